@@ -15,7 +15,19 @@ type (
 		Name        string
 		MatchCount  int
 		IsDeleted   bool
+		DeletedAt   *time.Time
 		CreatedAt   time.Time
+		// sortKey is the value the cat has been sorted by in a search, as text
+		sortKey string
+	}
+
+	// CatMatch is the current match of a cat, it is provided by the match package
+	CatMatch struct {
+		ID             string
+		Status         string
+		IssuerUserID   string
+		ReceiverUserID string
+		CreatedAt      time.Time
 	}
 )
 
@@ -33,3 +45,13 @@ var (
 		"Birman",
 	}
 )
+
+// AuthorizeOwner is the ownership policy for cats, only the user who owns
+// the cat is allowed to act on it. It returns ErrUserDoesNotOwnCat otherwise.
+func AuthorizeOwner(c Cat, userID string) error {
+	if userID == "" || c.UserID != userID {
+		return ErrUserDoesNotOwnCat
+	}
+
+	return nil
+}
@@ -8,6 +8,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
 	"net/http"
 	"net/url"
 	"slices"
@@ -19,18 +20,35 @@ import (
 type (
 	svc interface {
 		Create(ctx context.Context, args CreateArgs) (Cat, error)
-		Search(ctx context.Context, args SearchArgs) ([]Cat, error)
-		Update(ctx context.Context, args UpdateArgs) error
-		Delete(ctx context.Context, id int) error
+		Search(ctx context.Context, args SearchArgs) (SearchResult, error)
+		GetOneByID(ctx context.Context, args GetOneByIDArgs) (Cat, error)
+		Update(ctx context.Context, args UpdateArgs) ([]Cat, error)
+		Delete(ctx context.Context, args DeleteArgs) error
+		ListDeleted(ctx context.Context, args ListDeletedArgs) ([]Cat, error)
+		Restore(ctx context.Context, args RestoreArgs) error
+		Purge(ctx context.Context, args PurgeArgs) error
+		RestorableUntil(c Cat) time.Time
+	}
+
+	ownerSvc interface {
+		GetProfile(ctx context.Context, userID string) (user.User, error)
+	}
+
+	matchSvc interface {
+		GetCatMatch(ctx context.Context, catID int) (*CatMatch, error)
 	}
 
 	Controller struct {
-		s svc
+		s       svc
+		owners  ownerSvc
+		matches matchSvc
 	}
 )
 
-func NewController(s svc) Controller {
-	return Controller{s}
+// NewController creates the cat controller, owners and matches are only used
+// to embed the owner and the match of a cat into GetHandler responses
+func NewController(s svc, owners ownerSvc, matches matchSvc) Controller {
+	return Controller{s: s, owners: owners, matches: matches}
 }
 
 type CreateReqBody struct {
@@ -43,45 +61,128 @@ type CreateReqBody struct {
 }
 
 func (c CreateReqBody) Validate() bool {
-	// name min length 1 and max length 30
-	if len(c.Name) < 1 || len(c.Name) > 30 {
+	return isValidName(c.Name) &&
+		isValidRace(c.Race) &&
+		isValidSex(c.Sex) &&
+		isValidAgeInMonth(c.AgeInMonth) &&
+		isValidDescription(c.Description) &&
+		isValidImageURLs(c.ImageURLs)
+}
+
+// name min length 1 and max length 30
+func isValidName(name string) bool {
+	return len(name) >= 1 && len(name) <= 30
+}
+
+// must be valid race
+func isValidRace(race string) bool {
+	return slices.Contains(races, race)
+}
+
+// sex is either male or female
+func isValidSex(sex string) bool {
+	return sex == "male" || sex == "female"
+}
+
+// age in month min 1 and max 120082
+func isValidAgeInMonth(ageInMonth int) bool {
+	return ageInMonth >= 1 && ageInMonth <= 120082
+}
+
+// description min length 1 and max length 200
+func isValidDescription(description string) bool {
+	return len(description) >= 1 && len(description) <= 200
+}
+
+// imageUrls min item is 1 and should contain only valid url
+func isValidImageURLs(imageURLs []string) bool {
+	if len(imageURLs) < 1 {
 		return false
 	}
 
-	// must be valid race
-	if !slices.Contains(races, c.Race) {
-		return false
+	for _, str := range imageURLs {
+		u, err := url.Parse(str)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return false
+		}
 	}
 
-	// sex is either male or female
-	if c.Sex != "male" && c.Sex != "female" {
-		return false
+	return true
+}
+
+// PatchReqBody is a JSON Merge Patch (RFC 7396) of a cat, the fields that are
+// missing are left as they are. Every field of a cat is required, so a field
+// could not be removed with null.
+type PatchReqBody struct {
+	Race        *string
+	Sex         *string
+	Name        *string
+	AgeInMonth  *int
+	Description *string
+	ImageURLs   *[]string
+}
+
+func (p *PatchReqBody) UnmarshalJSON(data []byte) error {
+	var members map[string]json.RawMessage
+	err := json.Unmarshal(data, &members)
+	if err != nil {
+		return err
+	}
+	if members == nil {
+		return errors.New("merge patch must be an object")
 	}
 
-	// age in month min 1 and max 120082
-	if c.AgeInMonth < 1 || c.AgeInMonth > 120082 {
-		return false
+	for name, value := range members {
+		if string(value) == "null" {
+			return fmt.Errorf("%s could not be removed", name)
+		}
+
+		var dst any
+		switch name {
+		case "race":
+			dst = &p.Race
+		case "sex":
+			dst = &p.Sex
+		case "name":
+			dst = &p.Name
+		case "ageInMonth":
+			dst = &p.AgeInMonth
+		case "description":
+			dst = &p.Description
+		case "imageUrls":
+			dst = &p.ImageURLs
+		default:
+			return fmt.Errorf("unknown field %s", name)
+		}
+
+		err = json.Unmarshal(value, dst)
+		if err != nil {
+			return err
+		}
 	}
 
-	// description min length 1 and max length 200
-	if len(c.Description) < 1 || len(c.Description) > 200 {
+	return nil
+}
+
+// Validate only validates the fields that are present
+func (p PatchReqBody) Validate() bool {
+	if p.Name != nil && !isValidName(*p.Name) {
 		return false
 	}
-
-	// imageUrls min item is 1
-	if len(c.ImageURLs) < 1 {
+	if p.Race != nil && !isValidRace(*p.Race) {
 		return false
 	}
-
-	// imageUrls should contain only valid url
-	isUrl := func(str string) bool {
-		u, err := url.Parse(str)
-		return err == nil && u.Scheme != "" && u.Host != ""
+	if p.Sex != nil && !isValidSex(*p.Sex) {
+		return false
 	}
-	for _, url := range c.ImageURLs {
-		if !isUrl(url) {
-			return false
-		}
+	if p.AgeInMonth != nil && !isValidAgeInMonth(*p.AgeInMonth) {
+		return false
+	}
+	if p.Description != nil && !isValidDescription(*p.Description) {
+		return false
+	}
+	if p.ImageURLs != nil && !isValidImageURLs(*p.ImageURLs) {
+		return false
 	}
 
 	return true
@@ -137,18 +238,93 @@ func (c Controller) CreateHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write(respBody)
 }
 
+// SearchQueries are the raw queries of a search. Race, sex and ageInMonth could be
+// repeated or comma separated, every age bound is combined with the others.
 type SearchQueries struct {
 	id         string
 	limit      string
 	offset     string
-	race       string
-	sex        string
+	cursor     string
+	sort       string
+	race       []string
+	sex        []string
 	hasMatched string
-	ageInMonth string
+	ageInMonth []string
 	owned      string
 	search     string
 }
 
+// Validate returns a SearchQueriesError listing every invalid query,
+// the other methods expect the queries to be valid
+func (s SearchQueries) Validate() error {
+	var problems []string
+
+	if s.id != "" {
+		_, err := strconv.Atoi(s.id)
+		if err != nil {
+			problems = append(problems, "id must be a number")
+		}
+	}
+
+	if s.limit != "" {
+		l, err := strconv.Atoi(s.limit)
+		if err != nil || l < 0 {
+			problems = append(problems, "limit must be a number of at least 0")
+		}
+	}
+
+	if s.offset != "" {
+		o, err := strconv.Atoi(s.offset)
+		if err != nil || o < 0 {
+			problems = append(problems, "offset must be a number of at least 0")
+		}
+	}
+
+	_, err := s.Cursor()
+	if err != nil {
+		problems = append(problems, err.Error())
+	}
+
+	_, err = s.Sort()
+	if err != nil {
+		fields := make([]string, 0, len(sortFields))
+		for _, field := range sortFields {
+			fields = append(fields, string(field))
+		}
+		problems = append(problems, fmt.Sprintf("sort must be one of %s, prefixed with - for the descending order",
+			strings.Join(fields, ", ")))
+	}
+
+	for _, race := range s.Race() {
+		if !isValidRace(race) {
+			problems = append(problems, fmt.Sprintf("race %q must be one of %s", race, strings.Join(races, ", ")))
+		}
+	}
+
+	for _, sex := range s.Sex() {
+		if !isValidSex(sex) {
+			problems = append(problems, fmt.Sprintf("sex %q must be male or female", sex))
+		}
+	}
+
+	if s.hasMatched != "" && s.hasMatched != "true" && s.hasMatched != "false" {
+		problems = append(problems, "hasMatched must be true or false")
+	}
+
+	if s.owned != "" && s.owned != "true" && s.owned != "false" {
+		problems = append(problems, "owned must be true or false")
+	}
+
+	_, ageProblems := s.ageBounds()
+	problems = append(problems, ageProblems...)
+
+	if len(problems) > 0 {
+		return SearchQueriesError{Problems: problems}
+	}
+
+	return nil
+}
+
 func (s SearchQueries) ID() *string {
 	if s.id == "" {
 		return nil
@@ -182,24 +358,45 @@ func (s SearchQueries) Offset() *int {
 	return &o
 }
 
-func (s SearchQueries) Race() *string {
-	if s.race == "" {
-		return nil
+// Cursor returns ErrInvalidCursor when the cursor has not been made by a search,
+// or when it is combined with an offset
+func (s SearchQueries) Cursor() (*Cursor, error) {
+	if s.cursor == "" {
+		return nil, nil
 	}
-	if !slices.Contains(races, s.race) {
-		return nil
+	if s.offset != "" {
+		return nil, fmt.Errorf("cursor could not be combined with offset: %w", ErrInvalidCursor)
 	}
-	return &s.race
+
+	c, err := DecodeCursor(s.cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	return &c, nil
 }
 
-func (s SearchQueries) Sex() *string {
-	if s.sex == "" {
-		return nil
+// Sort returns ErrInvalidSort when the field is not one of the sort fields,
+// a leading - sorts in the descending order
+func (s SearchQueries) Sort() (*Sort, error) {
+	if s.sort == "" {
+		return nil, nil
 	}
-	if s.sex != "male" && s.sex != "female" {
-		return nil
+
+	sort, err := ParseSort(s.sort)
+	if err != nil {
+		return nil, err
 	}
-	return &s.sex
+
+	return &sort, nil
+}
+
+func (s SearchQueries) Race() []string {
+	return splitQueryValues(s.race)
+}
+
+func (s SearchQueries) Sex() []string {
+	return splitQueryValues(s.sex)
 }
 
 func (s SearchQueries) HasMatched() *bool {
@@ -217,43 +414,76 @@ func (s SearchQueries) HasMatched() *bool {
 	return nil
 }
 
-func (s SearchQueries) AgeInMonthGreaterThan() *int {
-	if s.ageInMonth == "" {
-		return nil
-	}
-	if strings.HasPrefix(s.ageInMonth, ">") {
-		a, err := strconv.Atoi(strings.TrimPrefix(s.ageInMonth, ">"))
+type ageBounds struct {
+	greaterThan *int
+	lessThan    *int
+	equal       *int
+}
+
+// ageBounds combines every bound of ageInMonth, a bound is >N, >=N, <N, <=N, =N or N.
+// The inclusive bounds are turned into exclusive ones since ages are whole months.
+func (s SearchQueries) ageBounds() (ageBounds, []string) {
+	var (
+		b        ageBounds
+		problems []string
+	)
+
+	for _, bound := range splitQueryValues(s.ageInMonth) {
+		op := strings.TrimRight(bound, "0123456789")
+		// ages are a postgres int, so a bound must fit into one
+		n64, err := strconv.ParseInt(strings.TrimPrefix(bound, op), 10, 32)
 		if err != nil {
-			return nil
+			problems = append(problems, fmt.Sprintf("ageInMonth %q must be a number prefixed with >, >=, <, <= or =", bound))
+			continue
+		}
+		n := int(n64)
+
+		switch op {
+		case ">", ">=":
+			if op == ">=" {
+				n--
+			}
+			if b.greaterThan == nil || n > *b.greaterThan {
+				b.greaterThan = &n
+			}
+		case "<", "<=":
+			// every age is at most the largest int, which leaves nothing to bound
+			if op == "<=" && n == math.MaxInt32 {
+				continue
+			}
+			if op == "<=" {
+				n++
+			}
+			if b.lessThan == nil || n < *b.lessThan {
+				b.lessThan = &n
+			}
+		case "=", "":
+			if b.equal != nil && *b.equal != n {
+				problems = append(problems, "ageInMonth could only have one exact age")
+				continue
+			}
+			b.equal = &n
+		default:
+			problems = append(problems, fmt.Sprintf("ageInMonth %q must be a number prefixed with >, >=, <, <= or =", bound))
 		}
-		return &a
 	}
-	return nil
+
+	return b, problems
+}
+
+func (s SearchQueries) AgeInMonthGreaterThan() *int {
+	b, _ := s.ageBounds()
+	return b.greaterThan
 }
 
 func (s SearchQueries) AgeInMonthLessThan() *int {
-	if s.ageInMonth == "" {
-		return nil
-	}
-	if strings.HasPrefix(s.ageInMonth, "<") {
-		a, err := strconv.Atoi(strings.TrimPrefix(s.ageInMonth, "<"))
-		if err != nil {
-			return nil
-		}
-		return &a
-	}
-	return nil
+	b, _ := s.ageBounds()
+	return b.lessThan
 }
 
 func (s SearchQueries) AgeInMonth() *int {
-	if s.ageInMonth == "" {
-		return nil
-	}
-	a, err := strconv.Atoi(strings.TrimPrefix(s.ageInMonth, "="))
-	if err != nil {
-		return nil
-	}
-	return &a
+	b, _ := s.ageBounds()
+	return b.equal
 }
 
 func (s SearchQueries) UserID(userID string) *string {
@@ -283,6 +513,21 @@ func (s SearchQueries) NameQuery() *string {
 	return &s.search
 }
 
+// splitQueryValues returns the comma separated values of repeated queries
+func splitQueryValues(queries []string) []string {
+	var values []string
+	for _, q := range queries {
+		for _, v := range strings.Split(q, ",") {
+			v = strings.TrimSpace(v)
+			if v != "" {
+				values = append(values, v)
+			}
+		}
+	}
+
+	return values
+}
+
 type SearchRespItem struct {
 	ID          string   `json:"id"`
 	Name        string   `json:"name"`
@@ -301,10 +546,12 @@ func (c Controller) SearchHandler(w http.ResponseWriter, r *http.Request) {
 		id:         queries.Get("id"),
 		limit:      queries.Get("limit"),
 		offset:     queries.Get("offset"),
-		race:       queries.Get("race"),
-		sex:        queries.Get("sex"),
+		cursor:     queries.Get("cursor"),
+		sort:       queries.Get("sort"),
+		race:       queries["race"],
+		sex:        queries["sex"],
 		hasMatched: queries.Get("hasMatched"),
-		ageInMonth: queries.Get("ageInMonth"),
+		ageInMonth: queries["ageInMonth"],
 		owned:      queries.Get("owned"),
 		search:     queries.Get("search"),
 	}
@@ -315,12 +562,22 @@ func (c Controller) SearchHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	cats, err := c.s.Search(r.Context(), SearchArgs{
+	err := sq.Validate()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	cursor, _ := sq.Cursor()
+	sort, _ := sq.Sort()
+
+	result, err := c.s.Search(r.Context(), SearchArgs{
 		ID:                    sq.ID(),
 		Limit:                 sq.Limit(),
 		Offset:                sq.Offset(),
-		Race:                  sq.Race(),
-		Sex:                   sq.Sex(),
+		Cursor:                cursor,
+		Sort:                  sort,
+		Races:                 sq.Race(),
+		Sexes:                 sq.Sex(),
 		HasMatched:            sq.HasMatched(),
 		AgeInMonthGreaterThan: sq.AgeInMonthGreaterThan(),
 		AgeInMonthLessThan:    sq.AgeInMonthLessThan(),
@@ -329,13 +586,17 @@ func (c Controller) SearchHandler(w http.ResponseWriter, r *http.Request) {
 		ExcludeUserID:         sq.ExcludeUserID(userID),
 		NameQuery:             sq.NameQuery(),
 	})
+	if errors.Is(err, ErrInvalidCursor) || errors.Is(err, ErrInvalidSort) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	items := make([]SearchRespItem, 0)
-	for _, c := range cats {
+	for _, c := range result.Cats {
 		items = append(items, SearchRespItem{
 			ID:          strconv.Itoa(c.ID),
 			Name:        c.Name,
@@ -349,8 +610,16 @@ func (c Controller) SearchHandler(w http.ResponseWriter, r *http.Request) {
 		})
 	}
 
+	var pagination web.Pagination
+	if result.Next != nil {
+		pagination.Next = pointer.Pointer(result.Next.Encode())
+	}
+	if result.Prev != nil {
+		pagination.Prev = pointer.Pointer(result.Prev.Encode())
+	}
+
 	w.Header().Set("Content-Type", "application/json")
-	respBody, err := json.Marshal(web.NewResTemplate("success", items))
+	respBody, err := json.Marshal(web.NewPaginatedResTemplate("success", items, pagination))
 	if err != nil {
 		http.Error(w, fmt.Sprintf("decoding cats into json: %s", err.Error()), http.StatusInternalServerError)
 		return
@@ -359,6 +628,122 @@ func (c Controller) SearchHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write(respBody)
 }
 
+const (
+	includeOwner = "owner"
+	includeMatch = "match"
+)
+
+type OwnerResp struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type CatMatchResp struct {
+	// ID is only shown to the users of the match
+	ID     *string `json:"id,omitempty"`
+	Status string  `json:"status"`
+}
+
+type GetResp struct {
+	SearchRespItem
+	Owner *OwnerResp    `json:"owner,omitempty"`
+	Match *CatMatchResp `json:"match,omitempty"`
+}
+
+// GetHandler returns a single cat, ?include=owner,match embeds the public profile of
+// its owner and its current match, whose status is none when the cat has no match
+func (c Controller) GetHandler(w http.ResponseWriter, r *http.Request) {
+	intCatID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "cat id is not found", http.StatusNotFound)
+		return
+	}
+
+	var withOwner, withMatch bool
+	if include := r.URL.Query().Get("include"); include != "" {
+		for _, field := range strings.Split(include, ",") {
+			switch strings.TrimSpace(field) {
+			case includeOwner:
+				withOwner = true
+			case includeMatch:
+				withMatch = true
+			default:
+				http.Error(w, fmt.Sprintf("include could only contain %s and %s", includeOwner, includeMatch), http.StatusBadRequest)
+				return
+			}
+		}
+	}
+
+	userID, ok := user.UserIDFromContext(r.Context())
+	if !ok || userID == "" {
+		http.Error(w, "invalid access token", http.StatusInternalServerError)
+		return
+	}
+
+	cat, err := c.s.GetOneByID(r.Context(), GetOneByIDArgs{ID: strconv.Itoa(intCatID)})
+	if errors.Is(err, ErrCatNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	resp := GetResp{
+		SearchRespItem: SearchRespItem{
+			ID:          strconv.Itoa(cat.ID),
+			Name:        cat.Name,
+			Race:        cat.Race,
+			Sex:         cat.Sex,
+			AgeInMonth:  cat.AgeInMonth,
+			ImageURLs:   cat.ImageURLs,
+			Description: cat.Description,
+			HasMatched:  cat.HasMatched || cat.MatchCount > 0,
+			CreatedAt:   cat.CreatedAt.Format(time.RFC3339),
+		},
+	}
+
+	if withOwner {
+		owner, err := c.owners.GetProfile(r.Context(), cat.UserID)
+		if err != nil && !errors.Is(err, user.ErrUserNotFound) {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err == nil {
+			resp.Owner = &OwnerResp{
+				ID:   strconv.Itoa(owner.ID),
+				Name: owner.Name,
+			}
+		}
+	}
+
+	if withMatch {
+		m, err := c.matches.GetCatMatch(r.Context(), cat.ID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		resp.Match = &CatMatchResp{Status: "none"}
+		if m != nil {
+			resp.Match.Status = m.Status
+			if userID == m.IssuerUserID || userID == m.ReceiverUserID {
+				resp.Match.ID = &m.ID
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	respBody, err := json.Marshal(web.NewResTemplate("success", resp))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("decoding cat into json: %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(respBody)
+}
+
 func (c Controller) UpdateHandler(w http.ResponseWriter, r *http.Request) {
 	catID := r.PathValue("id")
 	if catID == "" {
@@ -388,8 +773,9 @@ func (c Controller) UpdateHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = c.s.Update(r.Context(), UpdateArgs{
+	_, err = c.s.Update(r.Context(), UpdateArgs{
 		IDs:         []int{intCatID},
+		UserID:      userID,
 		Race:        &reqBody.Race,
 		Sex:         &reqBody.Sex,
 		Name:        &reqBody.Name,
@@ -401,6 +787,10 @@ func (c Controller) UpdateHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, err.Error(), http.StatusNotFound)
 		return
 	}
+	if errors.Is(err, ErrUserDoesNotOwnCat) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
 	if errors.Is(err, ErrCatSexEditedAfterMatchRequested) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
@@ -413,6 +803,90 @@ func (c Controller) UpdateHandler(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
+// PatchHandler applies a JSON Merge Patch to the cat and responds with the updated cat
+func (c Controller) PatchHandler(w http.ResponseWriter, r *http.Request) {
+	intCatID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "cat id is not found", http.StatusNotFound)
+		return
+	}
+
+	contentType, _, _ := strings.Cut(r.Header.Get("Content-Type"), ";")
+	contentType = strings.TrimSpace(contentType)
+	if contentType != "" && contentType != "application/merge-patch+json" && contentType != "application/json" {
+		http.Error(w, "content type must be application/merge-patch+json", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	reqBody, err := web.DecodeReqBody[PatchReqBody](r.Body)
+	if errors.Is(err, web.ErrInvalidReqBody) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	userID, ok := user.UserIDFromContext(r.Context())
+	if !ok || userID == "" {
+		http.Error(w, "invalid access token", http.StatusInternalServerError)
+		return
+	}
+
+	var imageURLs []string
+	if reqBody.ImageURLs != nil {
+		imageURLs = *reqBody.ImageURLs
+	}
+	cats, err := c.s.Update(r.Context(), UpdateArgs{
+		IDs:         []int{intCatID},
+		UserID:      userID,
+		Race:        reqBody.Race,
+		Sex:         reqBody.Sex,
+		Name:        reqBody.Name,
+		AgeInMonth:  reqBody.AgeInMonth,
+		Description: reqBody.Description,
+		ImageURLs:   imageURLs,
+	})
+	if errors.Is(err, ErrCatNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, ErrUserDoesNotOwnCat) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if errors.Is(err, ErrCatSexEditedAfterMatchRequested) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	cat := cats[0]
+	resp := SearchRespItem{
+		ID:          strconv.Itoa(cat.ID),
+		Name:        cat.Name,
+		Race:        cat.Race,
+		Sex:         cat.Sex,
+		AgeInMonth:  cat.AgeInMonth,
+		ImageURLs:   cat.ImageURLs,
+		Description: cat.Description,
+		HasMatched:  cat.HasMatched || cat.MatchCount > 0,
+		CreatedAt:   cat.CreatedAt.Format(time.RFC3339),
+	}
+	w.Header().Set("Content-Type", "application/json")
+	respBody, err := json.Marshal(web.NewResTemplate("success", resp))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("decoding cat into json: %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(respBody)
+}
+
 func (c Controller) DeleteHandler(w http.ResponseWriter, r *http.Request) {
 	catID := r.PathValue("id")
 	if catID == "" {
@@ -432,11 +906,183 @@ func (c Controller) DeleteHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = c.s.Delete(r.Context(), intCatID)
+	err = c.s.Delete(r.Context(), DeleteArgs{
+		ID:     intCatID,
+		UserID: userID,
+	})
+	if errors.Is(err, ErrCatNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, ErrUserDoesNotOwnCat) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// ForceDeleteHandler deletes any cat regardless of its owner, it is meant for admins
+func (c Controller) ForceDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	intCatID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "cat id is not found", http.StatusNotFound)
+		return
+	}
+
+	err = c.s.Delete(r.Context(), DeleteArgs{
+		ID:    intCatID,
+		Force: true,
+	})
+	if errors.Is(err, ErrCatNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+type TrashRespItem struct {
+	SearchRespItem
+	DeletedAt       string `json:"deletedAt"`
+	RestorableUntil string `json:"restorableUntil"`
+}
+
+// ListDeletedHandler lists the cats of the user that are in the trash,
+// it is paginated with limit and offset like SearchHandler
+func (c Controller) ListDeletedHandler(w http.ResponseWriter, r *http.Request) {
+	queries := r.URL.Query()
+	sq := SearchQueries{
+		limit:  queries.Get("limit"),
+		offset: queries.Get("offset"),
+	}
+
+	err := sq.Validate()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	userID, ok := user.UserIDFromContext(r.Context())
+	if !ok || userID == "" {
+		http.Error(w, "invalid access token", http.StatusInternalServerError)
+		return
+	}
+
+	cats, err := c.s.ListDeleted(r.Context(), ListDeletedArgs{
+		UserID: userID,
+		Limit:  sq.Limit(),
+		Offset: sq.Offset(),
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	items := make([]TrashRespItem, 0)
+	for _, cat := range cats {
+		deletedAt := ""
+		if cat.DeletedAt != nil {
+			deletedAt = cat.DeletedAt.Format(time.RFC3339)
+		}
+
+		items = append(items, TrashRespItem{
+			SearchRespItem: SearchRespItem{
+				ID:          strconv.Itoa(cat.ID),
+				Name:        cat.Name,
+				Race:        cat.Race,
+				Sex:         cat.Sex,
+				AgeInMonth:  cat.AgeInMonth,
+				ImageURLs:   cat.ImageURLs,
+				Description: cat.Description,
+				HasMatched:  cat.HasMatched || cat.MatchCount > 0,
+				CreatedAt:   cat.CreatedAt.Format(time.RFC3339),
+			},
+			DeletedAt:       deletedAt,
+			RestorableUntil: c.s.RestorableUntil(cat).Format(time.RFC3339),
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	respBody, err := json.Marshal(web.NewResTemplate("success", items))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("decoding cats into json: %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(respBody)
+}
+
+func (c Controller) RestoreHandler(w http.ResponseWriter, r *http.Request) {
+	intCatID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "cat id is not found", http.StatusNotFound)
+		return
+	}
+
+	userID, ok := user.UserIDFromContext(r.Context())
+	if !ok || userID == "" {
+		http.Error(w, "invalid access token", http.StatusInternalServerError)
+		return
+	}
+
+	err = c.s.Restore(r.Context(), RestoreArgs{
+		ID:     intCatID,
+		UserID: userID,
+	})
 	if errors.Is(err, ErrCatNotFound) {
 		http.Error(w, err.Error(), http.StatusNotFound)
 		return
 	}
+	if errors.Is(err, ErrCatNotDeleted) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if errors.Is(err, ErrRestoreWindowExpired) {
+		http.Error(w, err.Error(), http.StatusGone)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (c Controller) PurgeHandler(w http.ResponseWriter, r *http.Request) {
+	intCatID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "cat id is not found", http.StatusNotFound)
+		return
+	}
+
+	userID, ok := user.UserIDFromContext(r.Context())
+	if !ok || userID == "" {
+		http.Error(w, "invalid access token", http.StatusInternalServerError)
+		return
+	}
+
+	err = c.s.Purge(r.Context(), PurgeArgs{
+		ID:     intCatID,
+		UserID: userID,
+	})
+	if errors.Is(err, ErrCatNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, ErrCatNotDeleted) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
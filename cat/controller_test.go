@@ -0,0 +1,296 @@
+package cat
+
+import (
+	"catsocial/pkg/pointer"
+	"catsocial/user"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestPatchReqBodyUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		want    PatchReqBody
+		wantErr bool
+	}{
+		{name: "empty object", body: `{}`},
+		{
+			name: "partial patch",
+			body: `{"name":"Tom","ageInMonth":12}`,
+			want: PatchReqBody{Name: pointer.Pointer("Tom"), AgeInMonth: pointer.Pointer(12)},
+		},
+		{
+			name: "every member",
+			body: `{"race":"Persian","sex":"male","name":"Tom","ageInMonth":12,"description":"grey","imageUrls":["https://example.com/tom.png"]}`,
+			want: PatchReqBody{
+				Race:        pointer.Pointer("Persian"),
+				Sex:         pointer.Pointer("male"),
+				Name:        pointer.Pointer("Tom"),
+				AgeInMonth:  pointer.Pointer(12),
+				Description: pointer.Pointer("grey"),
+				ImageURLs:   &[]string{"https://example.com/tom.png"},
+			},
+		},
+		{name: "null member", body: `{"name":null}`, wantErr: true},
+		{name: "null member among others", body: `{"name":"Tom","description":null}`, wantErr: true},
+		{name: "unknown member", body: `{"owner":"1"}`, wantErr: true},
+		{name: "member of another case", body: `{"Name":"Tom"}`, wantErr: true},
+		{name: "member of the wrong type", body: `{"ageInMonth":"12"}`, wantErr: true},
+		{name: "null", body: `null`, wantErr: true},
+		{name: "array", body: `[{"name":"Tom"}]`, wantErr: true},
+		{name: "string", body: `"Tom"`, wantErr: true},
+		{name: "number", body: `12`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got PatchReqBody
+			err := json.Unmarshal([]byte(tt.body), &got)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Unmarshal() error = %v, want error %t", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("Unmarshal() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPatchReqBodyValidate(t *testing.T) {
+	tests := []struct {
+		name string
+		body PatchReqBody
+		want bool
+	}{
+		{name: "nothing to change", body: PatchReqBody{}, want: true},
+		{name: "valid name only", body: PatchReqBody{Name: pointer.Pointer("Tom")}, want: true},
+		{name: "valid age only", body: PatchReqBody{AgeInMonth: pointer.Pointer(12)}, want: true},
+		{name: "empty name", body: PatchReqBody{Name: pointer.Pointer("")}, want: false},
+		{name: "unknown race", body: PatchReqBody{Race: pointer.Pointer("Tiger")}, want: false},
+		{name: "unknown sex", body: PatchReqBody{Sex: pointer.Pointer("other")}, want: false},
+		{name: "age zero", body: PatchReqBody{AgeInMonth: pointer.Pointer(0)}, want: false},
+		{name: "empty description", body: PatchReqBody{Description: pointer.Pointer("")}, want: false},
+		{name: "no image urls", body: PatchReqBody{ImageURLs: &[]string{}}, want: false},
+		{name: "relative image url", body: PatchReqBody{ImageURLs: &[]string{"/tom.png"}}, want: false},
+		{name: "valid name with invalid sex", body: PatchReqBody{Name: pointer.Pointer("Tom"), Sex: pointer.Pointer("other")}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.body.Validate(); got != tt.want {
+				t.Fatalf("Validate() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+// getSvc returns a fixed cat, the profile of its owner and its match
+type getSvc struct {
+	svc
+	cat   Cat
+	match *CatMatch
+}
+
+func (s getSvc) GetOneByID(ctx context.Context, args GetOneByIDArgs) (Cat, error) {
+	if args.ID != strconv.Itoa(s.cat.ID) {
+		return Cat{}, ErrCatNotFound
+	}
+	return s.cat, nil
+}
+
+func (s getSvc) GetProfile(ctx context.Context, userID string) (user.User, error) {
+	return user.User{ID: 10, Name: "Tom Owner"}, nil
+}
+
+func (s getSvc) GetCatMatch(ctx context.Context, catID int) (*CatMatch, error) {
+	return s.match, nil
+}
+
+func TestGetHandlerInclude(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    string
+		userID  string
+		match   *CatMatch
+		want    int
+		wantRes string
+	}{
+		{name: "cat only", path: "/v1/cat/1", userID: "30", want: http.StatusOK, wantRes: `{}`},
+		{name: "unknown cat", path: "/v1/cat/2", userID: "30", want: http.StatusNotFound},
+		{name: "unknown include", path: "/v1/cat/1?include=owner,user", userID: "30", want: http.StatusBadRequest},
+		{
+			name: "owner", path: "/v1/cat/1?include=owner", userID: "30", want: http.StatusOK,
+			wantRes: `{"owner":{"id":"10","name":"Tom Owner"}}`,
+		},
+		{
+			name: "no match", path: "/v1/cat/1?include=match", userID: "30", want: http.StatusOK,
+			wantRes: `{"match":{"status":"none"}}`,
+		},
+		{
+			name: "match seen by another user", path: "/v1/cat/1?include=match", userID: "30", want: http.StatusOK,
+			match:   &CatMatch{ID: "5", Status: "pending", IssuerUserID: "10", ReceiverUserID: "20"},
+			wantRes: `{"match":{"status":"pending"}}`,
+		},
+		{
+			name: "match seen by its receiver", path: "/v1/cat/1?include=owner, match", userID: "20", want: http.StatusOK,
+			match:   &CatMatch{ID: "5", Status: "pending", IssuerUserID: "10", ReceiverUserID: "20"},
+			wantRes: `{"owner":{"id":"10","name":"Tom Owner"},"match":{"id":"5","status":"pending"}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := getSvc{cat: Cat{ID: 1, UserID: "10", Name: "Tom"}, match: tt.match}
+			c := NewController(s, s, s)
+
+			r := httptest.NewRequest(http.MethodGet, strings.ReplaceAll(tt.path, " ", "%20"), nil)
+			r.SetPathValue("id", strings.TrimPrefix(strings.SplitN(tt.path, "?", 2)[0], "/v1/cat/"))
+			r = r.WithContext(user.ContextWithUserID(r.Context(), tt.userID))
+			w := httptest.NewRecorder()
+
+			c.GetHandler(w, r)
+
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.want, w.Body.String())
+			}
+			if tt.want != http.StatusOK {
+				return
+			}
+
+			var res struct {
+				Data struct {
+					Owner *OwnerResp    `json:"owner,omitempty"`
+					Match *CatMatchResp `json:"match,omitempty"`
+				} `json:"data"`
+			}
+			err := json.Unmarshal(w.Body.Bytes(), &res)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, _ := json.Marshal(res.Data)
+			if string(got) != tt.wantRes {
+				t.Fatalf("embedded = %s, want %s", got, tt.wantRes)
+			}
+		})
+	}
+}
+
+func TestAgeBounds(t *testing.T) {
+	n := func(v int) *int { return &v }
+
+	tests := []struct {
+		name     string
+		bounds   []string
+		want     ageBounds
+		problems int
+	}{
+		{name: "none"},
+		{name: "greater than", bounds: []string{">4"}, want: ageBounds{greaterThan: n(4)}},
+		{name: "at least", bounds: []string{">=4"}, want: ageBounds{greaterThan: n(3)}},
+		{name: "less than", bounds: []string{"<4"}, want: ageBounds{lessThan: n(4)}},
+		{name: "at most", bounds: []string{"<=4"}, want: ageBounds{lessThan: n(5)}},
+		{name: "equal", bounds: []string{"=4"}, want: ageBounds{equal: n(4)}},
+		{name: "bare number", bounds: []string{"4"}, want: ageBounds{equal: n(4)}},
+		{name: "at least zero", bounds: []string{">=0"}, want: ageBounds{greaterThan: n(-1)}},
+		{name: "narrowest bounds win", bounds: []string{">2,>=6", "<20", "<=9"}, want: ageBounds{greaterThan: n(5), lessThan: n(10)}},
+		{name: "same exact age twice", bounds: []string{"4", "=4"}, want: ageBounds{equal: n(4)}},
+		{name: "largest int at most", bounds: []string{"<=2147483647"}},
+		{name: "largest int less than", bounds: []string{"<2147483647"}, want: ageBounds{lessThan: n(2147483647)}},
+		{name: "two exact ages", bounds: []string{"4", "5"}, want: ageBounds{equal: n(4)}, problems: 1},
+		{name: "int64 overflow", bounds: []string{"<=9223372036854775807"}, problems: 1},
+		{name: "int32 overflow", bounds: []string{">2147483648"}, problems: 1},
+		{name: "negative", bounds: []string{">-1"}, problems: 1},
+		{name: "unknown operator", bounds: []string{"!=4", "=>4", "<>4", "==4"}, problems: 4},
+		{name: "missing number", bounds: []string{">", ">=", "="}, problems: 3},
+		{name: "not a number", bounds: []string{"old", ">1.5", "4 months"}, problems: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, problems := SearchQueries{ageInMonth: tt.bounds}.ageBounds()
+			if len(problems) != tt.problems {
+				t.Fatalf("problems = %q, want %d", problems, tt.problems)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("ageBounds() = %s, want %s", formatAgeBounds(got), formatAgeBounds(tt.want))
+			}
+		})
+	}
+}
+
+// formatAgeBounds prints the bounds with the values behind their pointers
+func formatAgeBounds(b ageBounds) string {
+	format := func(p *int) string {
+		if p == nil {
+			return "nil"
+		}
+		return strconv.Itoa(*p)
+	}
+	return fmt.Sprintf("{greaterThan:%s lessThan:%s equal:%s}", format(b.greaterThan), format(b.lessThan), format(b.equal))
+}
+
+func TestSearchQueriesValidateRaceAndSex(t *testing.T) {
+	tests := []struct {
+		name      string
+		queries   SearchQueries
+		wantRaces []string
+		wantSexes []string
+		problems  int
+	}{
+		{name: "none"},
+		{
+			name:      "repeated",
+			queries:   SearchQueries{race: []string{"Persian", "Siamese"}, sex: []string{"male", "female"}},
+			wantRaces: []string{"Persian", "Siamese"},
+			wantSexes: []string{"male", "female"},
+		},
+		{
+			name:      "comma separated",
+			queries:   SearchQueries{race: []string{"Persian, Maine Coon"}, sex: []string{"male,female"}},
+			wantRaces: []string{"Persian", "Maine Coon"},
+			wantSexes: []string{"male", "female"},
+		},
+		{
+			name:      "empty values are left out",
+			queries:   SearchQueries{race: []string{"", "Persian,,"}, sex: []string{" , "}},
+			wantRaces: []string{"Persian"},
+		},
+		{
+			name:      "every invalid value is a problem",
+			queries:   SearchQueries{race: []string{"Tiger,Persian", "Lion"}, sex: []string{"male,other"}},
+			wantRaces: []string{"Tiger", "Persian", "Lion"},
+			wantSexes: []string{"male", "other"},
+			problems:  3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.queries.Validate()
+
+			var queriesErr SearchQueriesError
+			switch {
+			case tt.problems == 0 && err != nil:
+				t.Fatalf("Validate() = %v, want nil", err)
+			case tt.problems > 0 && !errors.As(err, &queriesErr):
+				t.Fatalf("Validate() = %v, want SearchQueriesError", err)
+			case tt.problems > 0 && len(queriesErr.Problems) != tt.problems:
+				t.Fatalf("problems = %q, want %d", queriesErr.Problems, tt.problems)
+			}
+			if tt.problems > 0 && !errors.Is(err, ErrInvalidSearchQueries) {
+				t.Fatalf("Validate() = %v, want it to wrap %v", err, ErrInvalidSearchQueries)
+			}
+
+			if got := tt.queries.Race(); !reflect.DeepEqual(got, tt.wantRaces) {
+				t.Fatalf("Race() = %q, want %q", got, tt.wantRaces)
+			}
+			if got := tt.queries.Sex(); !reflect.DeepEqual(got, tt.wantSexes) {
+				t.Fatalf("Sex() = %q, want %q", got, tt.wantSexes)
+			}
+		})
+	}
+}
@@ -0,0 +1,39 @@
+package cat
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+)
+
+// Cursor points at the cat a page of Search continues after, or before when Backward
+// is set. It is handed to clients as an opaque token made by Encode. Sort is the sort
+// the cursor has been made for and Key is the value the cat is sorted by.
+type Cursor struct {
+	ID       int    `json:"id"`
+	Backward bool   `json:"b,omitempty"`
+	Sort     string `json:"s,omitempty"`
+	Key      string `json:"k,omitempty"`
+}
+
+func (c Cursor) Encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// DecodeCursor parses a token made by Cursor.Encode, it returns ErrInvalidCursor
+// for anything else
+func DecodeCursor(token string) (Cursor, error) {
+	var c Cursor
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return c, fmt.Errorf("decode cursor: %w", ErrInvalidCursor)
+	}
+
+	err = json.Unmarshal(b, &c)
+	if err != nil || c.ID < 1 {
+		return c, fmt.Errorf("decode cursor: %w", ErrInvalidCursor)
+	}
+
+	return c, nil
+}
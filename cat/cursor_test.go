@@ -0,0 +1,52 @@
+package cat
+
+import (
+	"encoding/base64"
+	"errors"
+	"testing"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	tests := []Cursor{
+		{ID: 1},
+		{ID: 42, Backward: true},
+		{ID: 7, Sort: "-createdAt", Key: "2024-05-01 10:00:00.123456+07"},
+		{ID: 9, Sort: "name", Key: "Tom \"the\" Cat"},
+	}
+	for _, c := range tests {
+		got, err := DecodeCursor(c.Encode())
+		if err != nil {
+			t.Fatalf("DecodeCursor(%+v): %v", c, err)
+		}
+		if got != c {
+			t.Fatalf("DecodeCursor() = %+v, want %+v", got, c)
+		}
+	}
+}
+
+func TestDecodeCursorRejected(t *testing.T) {
+	encode := func(s string) string {
+		return base64.RawURLEncoding.EncodeToString([]byte(s))
+	}
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{name: "not base64", token: "not a cursor!"},
+		{name: "padded base64", token: base64.URLEncoding.EncodeToString([]byte(`{"id":1}`))},
+		{name: "not json", token: encode("id=1")},
+		{name: "not an object", token: encode(`[1]`)},
+		{name: "id of the wrong type", token: encode(`{"id":"1"}`)},
+		{name: "missing id", token: encode(`{"s":"name","k":"Tom"}`)},
+		{name: "id below one", token: encode(`{"id":-3}`)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := DecodeCursor(tt.token)
+			if !errors.Is(err, ErrInvalidCursor) {
+				t.Fatalf("DecodeCursor() error = %v, want %v", err, ErrInvalidCursor)
+			}
+		})
+	}
+}
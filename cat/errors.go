@@ -1,8 +1,30 @@
 package cat
 
-import "errors"
+import (
+	"errors"
+	"strings"
+)
 
 var (
 	ErrCatNotFound                     = errors.New("cat not found")
 	ErrCatSexEditedAfterMatchRequested = errors.New("cat sex edited after match has been requested")
+	ErrUserDoesNotOwnCat               = errors.New("user does not own cat")
+	ErrCatNotDeleted                   = errors.New("cat is not in the trash")
+	ErrRestoreWindowExpired            = errors.New("restore window of the cat has expired")
+	ErrInvalidCursor                   = errors.New("invalid cursor")
+	ErrInvalidSort                     = errors.New("invalid sort")
+	ErrInvalidSearchQueries            = errors.New("invalid search queries")
 )
+
+// SearchQueriesError lists every invalid query of a search, it wraps ErrInvalidSearchQueries
+type SearchQueriesError struct {
+	Problems []string
+}
+
+func (e SearchQueriesError) Error() string {
+	return ErrInvalidSearchQueries.Error() + ": " + strings.Join(e.Problems, "; ")
+}
+
+func (e SearchQueriesError) Unwrap() error {
+	return ErrInvalidSearchQueries
+}
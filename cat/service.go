@@ -5,7 +5,9 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"slices"
 	"strconv"
+	"time"
 )
 
 type (
@@ -15,20 +17,43 @@ type (
 		GetOneByID(ctx context.Context, args getOneByIDRepoArgs) (Cat, error)
 		GetByIDs(ctx context.Context, args getByIDsRepoArgs) ([]Cat, error)
 		Update(ctx context.Context, args UpdateRepoArgs) error
+		PurgeMatches(ctx context.Context, ids []int) ([]purgedMatch, error)
+		Purge(ctx context.Context, ids []int) error
 	}
 
 	trx interface {
 		WithTransaction(ctx context.Context, fn func(context.Context) error) error
 	}
 
+	// MatchWithdrawer withdraws the pending matches of a cat that is being deleted,
+	// it is implemented by the match package
+	MatchWithdrawer interface {
+		WithdrawCatMatches(ctx context.Context, catID int) error
+	}
+
+	// MatchWithdrawerFunc lets a function be used as a MatchWithdrawer
+	MatchWithdrawerFunc func(ctx context.Context, catID int) error
+
 	Service struct {
-		r   repo
-		trx trx
+		r             repo
+		trx           trx
+		restoreWindow time.Duration
+		matches       MatchWithdrawer
 	}
 )
 
-func NewService(r repo, trx trx) Service {
-	return Service{r: r, trx: trx}
+func (f MatchWithdrawerFunc) WithdrawCatMatches(ctx context.Context, catID int) error {
+	return f(ctx, catID)
+}
+
+// purgeBatchSize is the number of expired cats PurgeExpired deletes in one transaction
+const purgeBatchSize = 100
+
+// NewService creates the cat service, deleted cats could be restored within
+// restoreWindow and are purged by PurgeExpired after it. The pending matches
+// of a deleted cat are withdrawn through matches.
+func NewService(r repo, trx trx, restoreWindow time.Duration, matches MatchWithdrawer) Service {
+	return Service{r: r, trx: trx, restoreWindow: restoreWindow, matches: matches}
 }
 
 type CreateArgs struct {
@@ -57,8 +82,10 @@ type SearchArgs struct {
 	ID                    *string
 	Limit                 *int
 	Offset                *int
-	Race                  *string
-	Sex                   *string
+	Cursor                *Cursor
+	Sort                  *Sort
+	Races                 []string
+	Sexes                 []string
 	HasMatched            *bool
 	AgeInMonthGreaterThan *int
 	AgeInMonthLessThan    *int
@@ -68,13 +95,47 @@ type SearchArgs struct {
 	NameQuery             *string
 }
 
-func (s Service) Search(ctx context.Context, args SearchArgs) ([]Cat, error) {
-	return s.r.Search(ctx, searchRepoArgs{
+// SearchResult is a page of cats, Next and Prev point at the pages around it
+// and are nil when there is no such page
+type SearchResult struct {
+	Cats []Cat
+	Next *Cursor
+	Prev *Cursor
+}
+
+// Search returns a page of cats sorted by args.Sort, newest first by default. Pages could
+// be walked either with the offset or with the cursors of the result, which do not skip
+// or repeat cats that are created in between. A cursor only works with the sort it has
+// been made for, and sorting by relevance needs a name query.
+func (s Service) Search(ctx context.Context, args SearchArgs) (SearchResult, error) {
+	sort := ""
+	if args.Sort != nil {
+		sort = args.Sort.String()
+	}
+	if args.Cursor != nil && args.Cursor.Sort != sort {
+		return SearchResult{}, fmt.Errorf("search cats: cursor has been made for another sort: %w", ErrInvalidCursor)
+	}
+	if args.Cursor != nil && args.Sort != nil && !args.Sort.Field.validKey(args.Cursor.Key) {
+		return SearchResult{}, fmt.Errorf("search cats: cursor key does not fit sort %s: %w", sort, ErrInvalidCursor)
+	}
+	if args.Sort != nil && args.Sort.Field == SortRelevance && args.NameQuery == nil {
+		return SearchResult{}, fmt.Errorf("search cats: sort by relevance needs a name query: %w", ErrInvalidSort)
+	}
+
+	// one more cat tells whether there is a page after this one
+	limit := args.Limit
+	if limit != nil {
+		limit = pointer.Pointer(*limit + 1)
+	}
+
+	cats, err := s.r.Search(ctx, searchRepoArgs{
 		ID:                    args.ID,
-		Limit:                 args.Limit,
+		Limit:                 limit,
 		Offset:                args.Offset,
-		Race:                  args.Race,
-		Sex:                   args.Sex,
+		Cursor:                args.Cursor,
+		Sort:                  args.Sort,
+		Races:                 args.Races,
+		Sexes:                 args.Sexes,
 		HasMatched:            args.HasMatched,
 		AgeInMonthGreaterThan: args.AgeInMonthGreaterThan,
 		AgeInMonthLessThan:    args.AgeInMonthLessThan,
@@ -83,6 +144,39 @@ func (s Service) Search(ctx context.Context, args SearchArgs) ([]Cat, error) {
 		ExcludeUserID:         args.ExcludeUserID,
 		NameQuery:             args.NameQuery,
 	})
+	if err != nil {
+		return SearchResult{}, fmt.Errorf("search cats: %w", err)
+	}
+
+	backward := args.Cursor != nil && args.Cursor.Backward
+	hasMore := args.Limit != nil && len(cats) > *args.Limit
+	if hasMore {
+		cats = cats[:*args.Limit]
+	}
+	if backward {
+		slices.Reverse(cats)
+	}
+
+	result := SearchResult{Cats: cats}
+	if len(cats) == 0 {
+		return result, nil
+	}
+
+	// a backward page always comes from the page after it
+	hasNext := hasMore || backward
+	hasPrev := (backward && hasMore) ||
+		(args.Cursor != nil && !backward) ||
+		(args.Offset != nil && *args.Offset > 0)
+	if hasNext {
+		last := cats[len(cats)-1]
+		result.Next = &Cursor{ID: last.ID, Sort: sort, Key: last.sortKey}
+	}
+	if hasPrev {
+		first := cats[0]
+		result.Prev = &Cursor{ID: first.ID, Backward: true, Sort: sort, Key: first.sortKey}
+	}
+
+	return result, nil
 }
 
 type GetOneByIDArgs struct {
@@ -124,8 +218,19 @@ func (s Service) GetByIDs(ctx context.Context, args GetByIDsArgs) ([]Cat, error)
 	})
 }
 
+// everyCat reports whether fn holds for every cat
+func everyCat(cats []Cat, fn func(Cat) bool) bool {
+	for _, c := range cats {
+		if !fn(c) {
+			return false
+		}
+	}
+	return true
+}
+
 type UpdateArgs struct {
 	IDs           []int
+	UserID        string
 	HasMatched    *bool
 	Name          *string
 	Race          *string
@@ -138,7 +243,9 @@ type UpdateArgs struct {
 	MatchCount    *int
 }
 
-func (s Service) Update(ctx context.Context, args UpdateArgs) error {
+// Update returns the cats as they are once updated
+func (s Service) Update(ctx context.Context, args UpdateArgs) ([]Cat, error) {
+	var updated []Cat
 	err := s.trx.WithTransaction(ctx, func(ctx context.Context) error {
 		cats, err := s.r.GetByIDs(ctx, getByIDsRepoArgs{
 			IDs:       args.IDs,
@@ -150,6 +257,17 @@ func (s Service) Update(ctx context.Context, args UpdateArgs) error {
 		if err != nil {
 			return fmt.Errorf("get cat by ids: %w", err)
 		}
+		if len(cats) != len(args.IDs) {
+			return fmt.Errorf("get cat by ids: %w", ErrCatNotFound)
+		}
+
+		// only the owner could edit the cats
+		for _, cat := range cats {
+			err = AuthorizeOwner(cat, args.UserID)
+			if err != nil {
+				return err
+			}
+		}
 
 		// cat sex could not be edited after match has been requested
 		if args.Sex != nil {
@@ -160,32 +278,66 @@ func (s Service) Update(ctx context.Context, args UpdateArgs) error {
 			}
 		}
 
-		err = s.r.Update(ctx, UpdateRepoArgs{
-			IDs:         args.IDs,
-			Race:        args.Race,
-			Sex:         args.Sex,
-			Name:        args.Name,
-			AgeInMonth:  args.AgeInMonth,
-			Description: args.Description,
-			ImageURLs:   args.ImageURLs,
-		})
+		// only the columns that change are set, a field is left out when every cat
+		// already has its value
+		repoArgs := UpdateRepoArgs{IDs: args.IDs}
+		if args.Name != nil && !everyCat(cats, func(c Cat) bool { return c.Name == *args.Name }) {
+			repoArgs.Name = args.Name
+		}
+		if args.Race != nil && !everyCat(cats, func(c Cat) bool { return c.Race == *args.Race }) {
+			repoArgs.Race = args.Race
+		}
+		if args.Sex != nil && !everyCat(cats, func(c Cat) bool { return c.Sex == *args.Sex }) {
+			repoArgs.Sex = args.Sex
+		}
+		if args.AgeInMonth != nil && !everyCat(cats, func(c Cat) bool { return c.AgeInMonth == *args.AgeInMonth }) {
+			repoArgs.AgeInMonth = args.AgeInMonth
+		}
+		if args.Description != nil && !everyCat(cats, func(c Cat) bool { return c.Description == *args.Description }) {
+			repoArgs.Description = args.Description
+		}
+		if len(args.ImageURLs) > 0 && !everyCat(cats, func(c Cat) bool { return slices.Equal(c.ImageURLs, args.ImageURLs) }) {
+			repoArgs.ImageURLs = args.ImageURLs
+		}
+
+		// a patch that changes nothing has nothing to set
+		if repoArgs.Name == nil && repoArgs.Race == nil && repoArgs.Sex == nil && repoArgs.AgeInMonth == nil &&
+			repoArgs.Description == nil && len(repoArgs.ImageURLs) == 0 {
+			updated = cats
+			return nil
+		}
+
+		err = s.r.Update(ctx, repoArgs)
 		if err != nil {
 			return err
 		}
 
+		// the cats are still locked, so they are read back as this update has left them
+		updated, err = s.r.GetByIDs(ctx, getByIDsRepoArgs{IDs: args.IDs})
+		if err != nil {
+			return fmt.Errorf("get updated cats: %w", err)
+		}
+
 		return nil
 	})
 	if err != nil {
-		return fmt.Errorf("update cats: %w", err)
+		return nil, fmt.Errorf("update cats: %w", err)
 	}
 
-	return nil
+	return updated, nil
 }
 
-func (s Service) Delete(ctx context.Context, id int) error {
+type DeleteArgs struct {
+	ID     int
+	UserID string
+	// Force skips the ownership check, it is used by admins
+	Force bool
+}
+
+func (s Service) Delete(ctx context.Context, args DeleteArgs) error {
 	err := s.trx.WithTransaction(ctx, func(ctx context.Context) error {
-		_, err := s.r.GetOneByID(ctx, getOneByIDRepoArgs{
-			ID:        id,
+		cat, err := s.r.GetOneByID(ctx, getOneByIDRepoArgs{
+			ID:        args.ID,
 			ForUpdate: true,
 		})
 		if errors.Is(err, ErrCatNotFound) {
@@ -195,8 +347,21 @@ func (s Service) Delete(ctx context.Context, id int) error {
 			return fmt.Errorf("get cat by id: %w", err)
 		}
 
+		// only the owner could delete the cat, unless it is forced by an admin
+		if !args.Force {
+			err = AuthorizeOwner(cat, args.UserID)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = s.matches.WithdrawCatMatches(ctx, args.ID)
+		if err != nil {
+			return err
+		}
+
 		err = s.r.Update(ctx, UpdateRepoArgs{
-			IDs:       []int{id},
+			IDs:       []int{args.ID},
 			IsDeleted: pointer.Pointer(true),
 		})
 		if err != nil {
@@ -211,3 +376,298 @@ func (s Service) Delete(ctx context.Context, id int) error {
 
 	return nil
 }
+
+// RestorableUntil returns the time until which the deleted cat could be restored
+func (s Service) RestorableUntil(c Cat) time.Time {
+	if c.DeletedAt == nil {
+		return time.Time{}
+	}
+	return c.DeletedAt.Add(s.restoreWindow)
+}
+
+type ListDeletedArgs struct {
+	UserID string
+	Limit  *int
+	Offset *int
+}
+
+// ListDeleted returns the cats of the user that are in the trash
+func (s Service) ListDeleted(ctx context.Context, args ListDeletedArgs) ([]Cat, error) {
+	cats, err := s.r.Search(ctx, searchRepoArgs{
+		UserID:      &args.UserID,
+		OnlyDeleted: true,
+		Limit:       args.Limit,
+		Offset:      args.Offset,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list deleted cats: %w", err)
+	}
+
+	return cats, nil
+}
+
+type RestoreArgs struct {
+	ID     int
+	UserID string
+}
+
+// Restore takes the cat of the user out of the trash while its restore window is open
+func (s Service) Restore(ctx context.Context, args RestoreArgs) error {
+	err := s.trx.WithTransaction(ctx, func(ctx context.Context) error {
+		cat, err := s.getDeleted(ctx, args.ID, args.UserID)
+		if err != nil {
+			return err
+		}
+		if time.Now().After(s.RestorableUntil(cat)) {
+			return ErrRestoreWindowExpired
+		}
+
+		err = s.r.Update(ctx, UpdateRepoArgs{
+			IDs:       []int{args.ID},
+			IsDeleted: pointer.Pointer(false),
+		})
+		if err != nil {
+			return fmt.Errorf("update cat: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("restore cat: %w", err)
+	}
+
+	return nil
+}
+
+type PurgeArgs struct {
+	ID     int
+	UserID string
+}
+
+// Purge permanently deletes the cat of the user from the trash along with its matches
+func (s Service) Purge(ctx context.Context, args PurgeArgs) error {
+	err := s.trx.WithTransaction(ctx, func(ctx context.Context) error {
+		_, err := s.getDeleted(ctx, args.ID, args.UserID)
+		if err != nil {
+			return err
+		}
+
+		return s.purge(ctx, []int{args.ID})
+	})
+	if err != nil {
+		return fmt.Errorf("purge cat: %w", err)
+	}
+
+	return nil
+}
+
+// PurgeExpired permanently deletes the cats whose restore window has expired along
+// with their matches, and returns how many cats have been purged. It is meant to be
+// run periodically, the cats being restored meanwhile are left to the next run.
+func (s Service) PurgeExpired(ctx context.Context) (int, error) {
+	purged := 0
+	deletedBefore := time.Now().Add(-s.restoreWindow)
+	for {
+		var ids []int
+		err := s.trx.WithTransaction(ctx, func(ctx context.Context) error {
+			cats, err := s.r.Search(ctx, searchRepoArgs{
+				OnlyDeleted:   true,
+				DeletedBefore: &deletedBefore,
+				Limit:         pointer.Pointer(purgeBatchSize),
+				// a restore could not commit between the search and the purge
+				ForUpdateSkipLocked: true,
+			})
+			if err != nil {
+				return fmt.Errorf("search expired cats: %w", err)
+			}
+
+			for _, c := range cats {
+				ids = append(ids, c.ID)
+			}
+			if len(ids) == 0 {
+				return nil
+			}
+
+			return s.purge(ctx, ids)
+		})
+		if err != nil {
+			return purged, fmt.Errorf("purge expired cats: %w", err)
+		}
+
+		purged += len(ids)
+		if len(ids) < purgeBatchSize {
+			return purged, nil
+		}
+	}
+}
+
+// getDeleted returns the deleted cat of the user locked for update
+func (s Service) getDeleted(ctx context.Context, id int, userID string) (Cat, error) {
+	cat, err := s.r.GetOneByID(ctx, getOneByIDRepoArgs{
+		ID:             id,
+		ForUpdate:      true,
+		IncludeDeleted: true,
+	})
+	if errors.Is(err, ErrCatNotFound) {
+		return cat, fmt.Errorf("get cat by id: %w", ErrCatNotFound)
+	}
+	if err != nil {
+		return cat, fmt.Errorf("get cat by id: %w", err)
+	}
+
+	// the trash of other users is not leaked
+	if AuthorizeOwner(cat, userID) != nil {
+		return cat, ErrCatNotFound
+	}
+	if !cat.IsDeleted {
+		return cat, ErrCatNotDeleted
+	}
+
+	return cat, nil
+}
+
+func (s Service) purge(ctx context.Context, ids []int) error {
+	matches, err := s.r.PurgeMatches(ctx, ids)
+	if err != nil {
+		return fmt.Errorf("purge matches: %w", err)
+	}
+
+	for _, args := range counterpartUpdates(ids, matches) {
+		err = s.r.Update(ctx, args)
+		if err != nil {
+			return fmt.Errorf("update counterpart cats: %w", err)
+		}
+	}
+
+	err = s.r.Purge(ctx, ids)
+	if err != nil {
+		return fmt.Errorf("purge cats: %w", err)
+	}
+
+	return nil
+}
+
+// counterpartUpdates returns the updates the other cats of the purged matches need.
+// The cat of an approved match is not matched anymore, and a cat gives back one match
+// count for each of its pending matches.
+func counterpartUpdates(purgedIDs []int, matches []purgedMatch) []UpdateRepoArgs {
+	var (
+		unmatched []int
+		pending   = make(map[int]int)
+	)
+	for _, m := range matches {
+		catID := m.IssuerCatID
+		if slices.Contains(purgedIDs, catID) {
+			catID = m.ReceiverCatID
+		}
+		if slices.Contains(purgedIDs, catID) {
+			continue
+		}
+
+		switch m.Status {
+		case "approved":
+			unmatched = append(unmatched, catID)
+		case "pending":
+			pending[catID]++
+		}
+	}
+
+	var updates []UpdateRepoArgs
+	if len(unmatched) > 0 {
+		slices.Sort(unmatched)
+		unmatched = slices.Compact(unmatched)
+		updates = append(updates, UpdateRepoArgs{
+			IDs:        unmatched,
+			HasMatched: pointer.Pointer(false),
+			MatchCount: pointer.Pointer(0),
+		})
+	}
+
+	// the cats giving back the same count are updated together
+	byCount := make(map[int][]int)
+	for catID, n := range pending {
+		if !slices.Contains(unmatched, catID) {
+			byCount[n] = append(byCount[n], catID)
+		}
+	}
+	counts := make([]int, 0, len(byCount))
+	for n := range byCount {
+		counts = append(counts, n)
+	}
+	slices.Sort(counts)
+	for _, n := range counts {
+		slices.Sort(byCount[n])
+		updates = append(updates, UpdateRepoArgs{
+			IDs:           byCount[n],
+			IncMatchCount: pointer.Pointer(-n),
+		})
+	}
+
+	return updates
+}
+
+// DeleteUserData deletes every cat of the user the same way Delete does
+func (s Service) DeleteUserData(ctx context.Context, userID string) error {
+	err := s.trx.WithTransaction(ctx, func(ctx context.Context) error {
+		cats, err := s.r.Search(ctx, searchRepoArgs{UserID: &userID})
+		if err != nil {
+			return fmt.Errorf("search user cats: %w", err)
+		}
+
+		for _, c := range cats {
+			err = s.Delete(ctx, DeleteArgs{ID: c.ID, UserID: userID})
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("delete user cats: %w", err)
+	}
+
+	return nil
+}
+
+type ExportItem struct {
+	ID          string   `json:"id"`
+	Name        string   `json:"name"`
+	Race        string   `json:"race"`
+	Sex         string   `json:"sex"`
+	AgeInMonth  int      `json:"ageInMonth"`
+	ImageURLs   []string `json:"imageUrls"`
+	Description string   `json:"description"`
+	HasMatched  bool     `json:"hasMatched"`
+	IsDeleted   bool     `json:"isDeleted"`
+	CreatedAt   string   `json:"createdAt"`
+}
+
+// ExportUserData returns every cat of the user, including the deleted ones
+func (s Service) ExportUserData(ctx context.Context, userID string) (any, error) {
+	cats, err := s.r.Search(ctx, searchRepoArgs{
+		UserID:         &userID,
+		IncludeDeleted: true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("export user cats: %w", err)
+	}
+
+	items := make([]ExportItem, 0)
+	for _, c := range cats {
+		items = append(items, ExportItem{
+			ID:          strconv.Itoa(c.ID),
+			Name:        c.Name,
+			Race:        c.Race,
+			Sex:         c.Sex,
+			AgeInMonth:  c.AgeInMonth,
+			ImageURLs:   c.ImageURLs,
+			Description: c.Description,
+			HasMatched:  c.HasMatched || c.MatchCount > 0,
+			IsDeleted:   c.IsDeleted,
+			CreatedAt:   c.CreatedAt.Format(time.RFC3339),
+		})
+	}
+
+	return items, nil
+}
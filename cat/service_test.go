@@ -0,0 +1,198 @@
+package cat
+
+import (
+	"catsocial/pkg/pointer"
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type inlineTrx struct{}
+
+func (inlineTrx) WithTransaction(ctx context.Context, fn func(context.Context) error) error {
+	return fn(ctx)
+}
+
+// purgeRepo returns canned expired cats and purged matches, and records what the
+// purge does with them
+type purgeRepo struct {
+	repo
+	expired    []Cat
+	matches    []purgedMatch
+	searchArgs []searchRepoArgs
+	updates    []UpdateRepoArgs
+	purgedIDs  []int
+}
+
+func (r *purgeRepo) Search(ctx context.Context, args searchRepoArgs) ([]Cat, error) {
+	r.searchArgs = append(r.searchArgs, args)
+	return r.expired, nil
+}
+
+func (r *purgeRepo) PurgeMatches(ctx context.Context, ids []int) ([]purgedMatch, error) {
+	return r.matches, nil
+}
+
+func (r *purgeRepo) Update(ctx context.Context, args UpdateRepoArgs) error {
+	r.updates = append(r.updates, args)
+	return nil
+}
+
+func (r *purgeRepo) Purge(ctx context.Context, ids []int) error {
+	r.purgedIDs = append(r.purgedIDs, ids...)
+	return nil
+}
+
+func TestPurgeExpired(t *testing.T) {
+	tests := []struct {
+		name    string
+		matches []purgedMatch
+		want    []UpdateRepoArgs
+	}{
+		{
+			name:    "approved match",
+			matches: []purgedMatch{{IssuerCatID: 1, ReceiverCatID: 10, Status: "approved"}},
+			want:    []UpdateRepoArgs{{IDs: []int{10}, HasMatched: pointer.Pointer(false), MatchCount: pointer.Pointer(0)}},
+		},
+		{
+			name: "pending matches",
+			matches: []purgedMatch{
+				{IssuerCatID: 11, ReceiverCatID: 2, Status: "pending"},
+				{IssuerCatID: 1, ReceiverCatID: 12, Status: "pending"},
+				{IssuerCatID: 2, ReceiverCatID: 12, Status: "pending"},
+			},
+			want: []UpdateRepoArgs{
+				{IDs: []int{11}, IncMatchCount: pointer.Pointer(-1)},
+				{IDs: []int{12}, IncMatchCount: pointer.Pointer(-2)},
+			},
+		},
+		{
+			name: "finished matches and matches between purged cats",
+			matches: []purgedMatch{
+				{IssuerCatID: 1, ReceiverCatID: 2, Status: "approved"},
+				{IssuerCatID: 1, ReceiverCatID: 10, Status: "rejected"},
+				{IssuerCatID: 11, ReceiverCatID: 2, Status: "superseded"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &purgeRepo{expired: []Cat{{ID: 1}, {ID: 2}}, matches: tt.matches}
+			s := NewService(r, inlineTrx{}, 0, nil)
+
+			purged, err := s.PurgeExpired(context.Background())
+			if err != nil {
+				t.Fatalf("PurgeExpired(): %v", err)
+			}
+
+			if purged != 2 || !reflect.DeepEqual(r.purgedIDs, []int{1, 2}) {
+				t.Fatalf("purged = %d %v, want 2 [1 2]", purged, r.purgedIDs)
+			}
+			if len(r.searchArgs) != 1 || !r.searchArgs[0].ForUpdateSkipLocked {
+				t.Fatalf("search args = %+v, want the expired cats locked", r.searchArgs)
+			}
+			if !reflect.DeepEqual(r.updates, tt.want) {
+				t.Fatalf("counterpart updates = %s, want %s", formatUpdates(r.updates), formatUpdates(tt.want))
+			}
+		})
+	}
+}
+
+// formatUpdates prints the updates with the values behind their pointers
+func formatUpdates(updates []UpdateRepoArgs) string {
+	var b strings.Builder
+	for _, u := range updates {
+		fmt.Fprintf(&b, "{IDs:%v", u.IDs)
+		if u.HasMatched != nil {
+			fmt.Fprintf(&b, " HasMatched:%t", *u.HasMatched)
+		}
+		if u.MatchCount != nil {
+			fmt.Fprintf(&b, " MatchCount:%d", *u.MatchCount)
+		}
+		if u.IncMatchCount != nil {
+			fmt.Fprintf(&b, " IncMatchCount:%d", *u.IncMatchCount)
+		}
+		b.WriteString("}")
+	}
+	return "[" + b.String() + "]"
+}
+
+// memoryRepo keeps the cats in memory
+type memoryRepo struct {
+	repo
+	cats map[int]Cat
+}
+
+func (r *memoryRepo) GetByIDs(ctx context.Context, args getByIDsRepoArgs) ([]Cat, error) {
+	var cats []Cat
+	for _, id := range args.IDs {
+		if c, ok := r.cats[id]; ok {
+			cats = append(cats, c)
+		}
+	}
+	return cats, nil
+}
+
+func (r *memoryRepo) Update(ctx context.Context, args UpdateRepoArgs) error {
+	for _, id := range args.IDs {
+		c := r.cats[id]
+		if args.Name != nil {
+			c.Name = *args.Name
+		}
+		if args.Description != nil {
+			c.Description = *args.Description
+		}
+		r.cats[id] = c
+	}
+	return nil
+}
+
+func TestUpdateReturnsUpdatedCats(t *testing.T) {
+	r := &memoryRepo{cats: map[int]Cat{1: {ID: 1, UserID: "10", Name: "Tom", Description: "grey"}}}
+	s := NewService(r, inlineTrx{}, 0, nil)
+
+	cats, err := s.Update(context.Background(), UpdateArgs{IDs: []int{1}, UserID: "10", Name: pointer.Pointer("Thomas")})
+	if err != nil {
+		t.Fatalf("Update(): %v", err)
+	}
+
+	want := []Cat{{ID: 1, UserID: "10", Name: "Thomas", Description: "grey"}}
+	if !reflect.DeepEqual(cats, want) {
+		t.Fatalf("Update() = %+v, want %+v", cats, want)
+	}
+}
+
+func TestSearchRejectsMismatchedCursor(t *testing.T) {
+	byName := &Sort{Field: SortName}
+	byAge := &Sort{Field: SortAgeInMonth, Desc: true}
+	byCreatedAt := &Sort{Field: SortCreatedAt}
+
+	tests := []struct {
+		name   string
+		sort   *Sort
+		cursor Cursor
+	}{
+		{name: "sorted cursor without sort", cursor: Cursor{ID: 1, Sort: "name", Key: "Tom"}},
+		{name: "unsorted cursor with sort", sort: byName, cursor: Cursor{ID: 1}},
+		{name: "cursor of another sort", sort: byName, cursor: Cursor{ID: 1, Sort: "-name", Key: "Tom"}},
+		{name: "age key that is not a number", sort: byAge, cursor: Cursor{ID: 1, Sort: "-ageInMonth", Key: "1; drop table cats"}},
+		{name: "timestamp key that is not a timestamp", sort: byCreatedAt, cursor: Cursor{ID: 1, Sort: "createdAt", Key: "yesterday"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &purgeRepo{}
+			s := NewService(r, inlineTrx{}, 0, nil)
+
+			_, err := s.Search(context.Background(), SearchArgs{Sort: tt.sort, Cursor: &tt.cursor})
+			if !errors.Is(err, ErrInvalidCursor) {
+				t.Fatalf("Search() error = %v, want %v", err, ErrInvalidCursor)
+			}
+			if len(r.searchArgs) != 0 {
+				t.Fatalf("the repo has been searched with %+v", r.searchArgs)
+			}
+		})
+	}
+}
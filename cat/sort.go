@@ -0,0 +1,88 @@
+package cat
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+type (
+	// SortField is a field cats could be sorted by, only the fields in sortFields are allowed
+	SortField string
+
+	// Sort orders the cats of a search by Field, ties are broken by id in the same direction
+	Sort struct {
+		Field SortField
+		Desc  bool
+	}
+)
+
+const (
+	SortCreatedAt  SortField = "createdAt"
+	SortAgeInMonth SortField = "ageInMonth"
+	SortName       SortField = "name"
+	// SortRelevance sorts by how similar the name is to the name search
+	SortRelevance SortField = "relevance"
+)
+
+var (
+	sortFields = []SortField{SortCreatedAt, SortAgeInMonth, SortName, SortRelevance}
+
+	// timestampKeyLayouts are the ways postgres writes a timestamptz as text,
+	// the offset has minutes or seconds only when it is not a whole hour
+	timestampKeyLayouts = []string{
+		"2006-01-02 15:04:05.999999-07",
+		"2006-01-02 15:04:05.999999-07:00",
+		"2006-01-02 15:04:05.999999-07:00:00",
+	}
+	decimalKeyPattern = regexp.MustCompile(`^[+-]?(\d+\.?\d*|\.\d+)([eE][+-]?\d+)?$`)
+)
+
+// ParseSort parses a sort like ageInMonth, or -ageInMonth for the descending order
+func ParseSort(s string) (Sort, error) {
+	field, desc := strings.CutPrefix(s, "-")
+	if !slices.Contains(sortFields, SortField(field)) {
+		return Sort{}, fmt.Errorf("parse sort %q: %w", s, ErrInvalidSort)
+	}
+
+	return Sort{Field: SortField(field), Desc: desc}, nil
+}
+
+func (s Sort) String() string {
+	if s.Desc {
+		return "-" + string(s.Field)
+	}
+	return string(s.Field)
+}
+
+// validKey reports whether key, the sort value of a cursor, could be cast back into
+// the type of the field, so a tampered cursor is rejected before it reaches the database
+func (f SortField) validKey(key string) bool {
+	switch f {
+	case SortCreatedAt:
+		for _, layout := range timestampKeyLayouts {
+			if _, err := time.Parse(layout, key); err == nil {
+				return true
+			}
+		}
+		return false
+	case SortAgeInMonth:
+		_, err := strconv.ParseInt(key, 10, 32)
+		return err == nil
+	case SortName:
+		return utf8.ValidString(key) && !strings.ContainsRune(key, 0)
+	case SortRelevance:
+		if !decimalKeyPattern.MatchString(key) {
+			return false
+		}
+		v, err := strconv.ParseFloat(key, 32)
+		return err == nil && !math.IsNaN(v) && !math.IsInf(v, 0)
+	default:
+		return false
+	}
+}
@@ -0,0 +1,87 @@
+package cat
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseSort(t *testing.T) {
+	tests := []struct {
+		sort    string
+		want    Sort
+		wantErr error
+	}{
+		{sort: "createdAt", want: Sort{Field: SortCreatedAt}},
+		{sort: "-ageInMonth", want: Sort{Field: SortAgeInMonth, Desc: true}},
+		{sort: "name", want: Sort{Field: SortName}},
+		{sort: "-relevance", want: Sort{Field: SortRelevance, Desc: true}},
+		{sort: "", wantErr: ErrInvalidSort},
+		{sort: "-", wantErr: ErrInvalidSort},
+		{sort: "--name", wantErr: ErrInvalidSort},
+		{sort: "+name", wantErr: ErrInvalidSort},
+		{sort: "Name", wantErr: ErrInvalidSort},
+		{sort: "id", wantErr: ErrInvalidSort},
+		{sort: "name_normalized", wantErr: ErrInvalidSort},
+		{sort: "name; drop table cats", wantErr: ErrInvalidSort},
+	}
+	for _, tt := range tests {
+		t.Run(tt.sort, func(t *testing.T) {
+			got, err := ParseSort(tt.sort)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ParseSort() error = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Fatalf("ParseSort() = %+v, want %+v", got, tt.want)
+			}
+			if err == nil && got.String() != tt.sort {
+				t.Fatalf("String() = %q, want %q", got.String(), tt.sort)
+			}
+		})
+	}
+}
+
+func TestSortFieldValidKey(t *testing.T) {
+	tests := []struct {
+		field SortField
+		key   string
+		want  bool
+	}{
+		{field: SortCreatedAt, key: "2024-05-01 10:00:00.123456+07", want: true},
+		{field: SortCreatedAt, key: "2024-05-01 10:00:00+05:30", want: true},
+		{field: SortCreatedAt, key: "1900-01-01 00:00:00+07:07:12", want: true},
+		{field: SortCreatedAt, key: "2024-05-01T10:00:00Z", want: false},
+		{field: SortCreatedAt, key: "2024-05-01", want: false},
+		{field: SortCreatedAt, key: "", want: false},
+
+		{field: SortAgeInMonth, key: "12", want: true},
+		{field: SortAgeInMonth, key: "-1", want: true},
+		{field: SortAgeInMonth, key: "2147483647", want: true},
+		{field: SortAgeInMonth, key: "2147483648", want: false},
+		{field: SortAgeInMonth, key: "1.5", want: false},
+		{field: SortAgeInMonth, key: "12 or 1=1", want: false},
+
+		{field: SortName, key: "Tom", want: true},
+		{field: SortName, key: "", want: true},
+		{field: SortName, key: "Tom\x00", want: false},
+		{field: SortName, key: "\xff", want: false},
+
+		{field: SortRelevance, key: "0.5", want: true},
+		{field: SortRelevance, key: "1", want: true},
+		{field: SortRelevance, key: "1e-05", want: true},
+		{field: SortRelevance, key: ".25", want: true},
+		{field: SortRelevance, key: "NaN", want: false},
+		{field: SortRelevance, key: "Infinity", want: false},
+		{field: SortRelevance, key: "1e39", want: false},
+		{field: SortRelevance, key: "0x1p-2", want: false},
+		{field: SortRelevance, key: "", want: false},
+
+		{field: "id", key: "1", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.field)+" "+tt.key, func(t *testing.T) {
+			if got := tt.field.validKey(tt.key); got != tt.want {
+				t.Fatalf("validKey(%q) = %t, want %t", tt.key, got, tt.want)
+			}
+		})
+	}
+}
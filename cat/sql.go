@@ -5,6 +5,7 @@ import (
 	"context"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/jackc/pgx/v5"
 )
@@ -54,12 +55,25 @@ func (s SQL) Create(ctx context.Context, args createRepoArgs) (Cat, error) {
 	return c, nil
 }
 
+// sortColumns whitelists the sql expressions cats could be sorted by and the type their
+// keys are cast to when they come back from a cursor. The relevance expression takes
+// the name search as its parameter.
+var sortColumns = map[SortField]struct {
+	expr string
+	cast string
+}{
+	SortCreatedAt:  {expr: "created_at", cast: "timestamptz"},
+	SortAgeInMonth: {expr: "age_in_month", cast: "int"},
+	SortName:       {expr: "name_normalized", cast: "text"},
+	SortRelevance:  {expr: "similarity(name_normalized, lower($%d))", cast: "real"},
+}
+
 type searchRepoArgs struct {
 	ID                    *string
 	Limit                 *int
 	Offset                *int
-	Race                  *string
-	Sex                   *string
+	Races                 []string
+	Sexes                 []string
 	HasMatched            *bool
 	AgeInMonthGreaterThan *int
 	AgeInMonthLessThan    *int
@@ -68,6 +82,17 @@ type searchRepoArgs struct {
 	ExcludeUserID         *string
 	NameQuery             *string
 	IncludeDeleted        bool
+	// OnlyDeleted returns the cats in the trash, DeletedBefore narrows them down
+	OnlyDeleted   bool
+	DeletedBefore *time.Time
+	// Sort defaults to the id descending
+	Sort *Sort
+	// Cursor continues the search after the cat of the cursor, or before it
+	// when the cursor is backward, in which case the cats come in reverse order
+	Cursor *Cursor
+	// ForUpdateSkipLocked locks the cats found, leaving out the ones another
+	// transaction holds
+	ForUpdateSkipLocked bool
 }
 
 func (s SQL) Search(ctx context.Context, args searchRepoArgs) ([]Cat, error) {
@@ -80,28 +105,60 @@ func (s SQL) Search(ctx context.Context, args searchRepoArgs) ([]Cat, error) {
 		arg = 1
 	)
 
-	query.WriteString(`
+	sortExpr, sortCast, desc := "id", "int", true
+	if args.Sort != nil {
+		col, ok := sortColumns[args.Sort.Field]
+		if !ok {
+			return nil, fmt.Errorf("sql search cat: sort by %s: %w", args.Sort.Field, ErrInvalidSort)
+		}
+		sortExpr, sortCast, desc = col.expr, col.cast, args.Sort.Desc
+
+		if args.Sort.Field == SortRelevance {
+			if args.NameQuery == nil {
+				return nil, fmt.Errorf("sql search cat: sort by relevance without name query: %w", ErrInvalidSort)
+			}
+			sortExpr = fmt.Sprintf(col.expr, arg)
+			sqlArgs = append(sqlArgs, *args.NameQuery)
+			arg += 1
+		}
+	}
+
+	query.WriteString(fmt.Sprintf(`
 		select 
 			id, user_id, race, sex, name, age_in_month, match_count,
-			description, image_urls, has_matched, created_at
+			description, image_urls, has_matched, is_deleted, deleted_at, created_at,
+			(%s)::text
 		from cats
-	`)
+	`, sortExpr))
 
-	if !args.IncludeDeleted {
+	if args.OnlyDeleted {
+		whereQueries = append(whereQueries, fmt.Sprintf("is_deleted = $%d", arg))
+		sqlArgs = append(sqlArgs, true)
+		arg += 1
+	} else if !args.IncludeDeleted {
 		whereQueries = append(whereQueries, fmt.Sprintf("is_deleted = $%d", arg))
 		sqlArgs = append(sqlArgs, false)
 		arg += 1
 	}
 
+	if args.DeletedBefore != nil {
+		whereQueries = append(whereQueries, fmt.Sprintf("deleted_at < $%d", arg))
+		sqlArgs = append(sqlArgs, *args.DeletedBefore)
+		arg += 1
+	}
+
+	// the age bounds are combined, so an age range is both a lower and an upper bound
 	if args.AgeInMonth != nil {
 		whereQueries = append(whereQueries, fmt.Sprintf("age_in_month = $%d", arg))
 		sqlArgs = append(sqlArgs, *args.AgeInMonth)
 		arg += 1
-	} else if args.AgeInMonthGreaterThan != nil {
+	}
+	if args.AgeInMonthGreaterThan != nil {
 		whereQueries = append(whereQueries, fmt.Sprintf("age_in_month > $%d", arg))
 		sqlArgs = append(sqlArgs, *args.AgeInMonthGreaterThan)
 		arg += 1
-	} else if args.AgeInMonthLessThan != nil {
+	}
+	if args.AgeInMonthLessThan != nil {
 		whereQueries = append(whereQueries, fmt.Sprintf("age_in_month < $%d", arg))
 		sqlArgs = append(sqlArgs, *args.AgeInMonthLessThan)
 		arg += 1
@@ -109,13 +166,11 @@ func (s SQL) Search(ctx context.Context, args searchRepoArgs) ([]Cat, error) {
 
 	if args.HasMatched != nil && *args.HasMatched {
 		whereQueries = append(whereQueries, `
-			has_matched = true
-			or match_count > 0
+			(has_matched = true or match_count > 0)
 		`)
 	} else if args.HasMatched != nil && !*args.HasMatched {
 		whereQueries = append(whereQueries, `
-			has_matched = false
-			and match_count <= 0
+			(has_matched = false and match_count <= 0)
 		`)
 	}
 
@@ -124,14 +179,14 @@ func (s SQL) Search(ctx context.Context, args searchRepoArgs) ([]Cat, error) {
 		sqlArgs = append(sqlArgs, *args.ID)
 		arg += 1
 	}
-	if args.Race != nil {
-		whereQueries = append(whereQueries, fmt.Sprintf("race = $%d", arg))
-		sqlArgs = append(sqlArgs, *args.Race)
+	if len(args.Races) > 0 {
+		whereQueries = append(whereQueries, fmt.Sprintf("race = any($%d)", arg))
+		sqlArgs = append(sqlArgs, args.Races)
 		arg += 1
 	}
-	if args.Sex != nil {
-		whereQueries = append(whereQueries, fmt.Sprintf("sex = $%d", arg))
-		sqlArgs = append(sqlArgs, *args.Sex)
+	if len(args.Sexes) > 0 {
+		whereQueries = append(whereQueries, fmt.Sprintf("sex = any($%d)", arg))
+		sqlArgs = append(sqlArgs, args.Sexes)
 		arg += 1
 	}
 	if args.NameQuery != nil {
@@ -150,15 +205,41 @@ func (s SQL) Search(ctx context.Context, args searchRepoArgs) ([]Cat, error) {
 		arg += 1
 	}
 
+	// a backward cursor walks the other way, so its cats come in reverse order
+	if args.Cursor != nil && args.Cursor.Backward {
+		desc = !desc
+	}
+	order, comparison := "asc", ">"
+	if desc {
+		order, comparison = "desc", "<"
+	}
+
+	if args.Cursor != nil && args.Sort == nil {
+		whereQueries = append(whereQueries, fmt.Sprintf("id %s $%d", comparison, arg))
+		sqlArgs = append(sqlArgs, args.Cursor.ID)
+		arg += 1
+	} else if args.Cursor != nil {
+		whereQueries = append(whereQueries, fmt.Sprintf("(%s, id) %s ($%d::text::%s, $%d)",
+			sortExpr, comparison, arg, sortCast, arg+1))
+		sqlArgs = append(sqlArgs, args.Cursor.Key, args.Cursor.ID)
+		arg += 2
+	}
+
 	if len(whereQueries) > 0 {
 		query.WriteString(fmt.Sprintf(`
 			where %s
 		`, strings.Join(whereQueries, " and ")))
 	}
 
-	query.WriteString(`
-		order by id desc
-	`)
+	if args.Sort == nil {
+		query.WriteString(fmt.Sprintf(`
+			order by id %s
+		`, order))
+	} else {
+		query.WriteString(fmt.Sprintf(`
+			order by %s %s, id %s
+		`, sortExpr, order, order))
+	}
 
 	if args.Limit != nil {
 		query.WriteString(fmt.Sprintf(`
@@ -176,6 +257,12 @@ func (s SQL) Search(ctx context.Context, args searchRepoArgs) ([]Cat, error) {
 		arg += 1
 	}
 
+	if args.ForUpdateSkipLocked {
+		query.WriteString(`
+			for update skip locked
+		`)
+	}
+
 	db := s.pgxTrx.FromContext(ctx)
 	rows, err := db.Query(ctx, query.String(), sqlArgs...)
 	if err != nil {
@@ -187,7 +274,8 @@ func (s SQL) Search(ctx context.Context, args searchRepoArgs) ([]Cat, error) {
 		var c Cat
 		err = rows.Scan(
 			&c.ID, &c.UserID, &c.Race, &c.Sex, &c.Name, &c.AgeInMonth, &c.MatchCount,
-			&c.Description, &c.ImageURLs, &c.HasMatched, &c.CreatedAt,
+			&c.Description, &c.ImageURLs, &c.HasMatched, &c.IsDeleted, &c.DeletedAt, &c.CreatedAt,
+			&c.sortKey,
 		)
 		if err != nil {
 			return nil, fmt.Errorf("sql search cat: %w", err)
@@ -203,8 +291,9 @@ func (s SQL) Search(ctx context.Context, args searchRepoArgs) ([]Cat, error) {
 }
 
 type getOneByIDRepoArgs struct {
-	ID        int
-	ForUpdate bool
+	ID             int
+	ForUpdate      bool
+	IncludeDeleted bool
 }
 
 func (s SQL) GetOneByID(ctx context.Context, args getOneByIDRepoArgs) (Cat, error) {
@@ -219,12 +308,12 @@ func (s SQL) GetOneByID(ctx context.Context, args getOneByIDRepoArgs) (Cat, erro
 	err := db.QueryRow(ctx, fmt.Sprintf(`
 		select
 			id, user_id, race, sex, name, age_in_month, match_count,
-			description, image_urls, has_matched, created_at
+			description, image_urls, has_matched, is_deleted, deleted_at, created_at
 		from cats
 		where id = $1
-		and is_deleted = false %s
-	`, forUpdate), args.ID).Scan(&c.ID, &c.UserID, &c.Race, &c.Sex, &c.Name, &c.AgeInMonth, &c.MatchCount,
-		&c.Description, &c.ImageURLs, &c.HasMatched, &c.CreatedAt)
+		and (is_deleted = false or $2) %s
+	`, forUpdate), args.ID, args.IncludeDeleted).Scan(&c.ID, &c.UserID, &c.Race, &c.Sex, &c.Name, &c.AgeInMonth, &c.MatchCount,
+		&c.Description, &c.ImageURLs, &c.HasMatched, &c.IsDeleted, &c.DeletedAt, &c.CreatedAt)
 	if err != nil {
 		e := err
 		if err == pgx.ErrNoRows {
@@ -360,8 +449,9 @@ func (s SQL) Update(ctx context.Context, args UpdateRepoArgs) error {
 
 	if args.IsDeleted != nil {
 		updateQueries = append(updateQueries, fmt.Sprintf(`
-			is_deleted = $%d
-		`, arg))
+			is_deleted = $%d,
+			deleted_at = case when $%d then now() end
+		`, arg, arg))
 		sqlArgs = append(sqlArgs, *args.IsDeleted)
 		arg += 1
 	}
@@ -409,3 +499,59 @@ func (s SQL) Update(ctx context.Context, args UpdateRepoArgs) error {
 
 	return nil
 }
+
+// purgedMatch is a match that has been deleted along with a purged cat
+type purgedMatch struct {
+	IssuerCatID   int
+	ReceiverCatID int
+	Status        string
+}
+
+// PurgeMatches deletes the matches of the cats and returns them, so the other cats
+// of the matches could be corrected
+func (s SQL) PurgeMatches(ctx context.Context, ids []int) ([]purgedMatch, error) {
+	db := s.pgxTrx.FromContext(ctx)
+
+	rows, err := db.Query(ctx, `
+		delete from matches
+		where issuer_cat_id = any($1)
+		or receiver_cat_id = any($1)
+		returning issuer_cat_id, receiver_cat_id, status::text
+	`, ids)
+	if err != nil {
+		return nil, fmt.Errorf("sql purge matches of cats: %w", err)
+	}
+	defer rows.Close()
+
+	var matches []purgedMatch
+	for rows.Next() {
+		var m purgedMatch
+		err = rows.Scan(&m.IssuerCatID, &m.ReceiverCatID, &m.Status)
+		if err != nil {
+			return nil, fmt.Errorf("sql purge matches of cats: %w", err)
+		}
+
+		matches = append(matches, m)
+	}
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("sql purge matches of cats: %w", rows.Err())
+	}
+
+	return matches, nil
+}
+
+// Purge hard deletes the cats that are in the trash, the image urls go with their rows
+func (s SQL) Purge(ctx context.Context, ids []int) error {
+	db := s.pgxTrx.FromContext(ctx)
+
+	_, err := db.Exec(ctx, `
+		delete from cats
+		where id = any($1)
+		and is_deleted = true
+	`, ids)
+	if err != nil {
+		return fmt.Errorf("sql purge cats: %w", err)
+	}
+
+	return nil
+}
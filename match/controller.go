@@ -17,9 +17,10 @@ type (
 	svc interface {
 		Create(ctx context.Context, args CreateArgs) error
 		Get(ctx context.Context, args GetArgs) ([]Match, error)
-		Approve(ctx context.Context, matchID string) error
-		Reject(ctx context.Context, matchID string) error
+		Approve(ctx context.Context, args ApproveArgs) error
+		Reject(ctx context.Context, args RejectArgs) error
 		Delete(ctx context.Context, args DeleteArgs) error
+		Remove(ctx context.Context, matchID int) error
 	}
 
 	Controller struct {
@@ -111,6 +112,7 @@ type (
 	GetRespItem struct {
 		ID             string              `json:"id"`
 		Msg            string              `json:"message"`
+		Status         string              `json:"status"`
 		CreatedAt      string              `json:"createdAt"`
 		IssuedBy       GetRespItemIssuedBy `json:"issuedBy"`
 		MatchCatDetail cat.SearchRespItem  `json:"matchCatDetail"`
@@ -148,6 +150,7 @@ func (c Controller) GetHandler(w http.ResponseWriter, r *http.Request) {
 		items = append(items, GetRespItem{
 			ID:        strconv.Itoa(m.ID),
 			Msg:       m.Msg,
+			Status:    string(m.Status),
 			CreatedAt: m.CreatedAt.Format(time.RFC3339),
 			IssuedBy: GetRespItemIssuedBy{
 				Email:     m.IssuerUser.Email,
@@ -225,11 +228,18 @@ func (c Controller) ApproveHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = c.s.Approve(r.Context(), reqBody.MatchID)
+	err = c.s.Approve(r.Context(), ApproveArgs{
+		MatchID: reqBody.MatchID,
+		UserID:  userID,
+	})
 	if errors.Is(err, ErrMatchNotValid) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	if errors.Is(err, ErrIssuerCannotRespond) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
 	if errors.Is(err, ErrMatchNotFound) {
 		http.Error(w, err.Error(), http.StatusNotFound)
 		return
@@ -278,11 +288,18 @@ func (c Controller) RejectHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = c.s.Reject(r.Context(), reqBody.MatchID)
+	err = c.s.Reject(r.Context(), RejectArgs{
+		MatchID: reqBody.MatchID,
+		UserID:  userID,
+	})
 	if errors.Is(err, ErrMatchNotValid) {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	if errors.Is(err, ErrIssuerCannotRespond) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
 	if errors.Is(err, ErrMatchNotFound) {
 		http.Error(w, err.Error(), http.StatusNotFound)
 		return
@@ -333,3 +350,28 @@ func (c Controller) DeleteHandler(w http.ResponseWriter, r *http.Request) {
 
 	w.WriteHeader(http.StatusOK)
 }
+
+// ForceDeleteHandler removes a pending or an approved match regardless of its issuer, it is meant for admins
+func (c Controller) ForceDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	intMatchID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "match id is not valid", http.StatusNotFound)
+		return
+	}
+
+	err = c.s.Remove(r.Context(), intMatchID)
+	if errors.Is(err, ErrMatchNotValid) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, ErrMatchNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,61 @@
+package match
+
+import (
+	"catsocial/user"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// errSvc answers approve and reject with a canned error
+type errSvc struct {
+	svc
+	err error
+}
+
+func (s errSvc) Approve(ctx context.Context, args ApproveArgs) error {
+	return s.err
+}
+
+func (s errSvc) Reject(ctx context.Context, args RejectArgs) error {
+	return s.err
+}
+
+func TestRespondHandlers(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{name: "responded", err: nil, want: http.StatusOK},
+		{name: "issuer", err: fmt.Errorf("respond: %w", ErrIssuerCannotRespond), want: http.StatusForbidden},
+		{name: "not found", err: fmt.Errorf("respond: %w", ErrMatchNotFound), want: http.StatusNotFound},
+		{name: "not valid", err: fmt.Errorf("respond: %w", ErrMatchNotValid), want: http.StatusBadRequest},
+		{name: "unexpected", err: errors.New("connection reset"), want: http.StatusInternalServerError},
+	}
+	for _, action := range []string{"approve", "reject"} {
+		for _, tt := range tests {
+			t.Run(action+" "+tt.name, func(t *testing.T) {
+				c := NewController(errSvc{err: tt.err})
+				h := c.ApproveHandler
+				if action == "reject" {
+					h = c.RejectHandler
+				}
+
+				r := httptest.NewRequest(http.MethodPost, "/v1/cat/match/"+action, strings.NewReader(`{"matchId":"1"}`))
+				r = r.WithContext(user.ContextWithUserID(r.Context(), "20"))
+				w := httptest.NewRecorder()
+
+				h(w, r)
+
+				if w.Code != tt.want {
+					t.Fatalf("status = %d, want %d: %s", w.Code, tt.want, w.Body.String())
+				}
+			})
+		}
+	}
+}
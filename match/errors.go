@@ -9,4 +9,5 @@ var (
 	ErrUserDoesNotOwnCat    = errors.New("user does not own user cat")
 	ErrMatchNotFound        = errors.New("match not found")
 	ErrMatchNotValid        = errors.New("match not valid")
+	ErrIssuerCannotRespond  = errors.New("match issuer cannot approve or reject its own match")
 )
@@ -3,29 +3,48 @@ package match
 import (
 	"catsocial/cat"
 	"catsocial/user"
+	"strconv"
 	"time"
 )
 
 type (
 	Match struct {
-		ID                        int
-		IssuerUser                user.User
-		ReceiverUser              user.User
-		IssuerCat                 cat.Cat
-		ReceiverCat               cat.Cat
-		HasBeenApprovedOrRejected bool
-		CreatedAt                 time.Time
-		Msg                       string
+		ID           int
+		IssuerUser   user.User
+		ReceiverUser user.User
+		IssuerCat    cat.Cat
+		ReceiverCat  cat.Cat
+		Status       Status
+		CreatedAt    time.Time
+		Msg          string
 	}
 
 	MatchRaw struct {
-		ID                        int
-		IssuerUserID              int
-		ReceiverUserID            int
-		IssuerCatID               int
-		ReceiverCatID             int
-		HasBeenApprovedOrRejected bool
-		CreatedAt                 time.Time
-		Msg                       string
+		ID             int
+		IssuerUserID   int
+		ReceiverUserID int
+		IssuerCatID    int
+		ReceiverCatID  int
+		Status         Status
+		CreatedAt      time.Time
+		Msg            string
 	}
 )
+
+// AuthorizeResponder is the policy for approving or rejecting a match, only the
+// receiver of the match is allowed to respond to it. The issuer gets
+// ErrIssuerCannotRespond while anyone else gets ErrMatchNotFound so that
+// matches of other users are not leaked.
+func AuthorizeResponder(m MatchRaw, userID string) error {
+	if userID == "" {
+		return ErrMatchNotFound
+	}
+	if strconv.Itoa(m.IssuerUserID) == userID {
+		return ErrIssuerCannotRespond
+	}
+	if strconv.Itoa(m.ReceiverUserID) != userID {
+		return ErrMatchNotFound
+	}
+
+	return nil
+}
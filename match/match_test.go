@@ -0,0 +1,29 @@
+package match
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestAuthorizeResponder(t *testing.T) {
+	m := MatchRaw{ID: 1, IssuerUserID: 10, ReceiverUserID: 20, Status: StatusPending}
+
+	tests := []struct {
+		name   string
+		userID string
+		want   error
+	}{
+		{name: "issuer", userID: "10", want: ErrIssuerCannotRespond},
+		{name: "receiver", userID: "20", want: nil},
+		{name: "third party", userID: "30", want: ErrMatchNotFound},
+		{name: "anonymous", userID: "", want: ErrMatchNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := AuthorizeResponder(m, tt.userID)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("AuthorizeResponder() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
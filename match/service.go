@@ -4,8 +4,10 @@ import (
 	"catsocial/cat"
 	"catsocial/pkg/pointer"
 	"context"
+	"errors"
 	"fmt"
 	"strconv"
+	"time"
 )
 
 type (
@@ -14,7 +16,8 @@ type (
 		Get(ctx context.Context, args getRepoArgs) ([]Match, error)
 		GetByID(ctx context.Context, args getByIDRepoArgs) (MatchRaw, error)
 		GetByCatID(ctx context.Context, catID int) (MatchRaw, error)
-		Update(ctx context.Context, args updateRepoArgs) error
+		GetRaws(ctx context.Context, args getRawsRepoArgs) ([]MatchRaw, error)
+		Update(ctx context.Context, args updateRepoArgs) (int64, error)
 		Delete(ctx context.Context, args deleteRepoArgs) error
 	}
 
@@ -86,7 +89,7 @@ func (s Service) Create(ctx context.Context, args CreateArgs) error {
 			userCat = cats[1]
 			matchCat = cats[0]
 		}
-		if userCat.UserID != args.UserID {
+		if cat.AuthorizeOwner(userCat, args.UserID) != nil {
 			return ErrUserDoesNotOwnCat
 		}
 
@@ -140,8 +143,33 @@ func (s Service) GetByCatID(ctx context.Context, catID int) (MatchRaw, error) {
 	return matches, nil
 }
 
-func (s Service) Approve(ctx context.Context, matchID string) error {
-	intID, err := strconv.Atoi(matchID)
+// GetCatMatch returns the current match of the cat for the cat package,
+// nil when the cat has no approved or pending match
+func (s Service) GetCatMatch(ctx context.Context, catID int) (*cat.CatMatch, error) {
+	m, err := s.matchRepo.GetByCatID(ctx, catID)
+	if errors.Is(err, ErrMatchNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get cat match: %w", err)
+	}
+
+	return &cat.CatMatch{
+		ID:             strconv.Itoa(m.ID),
+		Status:         string(m.Status),
+		IssuerUserID:   strconv.Itoa(m.IssuerUserID),
+		ReceiverUserID: strconv.Itoa(m.ReceiverUserID),
+		CreatedAt:      m.CreatedAt,
+	}, nil
+}
+
+type ApproveArgs struct {
+	MatchID string
+	UserID  string
+}
+
+func (s Service) Approve(ctx context.Context, args ApproveArgs) error {
+	intID, err := strconv.Atoi(args.MatchID)
 	if err != nil {
 		return fmt.Errorf("approve match: match id is not valid: %w", err)
 	}
@@ -154,24 +182,23 @@ func (s Service) Approve(ctx context.Context, matchID string) error {
 		if err != nil {
 			return fmt.Errorf("get match by id: %w", err)
 		}
-		if matchRaw.HasBeenApprovedOrRejected {
-			return ErrMatchNotValid
+		err = AuthorizeResponder(matchRaw, args.UserID)
+		if err != nil {
+			return err
 		}
 
-		err = s.matchRepo.Update(ctx, updateRepoArgs{
-			ID:                        intID,
-			HasBeenApprovedOrRejected: pointer.Pointer(true),
-		})
+		err = s.transition(ctx, updateRepoArgs{ID: &matchRaw.ID}, matchRaw.Status, StatusApproved)
 		if err != nil {
 			return fmt.Errorf("update match: %w", err)
 		}
 
-		err = s.matchRepo.Delete(ctx, deleteRepoArgs{
-			CatIDs:         []int{matchRaw.IssuerCatID, matchRaw.ReceiverCatID},
-			ExcludeMatchID: pointer.Pointer(matchRaw.ID),
-		})
+		// other pending matches of both cats could not be approved anymore, and
+		// the other cats of those matches could be matched again
+		err = s.release(ctx, getRawsRepoArgs{
+			CatIDs: []int{matchRaw.IssuerCatID, matchRaw.ReceiverCatID},
+		}, StatusSuperseded)
 		if err != nil {
-			return fmt.Errorf("delete other matches: %w", err)
+			return fmt.Errorf("supersede other matches: %w", err)
 		}
 
 		err = s.catRepo.Update(ctx, cat.UpdateRepoArgs{
@@ -192,8 +219,13 @@ func (s Service) Approve(ctx context.Context, matchID string) error {
 	return nil
 }
 
-func (s Service) Reject(ctx context.Context, matchID string) error {
-	intID, err := strconv.Atoi(matchID)
+type RejectArgs struct {
+	MatchID string
+	UserID  string
+}
+
+func (s Service) Reject(ctx context.Context, args RejectArgs) error {
+	intID, err := strconv.Atoi(args.MatchID)
 	if err != nil {
 		return fmt.Errorf("reject match: match id is not valid: %w", err)
 	}
@@ -206,16 +238,14 @@ func (s Service) Reject(ctx context.Context, matchID string) error {
 		if err != nil {
 			return fmt.Errorf("get match by id: %w", err)
 		}
-		if matchRaw.HasBeenApprovedOrRejected {
-			return ErrMatchNotValid
+		err = AuthorizeResponder(matchRaw, args.UserID)
+		if err != nil {
+			return err
 		}
 
-		err = s.matchRepo.Update(ctx, updateRepoArgs{
-			ID:                        intID,
-			HasBeenApprovedOrRejected: pointer.Pointer(true),
-		})
+		err = s.transition(ctx, updateRepoArgs{ID: &matchRaw.ID}, matchRaw.Status, StatusRejected)
 		if err != nil {
-			return fmt.Errorf("update matches: %w", err)
+			return fmt.Errorf("update match: %w", err)
 		}
 
 		return nil
@@ -241,18 +271,13 @@ func (s Service) Delete(ctx context.Context, args DeleteArgs) error {
 		if err != nil {
 			return fmt.Errorf("get match by id: %w", err)
 		}
-		if matchRaw.HasBeenApprovedOrRejected {
-			return ErrMatchNotValid
-		}
 		if strconv.Itoa(matchRaw.IssuerUserID) != args.UserID {
 			return ErrMatchNotFound
 		}
 
-		err = s.matchRepo.Delete(ctx, deleteRepoArgs{
-			MatchID: &args.MatchID,
-		})
+		err = s.transition(ctx, updateRepoArgs{ID: &matchRaw.ID}, matchRaw.Status, StatusWithdrawn)
 		if err != nil {
-			return err
+			return fmt.Errorf("update match: %w", err)
 		}
 
 		err = s.catRepo.Update(ctx, cat.UpdateRepoArgs{
@@ -271,3 +296,165 @@ func (s Service) Delete(ctx context.Context, args DeleteArgs) error {
 
 	return nil
 }
+
+// Remove moves a pending or an approved match into removed regardless of its issuer,
+// it is meant for admins. The cats of a pending match get their match count back,
+// while the cats of an approved match are not matched anymore.
+func (s Service) Remove(ctx context.Context, matchID int) error {
+	err := s.trx.WithTransaction(ctx, func(ctx context.Context) error {
+		matchRaw, err := s.matchRepo.GetByID(ctx, getByIDRepoArgs{
+			ID:            matchID,
+			ForUpdateCats: true,
+		})
+		if err != nil {
+			return fmt.Errorf("get match by id: %w", err)
+		}
+
+		err = s.transition(ctx, updateRepoArgs{ID: &matchRaw.ID}, matchRaw.Status, StatusRemoved)
+		if err != nil {
+			return fmt.Errorf("update match: %w", err)
+		}
+
+		catArgs := cat.UpdateRepoArgs{
+			IDs:           []int{matchRaw.IssuerCatID, matchRaw.ReceiverCatID},
+			IncMatchCount: pointer.Pointer(-1),
+		}
+		if matchRaw.Status == StatusApproved {
+			catArgs = cat.UpdateRepoArgs{
+				IDs:        []int{matchRaw.IssuerCatID, matchRaw.ReceiverCatID},
+				HasMatched: pointer.Pointer(false),
+				MatchCount: pointer.Pointer(0),
+			}
+		}
+		err = s.catRepo.Update(ctx, catArgs)
+		if err != nil {
+			return fmt.Errorf("update cats: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("remove match: %w", err)
+	}
+
+	return nil
+}
+
+// WithdrawCatMatches withdraws every pending match of a cat that is being deleted and
+// decrements the match count of both cats of each match. An approved match is kept as
+// the history of both cats, so the other cat stays matched.
+func (s Service) WithdrawCatMatches(ctx context.Context, catID int) error {
+	err := s.withdraw(ctx, getRawsRepoArgs{CatIDs: []int{catID}})
+	if err != nil {
+		return fmt.Errorf("withdraw cat matches: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteUserData withdraws every pending match the user has issued or received
+func (s Service) DeleteUserData(ctx context.Context, userID string) error {
+	err := s.withdraw(ctx, getRawsRepoArgs{UserID: &userID})
+	if err != nil {
+		return fmt.Errorf("delete user matches: %w", err)
+	}
+
+	return nil
+}
+
+// withdraw withdraws the pending matches args narrows down to, and decrements the
+// match count of both cats of each match
+func (s Service) withdraw(ctx context.Context, args getRawsRepoArgs) error {
+	return s.trx.WithTransaction(ctx, func(ctx context.Context) error {
+		return s.release(ctx, args, StatusWithdrawn)
+	})
+}
+
+// release moves the pending matches args narrows down to into the final status to,
+// and decrements the match count of both cats of each match. It must be run in a
+// transaction.
+func (s Service) release(ctx context.Context, args getRawsRepoArgs, to Status) error {
+	args.Status = pointer.Pointer(StatusPending)
+	args.ForUpdateCats = true
+	matches, err := s.matchRepo.GetRaws(ctx, args)
+	if err != nil {
+		return fmt.Errorf("get pending matches: %w", err)
+	}
+
+	for _, m := range matches {
+		err = s.transition(ctx, updateRepoArgs{ID: &m.ID}, m.Status, to)
+		if err != nil {
+			return fmt.Errorf("update match: %w", err)
+		}
+
+		err = s.catRepo.Update(ctx, cat.UpdateRepoArgs{
+			IDs:           []int{m.IssuerCatID, m.ReceiverCatID},
+			IncMatchCount: pointer.Pointer(-1),
+		})
+		if err != nil {
+			return fmt.Errorf("decrement cats match count: %w", err)
+		}
+	}
+
+	return nil
+}
+
+type ExportItem struct {
+	ID            string `json:"id"`
+	Role          string `json:"role"`
+	Status        string `json:"status"`
+	Msg           string `json:"message"`
+	IssuerCatID   string `json:"issuerCatId"`
+	ReceiverCatID string `json:"receiverCatId"`
+	CreatedAt     string `json:"createdAt"`
+}
+
+// ExportUserData returns the whole match history of the user
+func (s Service) ExportUserData(ctx context.Context, userID string) (any, error) {
+	matches, err := s.matchRepo.GetRaws(ctx, getRawsRepoArgs{UserID: &userID})
+	if err != nil {
+		return nil, fmt.Errorf("export user matches: %w", err)
+	}
+
+	items := make([]ExportItem, 0)
+	for _, m := range matches {
+		role := "receiver"
+		if strconv.Itoa(m.IssuerUserID) == userID {
+			role = "issuer"
+		}
+		items = append(items, ExportItem{
+			ID:            strconv.Itoa(m.ID),
+			Role:          role,
+			Status:        string(m.Status),
+			Msg:           m.Msg,
+			IssuerCatID:   strconv.Itoa(m.IssuerCatID),
+			ReceiverCatID: strconv.Itoa(m.ReceiverCatID),
+			CreatedAt:     m.CreatedAt.Format(time.RFC3339),
+		})
+	}
+
+	return items, nil
+}
+
+// transition moves the matches selected by args from status from into status to.
+// It is the only place where the status of a match is changed, so that every
+// change goes through the legal transitions of a match. A single match that is no
+// longer in status from, because a concurrent request has moved it first, gives
+// ErrMatchNotValid so that the caller does not act on a stale status.
+func (s Service) transition(ctx context.Context, args updateRepoArgs, from, to Status) error {
+	if !from.CanTransitionTo(to) {
+		return fmt.Errorf("transition match from %s to %s: %w", from, to, ErrMatchNotValid)
+	}
+
+	args.FromStatus = &from
+	args.Status = &to
+	n, err := s.matchRepo.Update(ctx, args)
+	if err != nil {
+		return fmt.Errorf("transition match from %s to %s: %w", from, to, err)
+	}
+	if args.ID != nil && n == 0 {
+		return fmt.Errorf("transition match from %s to %s: match has been changed: %w", from, to, ErrMatchNotValid)
+	}
+
+	return nil
+}
@@ -0,0 +1,199 @@
+package match
+
+import (
+	"catsocial/cat"
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+type inlineTrx struct{}
+
+func (inlineTrx) WithTransaction(ctx context.Context, fn func(context.Context) error) error {
+	return fn(ctx)
+}
+
+// memoryRepo keeps the matches in memory, the latest first
+type memoryRepo struct {
+	matchRepo
+	matches []MatchRaw
+}
+
+func (r *memoryRepo) GetByID(ctx context.Context, args getByIDRepoArgs) (MatchRaw, error) {
+	for _, m := range r.matches {
+		if m.ID == args.ID {
+			return m, nil
+		}
+	}
+	return MatchRaw{}, ErrMatchNotFound
+}
+
+func (r *memoryRepo) GetRaws(ctx context.Context, args getRawsRepoArgs) ([]MatchRaw, error) {
+	var matches []MatchRaw
+	for _, m := range r.matches {
+		if args.CatIDs != nil && !slices.Contains(args.CatIDs, m.IssuerCatID) && !slices.Contains(args.CatIDs, m.ReceiverCatID) {
+			continue
+		}
+		if args.Status != nil && m.Status != *args.Status {
+			continue
+		}
+		matches = append(matches, m)
+	}
+	return matches, nil
+}
+
+func (r *memoryRepo) Update(ctx context.Context, args updateRepoArgs) (int64, error) {
+	var n int64
+	for i, m := range r.matches {
+		if m.ID == *args.ID && m.Status == *args.FromStatus {
+			r.matches[i].Status = *args.Status
+			n++
+		}
+	}
+	return n, nil
+}
+
+// catUpdates records the updates of the cats
+type catUpdates []cat.UpdateRepoArgs
+
+func (u *catUpdates) Update(ctx context.Context, args cat.UpdateRepoArgs) error {
+	*u = append(*u, args)
+	return nil
+}
+
+func (u catUpdates) String() string {
+	s := ""
+	for _, args := range u {
+		s += fmt.Sprintf("{IDs:%v", args.IDs)
+		if args.IncMatchCount != nil {
+			s += fmt.Sprintf(" IncMatchCount:%d", *args.IncMatchCount)
+		}
+		if args.HasMatched != nil {
+			s += fmt.Sprintf(" HasMatched:%t", *args.HasMatched)
+		}
+		if args.MatchCount != nil {
+			s += fmt.Sprintf(" MatchCount:%d", *args.MatchCount)
+		}
+		s += "}"
+	}
+	return "[" + s + "]"
+}
+
+func TestApproveReleasesSupersededCats(t *testing.T) {
+	r := &memoryRepo{matches: []MatchRaw{
+		{ID: 4, IssuerCatID: 5, ReceiverCatID: 6, Status: StatusPending},
+		{ID: 3, IssuerCatID: 2, ReceiverCatID: 4, Status: StatusPending},
+		{ID: 2, IssuerCatID: 3, ReceiverCatID: 1, Status: StatusPending},
+		{ID: 1, IssuerCatID: 1, ReceiverCatID: 2, IssuerUserID: 10, ReceiverUserID: 20, Status: StatusPending},
+	}}
+	cats := &catUpdates{}
+	s := NewService(r, nil, cats, inlineTrx{})
+
+	err := s.Approve(context.Background(), ApproveArgs{MatchID: "1", UserID: "20"})
+	if err != nil {
+		t.Fatalf("Approve(): %v", err)
+	}
+
+	wantStatuses := map[int]Status{1: StatusApproved, 2: StatusSuperseded, 3: StatusSuperseded, 4: StatusPending}
+	for _, m := range r.matches {
+		if m.Status != wantStatuses[m.ID] {
+			t.Errorf("match %d status = %s, want %s", m.ID, m.Status, wantStatuses[m.ID])
+		}
+	}
+
+	// the other cats of the superseded matches could be matched again, while
+	// the cats of the approved match end up matched once
+	minusOne, one, matched := -1, 1, true
+	want := catUpdates{
+		{IDs: []int{2, 4}, IncMatchCount: &minusOne},
+		{IDs: []int{3, 1}, IncMatchCount: &minusOne},
+		{IDs: []int{1, 2}, HasMatched: &matched, MatchCount: &one},
+	}
+	if !reflect.DeepEqual(*cats, want) {
+		t.Fatalf("cat updates = %s, want %s", cats, want)
+	}
+}
+
+func TestRemove(t *testing.T) {
+	minusOne, zero, unmatched := -1, 0, false
+	tests := []struct {
+		name   string
+		status Status
+		want   catUpdates
+	}{
+		{name: "pending", status: StatusPending, want: catUpdates{{IDs: []int{1, 2}, IncMatchCount: &minusOne}}},
+		{name: "approved", status: StatusApproved, want: catUpdates{{IDs: []int{1, 2}, HasMatched: &unmatched, MatchCount: &zero}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &memoryRepo{matches: []MatchRaw{{ID: 1, IssuerCatID: 1, ReceiverCatID: 2, Status: tt.status}}}
+			cats := &catUpdates{}
+			s := NewService(r, nil, cats, inlineTrx{})
+
+			err := s.Remove(context.Background(), 1)
+			if err != nil {
+				t.Fatalf("Remove(): %v", err)
+			}
+
+			// the match is kept as the history of both cats
+			if r.matches[0].Status != StatusRemoved {
+				t.Fatalf("match status = %s, want %s", r.matches[0].Status, StatusRemoved)
+			}
+			if !reflect.DeepEqual(*cats, tt.want) {
+				t.Fatalf("cat updates = %s, want %s", cats, tt.want)
+			}
+		})
+	}
+}
+
+func TestRemoveFinishedMatch(t *testing.T) {
+	r := &memoryRepo{matches: []MatchRaw{{ID: 1, IssuerCatID: 1, ReceiverCatID: 2, Status: StatusRejected}}}
+	cats := &catUpdates{}
+	s := NewService(r, nil, cats, inlineTrx{})
+
+	err := s.Remove(context.Background(), 1)
+	if !errors.Is(err, ErrMatchNotValid) {
+		t.Fatalf("Remove() error = %v, want %v", err, ErrMatchNotValid)
+	}
+	if len(*cats) != 0 {
+		t.Fatalf("cat updates = %s, want none", cats)
+	}
+}
+
+func TestRespondAuthorizesResponder(t *testing.T) {
+	respond := map[string]func(s Service, userID string) error{
+		"approve": func(s Service, userID string) error {
+			return s.Approve(context.Background(), ApproveArgs{MatchID: "1", UserID: userID})
+		},
+		"reject": func(s Service, userID string) error {
+			return s.Reject(context.Background(), RejectArgs{MatchID: "1", UserID: userID})
+		},
+	}
+	tests := []struct {
+		name   string
+		userID string
+		want   error
+	}{
+		{name: "issuer", userID: "10", want: ErrIssuerCannotRespond},
+		{name: "third party", userID: "30", want: ErrMatchNotFound},
+	}
+	for action, fn := range respond {
+		for _, tt := range tests {
+			t.Run(action+" by "+tt.name, func(t *testing.T) {
+				r := &memoryRepo{matches: []MatchRaw{{ID: 1, IssuerCatID: 1, ReceiverCatID: 2, IssuerUserID: 10, ReceiverUserID: 20, Status: StatusPending}}}
+				cats := &catUpdates{}
+
+				err := fn(NewService(r, nil, cats, inlineTrx{}), tt.userID)
+				if !errors.Is(err, tt.want) {
+					t.Fatalf("error = %v, want %v", err, tt.want)
+				}
+				if r.matches[0].Status != StatusPending || len(*cats) != 0 {
+					t.Fatalf("match status = %s, cat updates = %s, want them untouched", r.matches[0].Status, cats)
+				}
+			})
+		}
+	}
+}
@@ -53,6 +53,7 @@ func (s SQL) Get(ctx context.Context, args getRepoArgs) ([]Match, error) {
 		select
 			m.id,
 			m.msg,
+			m.status,
 			m.created_at,
 
 			issuer_user.name,
@@ -85,10 +86,10 @@ func (s SQL) Get(ctx context.Context, args getRepoArgs) ([]Match, error) {
 				on m.issuer_cat_id = issuer_cat.id
 			inner join cats receiver_cat
 				on m.receiver_cat_id = receiver_cat.id
-		where m.issuer_user_id = $1
-		or m.receiver_user_id = $1
+		where (m.issuer_user_id = $1 or m.receiver_user_id = $1)
+		and m.status != all($2)
 		order by m.id desc
-	`, args.UserID)
+	`, args.UserID, []string{string(StatusWithdrawn), string(StatusSuperseded), string(StatusRemoved)})
 	if err != nil {
 		return nil, fmt.Errorf("sql get matches: %w", err)
 	}
@@ -96,7 +97,7 @@ func (s SQL) Get(ctx context.Context, args getRepoArgs) ([]Match, error) {
 
 	for rows.Next() {
 		var m Match
-		err = rows.Scan(&m.ID, &m.Msg, &m.CreatedAt,
+		err = rows.Scan(&m.ID, &m.Msg, &m.Status, &m.CreatedAt,
 			// issuer user
 			&m.IssuerUser.Name, &m.IssuerUser.Email, &m.IssuerUser.CreatedAt,
 			// issuer cat
@@ -134,20 +135,20 @@ func (s SQL) IsExist(ctx context.Context, id int) (bool, error) {
 	return true, nil
 }
 
-func (s SQL) GetHasBeenApprovedOrRejectedById(ctx context.Context, id int) (bool, error) {
+func (s SQL) GetStatusByID(ctx context.Context, id int) (Status, error) {
 	db := s.pgxTrx.FromContext(ctx)
 
-	var b bool
-	err := db.QueryRow(ctx, "select has_been_approved_or_rejected from matches where id = $1", id).Scan(&b)
+	var st Status
+	err := db.QueryRow(ctx, "select status from matches where id = $1", id).Scan(&st)
 	if err != nil {
 		e := err
 		if err == pgx.ErrNoRows {
 			e = ErrMatchNotFound
 		}
-		return b, fmt.Errorf("sql finding match by id: %w", e)
+		return st, fmt.Errorf("sql finding match by id: %w", e)
 	}
 
-	return b, nil
+	return st, nil
 }
 
 type getByIDRepoArgs struct {
@@ -161,7 +162,7 @@ func (s SQL) GetByID(ctx context.Context, args getByIDRepoArgs) (MatchRaw, error
 	query := `
 		select 
 			id, issuer_user_id, receiver_user_id, issuer_cat_id, receiver_cat_id,
-			has_been_approved_or_rejected, created_at, msg
+			status, created_at, msg
 		from matches 
 		where id = $1
 	`
@@ -169,7 +170,7 @@ func (s SQL) GetByID(ctx context.Context, args getByIDRepoArgs) (MatchRaw, error
 		query = `
 			select 
 				m.id, m.issuer_user_id, m.receiver_user_id, m.issuer_cat_id, m.receiver_cat_id,
-				m.has_been_approved_or_rejected, m.created_at, m.msg
+				m.status, m.created_at, m.msg
 			from matches m
 				inner join cats issuer_cat
 					on m.issuer_cat_id = issuer_cat.id
@@ -183,7 +184,7 @@ func (s SQL) GetByID(ctx context.Context, args getByIDRepoArgs) (MatchRaw, error
 	var m MatchRaw
 	err := db.QueryRow(ctx, query, args.ID).
 		Scan(&m.ID, &m.IssuerUserID, &m.ReceiverUserID, &m.IssuerCatID, &m.ReceiverCatID,
-			&m.HasBeenApprovedOrRejected, &m.CreatedAt, &m.Msg)
+			&m.Status, &m.CreatedAt, &m.Msg)
 	if err != nil {
 		e := err
 		if err == pgx.ErrNoRows {
@@ -195,6 +196,97 @@ func (s SQL) GetByID(ctx context.Context, args getByIDRepoArgs) (MatchRaw, error
 	return m, nil
 }
 
+type getRawsRepoArgs struct {
+	// UserID narrows the matches down to the ones the user has issued or received
+	UserID *string
+	// CatIDs narrows the matches down to the ones any of the cats has issued or received
+	CatIDs        []int
+	Status        *Status
+	ForUpdateCats bool
+}
+
+// GetRaws returns the matches, the latest first
+func (s SQL) GetRaws(ctx context.Context, args getRawsRepoArgs) ([]MatchRaw, error) {
+	var (
+		query        strings.Builder
+		whereQueries []string
+		sqlArgs      []any
+
+		arg = 1
+	)
+
+	query.WriteString(`
+		select
+			m.id, m.issuer_user_id, m.receiver_user_id, m.issuer_cat_id, m.receiver_cat_id,
+			m.status, m.created_at, m.msg
+		from matches m
+			inner join cats issuer_cat
+				on m.issuer_cat_id = issuer_cat.id
+			inner join cats receiver_cat
+				on m.receiver_cat_id = receiver_cat.id
+	`)
+
+	if args.UserID != nil {
+		whereQueries = append(whereQueries, fmt.Sprintf("(m.issuer_user_id = $%d or m.receiver_user_id = $%d)", arg, arg))
+		sqlArgs = append(sqlArgs, *args.UserID)
+		arg += 1
+	}
+
+	if args.CatIDs != nil {
+		whereQueries = append(whereQueries, fmt.Sprintf("(m.issuer_cat_id = any($%d) or m.receiver_cat_id = any($%d))", arg, arg))
+		sqlArgs = append(sqlArgs, args.CatIDs)
+		arg += 1
+	}
+
+	if args.Status != nil {
+		whereQueries = append(whereQueries, fmt.Sprintf("m.status = $%d", arg))
+		sqlArgs = append(sqlArgs, string(*args.Status))
+		arg += 1
+	}
+
+	if len(whereQueries) > 0 {
+		query.WriteString(fmt.Sprintf(`
+			where %s
+		`, strings.Join(whereQueries, " and ")))
+	}
+
+	query.WriteString(`
+		order by m.id desc
+	`)
+
+	if args.ForUpdateCats {
+		query.WriteString(`
+			for update of issuer_cat, receiver_cat
+		`)
+	}
+
+	db := s.pgxTrx.FromContext(ctx)
+	rows, err := db.Query(ctx, query.String(), sqlArgs...)
+	if err != nil {
+		return nil, fmt.Errorf("sql get matches: %w", err)
+	}
+	defer rows.Close()
+
+	var matches []MatchRaw
+	for rows.Next() {
+		var m MatchRaw
+		err = rows.Scan(&m.ID, &m.IssuerUserID, &m.ReceiverUserID, &m.IssuerCatID, &m.ReceiverCatID,
+			&m.Status, &m.CreatedAt, &m.Msg)
+		if err != nil {
+			return nil, fmt.Errorf("sql get matches: %w", err)
+		}
+
+		matches = append(matches, m)
+	}
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("sql get matches: %w", rows.Err())
+	}
+
+	return matches, nil
+}
+
+// GetByCatID returns the current match of the cat, that is its approved match or
+// otherwise its latest pending match. Matches in a final status are not returned.
 func (s SQL) GetByCatID(ctx context.Context, catID int) (MatchRaw, error) {
 	db := s.pgxTrx.FromContext(ctx)
 
@@ -202,12 +294,14 @@ func (s SQL) GetByCatID(ctx context.Context, catID int) (MatchRaw, error) {
 	err := db.QueryRow(ctx, `
 		select 
 			id, issuer_user_id, receiver_user_id, issuer_cat_id, receiver_cat_id,
-			has_been_approved_or_rejected, created_at, msg
+			status, created_at, msg
 		from matches 
-		where issuer_cat_id = $1
-		or receiver_cat_id = $1
-	`, catID).Scan(&m.ID, &m.IssuerUserID, &m.ReceiverUserID, &m.IssuerCatID, &m.ReceiverCatID,
-		&m.HasBeenApprovedOrRejected, &m.CreatedAt, &m.Msg)
+		where (issuer_cat_id = $1 or receiver_cat_id = $1)
+		and status in ($2, $3)
+		order by status = $2 desc, created_at desc, id desc
+		limit 1
+	`, catID, StatusApproved, StatusPending).Scan(&m.ID, &m.IssuerUserID, &m.ReceiverUserID, &m.IssuerCatID, &m.ReceiverCatID,
+		&m.Status, &m.CreatedAt, &m.Msg)
 	if err != nil {
 		e := err
 		if err == pgx.ErrNoRows {
@@ -220,39 +314,64 @@ func (s SQL) GetByCatID(ctx context.Context, catID int) (MatchRaw, error) {
 }
 
 type updateRepoArgs struct {
-	ID                        int
-	HasBeenApprovedOrRejected *bool
+	ID         *int
+	FromStatus *Status
+	Status     *Status
 }
 
-func (s SQL) Update(ctx context.Context, args updateRepoArgs) error {
+// Update returns the number of matches it has changed
+func (s SQL) Update(ctx context.Context, args updateRepoArgs) (int64, error) {
 	var (
-		query   strings.Builder
-		sqlArgs []any
+		query         strings.Builder
+		updateQueries []string
+		whereQueries  []string
+		sqlArgs       []any
 
 		arg = 1
 	)
-	query.WriteString("update matches set ")
+	query.WriteString("update matches ")
 
-	if args.HasBeenApprovedOrRejected != nil {
-		query.WriteString(fmt.Sprintf(`
-			has_been_approved_or_rejected = $%d
+	if args.Status != nil {
+		updateQueries = append(updateQueries, fmt.Sprintf(`
+			status = $%d
 		`, arg))
-		sqlArgs = append(sqlArgs, *args.HasBeenApprovedOrRejected)
+		sqlArgs = append(sqlArgs, string(*args.Status))
 		arg += 1
 	}
 
 	query.WriteString(fmt.Sprintf(`
-		where id = $%d
-	`, arg))
-	sqlArgs = append(sqlArgs, args.ID)
+		set %s
+	`, strings.Join(updateQueries, ", ")))
+
+	if args.ID != nil {
+		whereQueries = append(whereQueries, fmt.Sprintf(`
+			id = $%d
+		`, arg))
+		sqlArgs = append(sqlArgs, *args.ID)
+		arg += 1
+	}
+
+	if args.FromStatus != nil {
+		whereQueries = append(whereQueries, fmt.Sprintf(`
+			status = $%d
+		`, arg))
+		sqlArgs = append(sqlArgs, string(*args.FromStatus))
+		arg += 1
+	}
+
+	if len(whereQueries) > 0 {
+		query.WriteString(fmt.Sprintf(`
+			where %s
+		`, strings.Join(whereQueries, " and ")))
+	}
 
 	db := s.pgxTrx.FromContext(ctx)
-	_, err := db.Exec(ctx, query.String(), sqlArgs...)
+	tag, err := db.Exec(ctx, query.String(), sqlArgs...)
 	if err != nil {
-		return fmt.Errorf("sql update match by id: %w", err)
+		return 0, fmt.Errorf("sql update matches: %w", err)
 	}
 
-	return nil
+	return tag.RowsAffected(), nil
 }
 
 type deleteRepoArgs struct {
@@ -0,0 +1,41 @@
+package match
+
+import "slices"
+
+type (
+	Status string
+)
+
+const (
+	StatusPending    Status = "pending"
+	StatusApproved   Status = "approved"
+	StatusRejected   Status = "rejected"
+	StatusWithdrawn  Status = "withdrawn"
+	StatusExpired    Status = "expired"
+	StatusSuperseded Status = "superseded"
+	// StatusRemoved is a match an admin has taken down
+	StatusRemoved Status = "removed"
+)
+
+var (
+	// transitions lists the legal status changes of a match,
+	// a status that is not listed as a key is final
+	transitions = map[Status][]Status{
+		StatusPending: {
+			StatusApproved,
+			StatusRejected,
+			StatusWithdrawn,
+			StatusExpired,
+			StatusSuperseded,
+			StatusRemoved,
+		},
+		StatusApproved: {
+			StatusRemoved,
+		},
+	}
+)
+
+// CanTransitionTo reports whether a match in status s could be moved into status next
+func (s Status) CanTransitionTo(next Status) bool {
+	return slices.Contains(transitions[s], next)
+}
@@ -0,0 +1,17 @@
+begin;
+
+drop index if exists idx_matches_status;
+
+alter table matches
+    add column if not exists has_been_approved_or_rejected boolean not null default false;
+
+update matches
+set has_been_approved_or_rejected = status in ('approved', 'rejected');
+
+-- withdrawn and superseded matches used to be deleted
+delete from matches
+where status in ('withdrawn', 'superseded');
+
+alter table matches drop column if exists status;
+
+commit;
@@ -0,0 +1,23 @@
+begin;
+
+alter table matches
+    add column if not exists status text not null default 'pending'
+    check (status in ('pending', 'approved', 'rejected', 'withdrawn', 'expired', 'superseded'));
+
+-- an approved match marks both of its cats as matched and every other match of
+-- those cats is removed, so the remaining answered matches are rejected ones
+update matches m
+set status = case
+        when issuer_cat.has_matched and receiver_cat.has_matched then 'approved'
+        else 'rejected'
+    end
+from cats issuer_cat, cats receiver_cat
+where m.issuer_cat_id = issuer_cat.id
+and m.receiver_cat_id = receiver_cat.id
+and m.has_been_approved_or_rejected = true;
+
+alter table matches drop column if exists has_been_approved_or_rejected;
+
+create index if not exists idx_matches_status on matches (status);
+
+commit;
@@ -0,0 +1,9 @@
+begin;
+
+drop index if exists idx_refresh_tokens_user_id;
+
+drop index if exists idx_refresh_tokens_session_id;
+
+drop table if exists refresh_tokens;
+
+commit;
@@ -0,0 +1,19 @@
+begin;
+
+create table
+    if not exists refresh_tokens (
+        id int primary key generated always as identity,
+        user_id int not null,
+        session_id text not null,
+        token_hash text unique not null,
+        expires_at timestamptz not null,
+        rotated_at timestamptz,
+        revoked_at timestamptz,
+        created_at timestamptz not null default now ()
+    );
+
+create index if not exists idx_refresh_tokens_user_id on refresh_tokens (user_id);
+
+create index if not exists idx_refresh_tokens_session_id on refresh_tokens (session_id);
+
+commit;
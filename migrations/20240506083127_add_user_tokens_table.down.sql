@@ -0,0 +1,7 @@
+begin;
+
+drop index if exists idx_user_tokens_user_id_purpose;
+
+drop table if exists user_tokens;
+
+commit;
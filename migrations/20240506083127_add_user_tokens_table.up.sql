@@ -0,0 +1,16 @@
+begin;
+
+create table
+    if not exists user_tokens (
+        id int primary key generated always as identity,
+        user_id int not null,
+        purpose text not null,
+        token_hash text unique not null,
+        expires_at timestamptz not null,
+        used_at timestamptz,
+        created_at timestamptz not null default now ()
+    );
+
+create index if not exists idx_user_tokens_user_id_purpose on user_tokens (user_id, purpose);
+
+commit;
@@ -0,0 +1,2 @@
+alter table users
+    add column if not exists email_verified_at timestamptz;
@@ -0,0 +1,3 @@
+alter table users
+    drop column if exists banned_at,
+    drop column if exists role;
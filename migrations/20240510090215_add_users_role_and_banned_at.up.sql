@@ -0,0 +1,3 @@
+alter table users
+    add column if not exists role varchar(16) not null default 'user',
+    add column if not exists banned_at timestamptz;
@@ -0,0 +1,5 @@
+begin;
+
+drop table if exists login_attempts;
+
+commit;
@@ -0,0 +1,11 @@
+begin;
+
+create table
+    if not exists login_attempts (
+        key text primary key,
+        failed_count int not null default 0,
+        last_failed_at timestamptz not null default now (),
+        locked_until timestamptz
+    );
+
+commit;
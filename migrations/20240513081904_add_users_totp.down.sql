@@ -0,0 +1,12 @@
+begin;
+
+drop index if exists idx_user_recovery_codes_user_id;
+
+drop table if exists user_recovery_codes;
+
+alter table users
+    drop column if exists totp_last_counter,
+    drop column if exists totp_enabled_at,
+    drop column if exists totp_secret;
+
+commit;
@@ -0,0 +1,19 @@
+begin;
+
+alter table users
+    add column if not exists totp_secret text,
+    add column if not exists totp_enabled_at timestamptz,
+    add column if not exists totp_last_counter bigint;
+
+create table
+    if not exists user_recovery_codes (
+        id int primary key generated always as identity,
+        user_id int not null,
+        code_hash text not null,
+        used_at timestamptz,
+        created_at timestamptz not null default now ()
+    );
+
+create index if not exists idx_user_recovery_codes_user_id on user_recovery_codes (user_id);
+
+commit;
@@ -0,0 +1,7 @@
+begin;
+
+drop index if exists idx_api_keys_user_id;
+
+drop table if exists api_keys;
+
+commit;
@@ -0,0 +1,18 @@
+begin;
+
+create table
+    if not exists api_keys (
+        id int primary key generated always as identity,
+        user_id int not null,
+        name varchar(50) not null,
+        prefix text unique not null,
+        key_hash text not null,
+        scopes text[] not null default '{}',
+        last_used_at timestamptz,
+        revoked_at timestamptz,
+        created_at timestamptz not null default now ()
+    );
+
+create index if not exists idx_api_keys_user_id on api_keys (user_id);
+
+commit;
@@ -0,0 +1,7 @@
+begin;
+
+drop index if exists idx_user_identities_user_id;
+
+drop table if exists user_identities;
+
+commit;
@@ -0,0 +1,16 @@
+begin;
+
+create table
+    if not exists user_identities (
+        id int primary key generated always as identity,
+        user_id int not null,
+        issuer text not null,
+        subject text not null,
+        email text not null,
+        created_at timestamptz not null default now (),
+        unique (issuer, subject)
+    );
+
+create index if not exists idx_user_identities_user_id on user_identities (user_id);
+
+commit;
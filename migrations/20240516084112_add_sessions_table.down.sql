@@ -0,0 +1,7 @@
+begin;
+
+drop index if exists idx_sessions_user_id;
+
+drop table if exists sessions;
+
+commit;
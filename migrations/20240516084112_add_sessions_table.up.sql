@@ -0,0 +1,30 @@
+begin;
+
+create table
+    if not exists sessions (
+        id text primary key,
+        user_id int not null,
+        user_agent text not null default '',
+        ip text not null default '',
+        created_at timestamptz not null default now (),
+        last_seen_at timestamptz not null default now ()
+    );
+
+create index if not exists idx_sessions_user_id on sessions (user_id);
+
+-- sessions started before this table existed are only known by their refresh tokens
+insert into
+    sessions (id, user_id, created_at, last_seen_at)
+select
+    session_id,
+    user_id,
+    min(created_at),
+    max(created_at)
+from
+    refresh_tokens
+group by
+    session_id,
+    user_id
+on conflict (id) do nothing;
+
+commit;
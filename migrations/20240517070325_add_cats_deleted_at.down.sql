@@ -0,0 +1,8 @@
+begin;
+
+drop index if exists idx_cats_deleted_at;
+
+alter table cats
+drop column if exists deleted_at;
+
+commit;
@@ -0,0 +1,14 @@
+begin;
+
+alter table cats
+add column if not exists deleted_at timestamptz;
+
+-- the restore window of cats deleted before this column existed starts now
+update cats
+set deleted_at = now()
+where is_deleted = true
+and deleted_at is null;
+
+create index if not exists idx_cats_deleted_at on cats (deleted_at) where is_deleted = true;
+
+commit;
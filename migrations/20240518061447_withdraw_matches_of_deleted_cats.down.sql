@@ -0,0 +1,4 @@
+-- the withdrawn matches could not be told apart from the ones withdrawn by their issuers
+begin;
+
+commit;
@@ -0,0 +1,29 @@
+begin;
+
+-- cats deleted before their pending matches were withdrawn on delete left those
+-- matches pending, they are withdrawn and the match count of their cats decremented
+with
+    withdrawn as (
+        update matches m
+        set status = 'withdrawn'
+        from cats c
+        where m.status = 'pending'
+        and c.is_deleted = true
+        and (m.issuer_cat_id = c.id or m.receiver_cat_id = c.id)
+        returning m.issuer_cat_id, m.receiver_cat_id
+    ),
+    counts as (
+        select cat_id, count(*) as withdrawn_count
+        from (
+            select issuer_cat_id as cat_id from withdrawn
+            union all
+            select receiver_cat_id as cat_id from withdrawn
+        ) cat_ids
+        group by cat_id
+    )
+update cats
+set match_count = greatest(match_count - counts.withdrawn_count, 0)
+from counts
+where cats.id = counts.cat_id;
+
+commit;
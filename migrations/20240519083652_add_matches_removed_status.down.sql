@@ -0,0 +1,13 @@
+begin;
+
+-- removed matches used to be deleted
+delete from matches
+where status = 'removed';
+
+alter table matches drop constraint if exists matches_status_check;
+
+alter table matches
+    add constraint matches_status_check
+    check (status in ('pending', 'approved', 'rejected', 'withdrawn', 'expired', 'superseded'));
+
+commit;
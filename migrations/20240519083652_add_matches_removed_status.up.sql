@@ -0,0 +1,10 @@
+begin;
+
+-- admins take matches down into removed instead of deleting them
+alter table matches drop constraint if exists matches_status_check;
+
+alter table matches
+    add constraint matches_status_check
+    check (status in ('pending', 'approved', 'rejected', 'withdrawn', 'expired', 'superseded', 'removed'));
+
+commit;
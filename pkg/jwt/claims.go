@@ -0,0 +1,73 @@
+package jwt
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"slices"
+)
+
+type (
+	// Claims is implemented by every claims struct that embeds RegisteredClaims
+	Claims interface {
+		Registered() RegisteredClaims
+	}
+
+	// RegisteredClaims are the standard claims of RFC 7519, times are unix seconds
+	// and zero means the claim is absent
+	RegisteredClaims struct {
+		Issuer    string   `json:"iss,omitempty"`
+		Subject   string   `json:"sub,omitempty"`
+		Audience  Audience `json:"aud,omitempty"`
+		ExpiresAt int64    `json:"exp,omitempty"`
+		NotBefore int64    `json:"nbf,omitempty"`
+		IssuedAt  int64    `json:"iat,omitempty"`
+		ID        string   `json:"jti,omitempty"`
+	}
+
+	// Audience is either a single string or an array of strings in the token
+	Audience []string
+)
+
+func (r RegisteredClaims) Registered() RegisteredClaims {
+	return r
+}
+
+func (a Audience) Contains(aud string) bool {
+	return slices.Contains(a, aud)
+}
+
+func (a Audience) MarshalJSON() ([]byte, error) {
+	if len(a) == 1 {
+		return json.Marshal(a[0])
+	}
+	return json.Marshal([]string(a))
+}
+
+func (a *Audience) UnmarshalJSON(b []byte) error {
+	var single string
+	if json.Unmarshal(b, &single) == nil {
+		*a = Audience{single}
+		return nil
+	}
+
+	var multi []string
+	err := json.Unmarshal(b, &multi)
+	if err != nil {
+		return fmt.Errorf("audience must be a string or an array of strings: %w", err)
+	}
+	*a = multi
+	return nil
+}
+
+// NewID returns a random token id for the jti claim
+func NewID() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", fmt.Errorf("jwt new id: %w", err)
+	}
+
+	return hex.EncodeToString(b), nil
+}
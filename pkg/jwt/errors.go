@@ -0,0 +1,14 @@
+package jwt
+
+import "errors"
+
+var (
+	ErrTokenMalformed        = errors.New("token is malformed")
+	ErrTokenUnverifiable     = errors.New("token could not be verified")
+	ErrTokenSignatureInvalid = errors.New("token signature is invalid")
+	ErrTokenExpired          = errors.New("token is expired")
+	ErrTokenNotValidYet      = errors.New("token is not valid yet")
+	ErrTokenUsedBeforeIssued = errors.New("token used before issued")
+	ErrTokenInvalidIssuer    = errors.New("token has invalid issuer")
+	ErrTokenInvalidAudience  = errors.New("token has invalid audience")
+)
@@ -0,0 +1,124 @@
+package jwt
+
+import (
+	"cmp"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"slices"
+)
+
+type (
+	// JWK is the public part of an asymmetric key as described in RFC 7517
+	JWK struct {
+		Kty string `json:"kty"`
+		Kid string `json:"kid"`
+		Use string `json:"use"`
+		Alg string `json:"alg"`
+		Crv string `json:"crv,omitempty"`
+		X   string `json:"x,omitempty"`
+		N   string `json:"n,omitempty"`
+		E   string `json:"e,omitempty"`
+	}
+
+	JWKS struct {
+		Keys []JWK `json:"keys"`
+	}
+)
+
+// JWK returns the public key in JWK format, symmetric keys are never published
+func (k Key) JWK() (JWK, bool) {
+	switch pub := k.public.(type) {
+	case ed25519.PublicKey:
+		return JWK{
+			Kty: "OKP",
+			Kid: k.ID,
+			Use: "sig",
+			Alg: AlgEdDSA,
+			Crv: "Ed25519",
+			X:   base64.RawURLEncoding.EncodeToString(pub),
+		}, true
+	case *rsa.PublicKey:
+		return JWK{
+			Kty: "RSA",
+			Kid: k.ID,
+			Use: "sig",
+			Alg: AlgRS256,
+			N:   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}, true
+	default:
+		return JWK{}, false
+	}
+}
+
+// JWKS returns every public key of the keyring, sorted by key id
+func (k Keyring) JWKS() JWKS {
+	jwks := JWKS{Keys: make([]JWK, 0)}
+	for _, key := range k.keys {
+		jwk, ok := key.JWK()
+		if ok {
+			jwks.Keys = append(jwks.Keys, jwk)
+		}
+	}
+	slices.SortFunc(jwks.Keys, func(a, b JWK) int {
+		return cmp.Compare(a.Kid, b.Kid)
+	})
+
+	return jwks
+}
+
+// Key returns the verification only key of the JWK,
+// only ed25519 (OKP) and rsa keys are supported
+func (j JWK) Key() (Key, error) {
+	switch {
+	case j.Kty == "OKP" && j.Crv == "Ed25519":
+		x, err := base64.RawURLEncoding.DecodeString(j.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return Key{}, fmt.Errorf("jwk key %q: invalid x: %w", j.Kid, ErrInvalidKey)
+		}
+		return NewPublicKey(j.Kid, ed25519.PublicKey(x))
+	case j.Kty == "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(j.N)
+		if err != nil {
+			return Key{}, fmt.Errorf("jwk key %q: invalid n: %w", j.Kid, ErrInvalidKey)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(j.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return Key{}, fmt.Errorf("jwk key %q: invalid e: %w", j.Kid, ErrInvalidKey)
+		}
+		return NewPublicKey(j.Kid, &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		})
+	default:
+		return Key{}, fmt.Errorf("jwk key %q: unsupported kty %q: %w", j.Kid, j.Kty, ErrInvalidKey)
+	}
+}
+
+// Keyring returns a verification only keyring of the signing keys of the JWKS,
+// keys that are not supported, not meant for signatures or without kid are skipped
+func (s JWKS) Keyring() (Keyring, error) {
+	var keys []Key
+	for _, jwk := range s.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		if jwk.Kid == "" {
+			continue
+		}
+		key, err := jwk.Key()
+		if err != nil {
+			continue
+		}
+		// a key published for another alg must not verify tokens with ours
+		if jwk.Alg != "" && jwk.Alg != key.Alg {
+			continue
+		}
+		keys = append(keys, key)
+	}
+
+	return NewVerificationKeyring(keys...)
+}
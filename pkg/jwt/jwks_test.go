@@ -0,0 +1,27 @@
+package jwt
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+)
+
+func TestJWKSKeyringSkipsKeysWithoutKid(t *testing.T) {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	withKid, _ := NewEd25519Key("key-1", private).JWK()
+	withoutKid, _ := NewEd25519Key("", private).JWK()
+
+	keyring, err := JWKS{Keys: []JWK{withoutKid, withKid}}.Keyring()
+	if err != nil {
+		t.Fatalf("Keyring(): %v", err)
+	}
+	if _, ok := keyring.Get("key-1"); !ok {
+		t.Fatal("key with kid has been skipped")
+	}
+	if _, ok := keyring.Get(""); ok {
+		t.Fatal("key without kid has been kept")
+	}
+}
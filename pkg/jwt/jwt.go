@@ -1,8 +1,6 @@
 package jwt
 
 import (
-	"crypto/hmac"
-	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
@@ -14,83 +12,150 @@ type (
 	Header struct {
 		Alg string `json:"alg"`
 		Typ string `json:"typ"`
+		Kid string `json:"kid,omitempty"`
+	}
+
+	// ValidationOptions configures the checks done by Parse on top of the signature.
+	// Issuer and Audience are only checked when they are not empty.
+	ValidationOptions struct {
+		Issuer   string
+		Audience string
+		// Leeway is the allowed clock skew for exp, nbf and iat
+		Leeway time.Duration
+		// Now defaults to time.Now
+		Now func() time.Time
 	}
 )
 
-func GenerateToken(duration time.Duration, secret string, p map[string]any) (string, error) {
-	header := []byte(`{"alg":"HS256","typ":"JWT"}`)
-	p["exp"] = int(time.Now().Add(duration).Unix())
-	payload, err := json.Marshal(p)
+// Sign signs the claims with the active key of the keyring
+func Sign[C Claims](keyring Keyring, claims C) (string, error) {
+	key := keyring.Active()
+	header, err := json.Marshal(Header{Alg: key.Alg, Typ: "JWT", Kid: key.ID})
 	if err != nil {
-		return "", fmt.Errorf("jwt generate token: %w", err)
+		return "", fmt.Errorf("jwt sign: %w", err)
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", fmt.Errorf("jwt sign: %w", err)
 	}
 	headerAndPayload := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
 
-	h := hmac.New(sha256.New, []byte(secret))
-	h.Write([]byte(headerAndPayload))
-	signature := h.Sum(nil)
+	signature, err := key.sign([]byte(headerAndPayload))
+	if err != nil {
+		return "", fmt.Errorf("jwt sign: %w", err)
+	}
 
 	return headerAndPayload + "." + base64.RawURLEncoding.EncodeToString(signature), nil
 }
 
-// IsTokenValid returns the token payload and boolean that will be true if the token is valid
-func IsTokenValid(token string, secret string) (map[string]any, bool) {
+// Parse verifies the token and decodes its claims. The token is verified with the keyring
+// key that matches its kid header, tokens without kid are verified with the active key,
+// or by a verification keyring with its only key of the alg of the token.
+// The returned error tells why the token has been rejected.
+func Parse[C Claims](token string, keyring Keyring, opts ValidationOptions) (C, error) {
+	var claims C
+
 	// jwt token must contain 3 elements separated by dot (.) header.payload.signature
 	elems := strings.Split(token, ".")
 	if len(elems) != 3 {
-		return nil, false
-	}
-
-	headerAndPayload := elems[0] + "." + elems[1]
-
-	// hmac(header.payload) must equal to signature
-	hash := hmac.New(sha256.New, []byte(secret))
-	hash.Write([]byte(headerAndPayload))
-	signature := hash.Sum(nil)
-	providedSignature, err := base64.RawURLEncoding.DecodeString(elems[2])
-	if err != nil {
-		return nil, false
-	}
-	if !hmac.Equal(signature, providedSignature) {
-		return nil, false
+		return claims, fmt.Errorf("jwt parse: token must have 3 parts: %w", ErrTokenMalformed)
 	}
 
 	// parse header
 	headerStr, err := base64.RawURLEncoding.DecodeString(elems[0])
 	if err != nil {
-		return nil, false
+		return claims, fmt.Errorf("jwt parse: decoding header: %w", ErrTokenMalformed)
 	}
 	var h Header
 	err = json.Unmarshal(headerStr, &h)
 	if err != nil {
-		return nil, false
+		return claims, fmt.Errorf("jwt parse: decoding header: %w", ErrTokenMalformed)
+	}
+
+	// typ is optional (RFC 7519), but when it is set we only accept JWT
+	if h.Typ != "" && h.Typ != "JWT" {
+		return claims, fmt.Errorf("jwt parse: unexpected typ %q: %w", h.Typ, ErrTokenMalformed)
 	}
 
-	// currently we only accept jwt token with alg HMAC SHA256 and type JWT
-	if h.Alg != "HS256" || h.Typ != "JWT" {
-		return nil, false
+	// pick the verification key by kid
+	key := keyring.Active()
+	switch {
+	case h.Kid != "":
+		var ok bool
+		key, ok = keyring.Get(h.Kid)
+		if !ok {
+			return claims, fmt.Errorf("jwt parse: unknown kid %q: %w", h.Kid, ErrTokenUnverifiable)
+		}
+	case keyring.activeID == "":
+		var ok bool
+		key, ok = keyring.soleKey(h.Alg)
+		if !ok {
+			return claims, fmt.Errorf("jwt parse: missing kid and no single key for alg %q: %w", h.Alg, ErrTokenUnverifiable)
+		}
+	}
+
+	// the alg is bound to the key, a token could not downgrade to another alg
+	// (e.g. none, or HS256 with the public key used as secret)
+	if h.Alg != key.Alg {
+		return claims, fmt.Errorf("jwt parse: alg %q does not match key %q: %w", h.Alg, key.ID, ErrTokenUnverifiable)
+	}
+
+	// signature must be valid for header.payload
+	providedSignature, err := base64.RawURLEncoding.DecodeString(elems[2])
+	if err != nil {
+		return claims, fmt.Errorf("jwt parse: decoding signature: %w", ErrTokenMalformed)
+	}
+	if !key.verify([]byte(elems[0]+"."+elems[1]), providedSignature) {
+		return claims, fmt.Errorf("jwt parse: %w", ErrTokenSignatureInvalid)
 	}
 
 	// parse payload
 	payloadStr, err := base64.RawURLEncoding.DecodeString(elems[1])
 	if err != nil {
-		return nil, false
+		return claims, fmt.Errorf("jwt parse: decoding payload: %w", ErrTokenMalformed)
 	}
-	var p map[string]any
-	err = json.Unmarshal(payloadStr, &p)
+	err = json.Unmarshal(payloadStr, &claims)
 	if err != nil {
-		return nil, false
+		return claims, fmt.Errorf("jwt parse: decoding payload: %w", ErrTokenMalformed)
+	}
+
+	err = validate(claims.Registered(), opts)
+	if err != nil {
+		return claims, fmt.Errorf("jwt parse: %w", err)
+	}
+
+	return claims, nil
+}
+
+func validate(c RegisteredClaims, opts ValidationOptions) error {
+	now := time.Now()
+	if opts.Now != nil {
+		now = opts.Now()
 	}
 
-	// check if payload exp already expires
-	exp, ok := p["exp"].(float64)
-	if !ok {
-		return nil, false
+	// exp is required, tokens must not live forever
+	if c.ExpiresAt == 0 {
+		return fmt.Errorf("missing exp: %w", ErrTokenExpired)
+	}
+	if now.After(time.Unix(c.ExpiresAt, 0).Add(opts.Leeway)) {
+		return ErrTokenExpired
 	}
-	expTime := time.Unix(int64(exp), 0)
-	if time.Now().After(expTime) {
-		return nil, false
+
+	if c.NotBefore != 0 && now.Before(time.Unix(c.NotBefore, 0).Add(-opts.Leeway)) {
+		return ErrTokenNotValidYet
+	}
+
+	if c.IssuedAt != 0 && now.Before(time.Unix(c.IssuedAt, 0).Add(-opts.Leeway)) {
+		return ErrTokenUsedBeforeIssued
+	}
+
+	if opts.Issuer != "" && c.Issuer != opts.Issuer {
+		return fmt.Errorf("unexpected iss %q: %w", c.Issuer, ErrTokenInvalidIssuer)
+	}
+
+	if opts.Audience != "" && !c.Audience.Contains(opts.Audience) {
+		return fmt.Errorf("unexpected aud %q: %w", c.Audience, ErrTokenInvalidAudience)
 	}
 
-	return p, true
+	return nil
 }
@@ -0,0 +1,184 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+type (
+	// Key is a single signing or verification key. The algorithm is bound to
+	// the key, so a token could never pick how its own signature is checked.
+	Key struct {
+		ID  string
+		Alg string
+
+		secret  []byte
+		private crypto.Signer
+		public  crypto.PublicKey
+	}
+)
+
+const (
+	AlgHS256 = "HS256"
+	AlgRS256 = "RS256"
+	AlgEdDSA = "EdDSA"
+
+	minRSAKeyBits = 2048
+)
+
+var (
+	ErrInvalidKey = errors.New("invalid jwt key")
+)
+
+func NewHMACKey(id string, secret []byte) Key {
+	return Key{ID: id, Alg: AlgHS256, secret: secret}
+}
+
+func NewEd25519Key(id string, private ed25519.PrivateKey) Key {
+	return Key{ID: id, Alg: AlgEdDSA, private: private, public: private.Public()}
+}
+
+func NewRSAKey(id string, private *rsa.PrivateKey) Key {
+	return Key{ID: id, Alg: AlgRS256, private: private, public: private.Public()}
+}
+
+// NewPublicKey creates a verification only key from an ed25519 or rsa public key
+func NewPublicKey(id string, public crypto.PublicKey) (Key, error) {
+	switch pub := public.(type) {
+	case ed25519.PublicKey:
+		return Key{ID: id, Alg: AlgEdDSA, public: pub}, nil
+	case *rsa.PublicKey:
+		if pub.N.BitLen() < minRSAKeyBits {
+			return Key{}, fmt.Errorf("new public key: rsa key must be at least %d bits: %w", minRSAKeyBits, ErrInvalidKey)
+		}
+		return Key{ID: id, Alg: AlgRS256, public: pub}, nil
+	default:
+		return Key{}, fmt.Errorf("new public key: unsupported key type %T: %w", public, ErrInvalidKey)
+	}
+}
+
+// ParsePEMKey parses a PKCS#8 or PKCS#1 private key, or a PKIX public key for
+// verification only keys. Only ed25519 and rsa keys are supported.
+func ParsePEMKey(id string, data []byte) (Key, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return Key{}, fmt.Errorf("parse pem key %q: no pem block found: %w", id, ErrInvalidKey)
+	}
+
+	switch block.Type {
+	case "PRIVATE KEY":
+		private, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return Key{}, fmt.Errorf("parse pem key %q: %w", id, err)
+		}
+		switch priv := private.(type) {
+		case ed25519.PrivateKey:
+			return NewEd25519Key(id, priv), nil
+		case *rsa.PrivateKey:
+			return newCheckedRSAKey(id, priv)
+		default:
+			return Key{}, fmt.Errorf("parse pem key %q: unsupported key type %T: %w", id, private, ErrInvalidKey)
+		}
+	case "RSA PRIVATE KEY":
+		priv, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return Key{}, fmt.Errorf("parse pem key %q: %w", id, err)
+		}
+		return newCheckedRSAKey(id, priv)
+	case "PUBLIC KEY":
+		public, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return Key{}, fmt.Errorf("parse pem key %q: %w", id, err)
+		}
+		return NewPublicKey(id, public)
+	default:
+		return Key{}, fmt.Errorf("parse pem key %q: unsupported pem block %q: %w", id, block.Type, ErrInvalidKey)
+	}
+}
+
+// LoadKeyFiles loads pem keys written as comma separated kid:path pairs
+func LoadKeyFiles(s string) ([]Key, error) {
+	var keys []Key
+	for _, pair := range strings.Split(s, ",") {
+		id, path, ok := strings.Cut(strings.TrimSpace(pair), ":")
+		if !ok || id == "" || path == "" {
+			return nil, fmt.Errorf("load key files: key file must be written as kid:path: %w", ErrInvalidKeyring)
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("load key files: %w", err)
+		}
+
+		key, err := ParsePEMKey(id, data)
+		if err != nil {
+			return nil, fmt.Errorf("load key files: %w", err)
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+// CanSign reports whether the key could be used to sign tokens
+func (k Key) CanSign() bool {
+	return len(k.secret) > 0 || k.private != nil
+}
+
+func (k Key) sign(data []byte) ([]byte, error) {
+	switch {
+	case k.Alg == AlgHS256 && len(k.secret) > 0:
+		h := hmac.New(sha256.New, k.secret)
+		h.Write(data)
+		return h.Sum(nil), nil
+	case k.Alg == AlgEdDSA && k.private != nil:
+		return k.private.Sign(rand.Reader, data, crypto.Hash(0))
+	case k.Alg == AlgRS256 && k.private != nil:
+		digest := sha256.Sum256(data)
+		return k.private.Sign(rand.Reader, digest[:], crypto.SHA256)
+	default:
+		return nil, fmt.Errorf("sign with key %q: %w", k.ID, ErrInvalidKey)
+	}
+}
+
+func (k Key) verify(data []byte, signature []byte) bool {
+	switch k.Alg {
+	case AlgHS256:
+		if len(k.secret) == 0 {
+			return false
+		}
+		h := hmac.New(sha256.New, k.secret)
+		h.Write(data)
+		return hmac.Equal(h.Sum(nil), signature)
+	case AlgEdDSA:
+		pub, ok := k.public.(ed25519.PublicKey)
+		return ok && ed25519.Verify(pub, data, signature)
+	case AlgRS256:
+		pub, ok := k.public.(*rsa.PublicKey)
+		if !ok {
+			return false
+		}
+		digest := sha256.Sum256(data)
+		return rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], signature) == nil
+	default:
+		return false
+	}
+}
+
+func newCheckedRSAKey(id string, private *rsa.PrivateKey) (Key, error) {
+	if private.N.BitLen() < minRSAKeyBits {
+		return Key{}, fmt.Errorf("parse pem key %q: rsa key must be at least %d bits: %w", id, minRSAKeyBits, ErrInvalidKey)
+	}
+
+	return NewRSAKey(id, private), nil
+}
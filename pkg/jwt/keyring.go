@@ -0,0 +1,104 @@
+package jwt
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+type (
+	// Keyring holds one active key that is used for signing and any number of
+	// keys that are only used for verification, so secrets could be rotated
+	// without invalidating the tokens signed by the previous key.
+	Keyring struct {
+		activeID string
+		keys     map[string]Key
+	}
+)
+
+var (
+	ErrInvalidKeyring = errors.New("invalid jwt keyring")
+)
+
+func NewKeyring(activeID string, keys ...Key) (Keyring, error) {
+	k := Keyring{activeID: activeID, keys: make(map[string]Key, len(keys))}
+	for _, key := range keys {
+		if key.ID == "" {
+			return Keyring{}, fmt.Errorf("new keyring: key id must not be empty: %w", ErrInvalidKeyring)
+		}
+		if _, ok := k.keys[key.ID]; ok {
+			return Keyring{}, fmt.Errorf("new keyring: duplicate key id %q: %w", key.ID, ErrInvalidKeyring)
+		}
+		k.keys[key.ID] = key
+	}
+
+	active, ok := k.keys[activeID]
+	if !ok {
+		return Keyring{}, fmt.Errorf("new keyring: active key %q not found: %w", activeID, ErrInvalidKeyring)
+	}
+	if !active.CanSign() {
+		return Keyring{}, fmt.Errorf("new keyring: active key %q could not sign: %w", activeID, ErrInvalidKeyring)
+	}
+
+	return k, nil
+}
+
+// NewVerificationKeyring creates a keyring without an active key, e.g. from the JWKS
+// of another issuer. It could not sign, and verifies a token without kid only when a
+// single key has the alg of the token.
+func NewVerificationKeyring(keys ...Key) (Keyring, error) {
+	k := Keyring{keys: make(map[string]Key, len(keys))}
+	for _, key := range keys {
+		if key.ID == "" {
+			return Keyring{}, fmt.Errorf("new verification keyring: key id must not be empty: %w", ErrInvalidKeyring)
+		}
+		if _, ok := k.keys[key.ID]; ok {
+			return Keyring{}, fmt.Errorf("new verification keyring: duplicate key id %q: %w", key.ID, ErrInvalidKeyring)
+		}
+		k.keys[key.ID] = key
+	}
+
+	return k, nil
+}
+
+// ParseKeys parses HMAC keys written as comma separated kid:secret pairs
+func ParseKeys(s string) ([]Key, error) {
+	var keys []Key
+	for _, pair := range strings.Split(s, ",") {
+		id, secret, ok := strings.Cut(strings.TrimSpace(pair), ":")
+		if !ok || id == "" || secret == "" {
+			return nil, fmt.Errorf("parse keys: key must be written as kid:secret: %w", ErrInvalidKeyring)
+		}
+		keys = append(keys, NewHMACKey(id, []byte(secret)))
+	}
+
+	return keys, nil
+}
+
+// Active returns the key that is used to sign new tokens
+func (k Keyring) Active() Key {
+	return k.keys[k.activeID]
+}
+
+// Get returns the key with the given id
+func (k Keyring) Get(id string) (Key, bool) {
+	key, ok := k.keys[id]
+	return key, ok
+}
+
+// soleKey returns the key with the alg when it is the only one,
+// otherwise a token without kid could be verified by the wrong key
+func (k Keyring) soleKey(alg string) (Key, bool) {
+	var (
+		sole  Key
+		found int
+	)
+	for _, key := range k.keys {
+		if key.Alg == alg {
+			sole = key
+			found++
+		}
+	}
+
+	return sole, found == 1
+}
@@ -0,0 +1,76 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// File writes every message as an .eml file into a directory, useful for local development
+	File struct {
+		dir string
+	}
+
+	// Memory keeps every message in memory, useful for tests
+	Memory struct {
+		mu   sync.Mutex
+		msgs []Message
+	}
+)
+
+func NewFile(dir string) File {
+	return File{dir: dir}
+}
+
+func (f File) Send(ctx context.Context, msg Message) error {
+	body, err := format("", msg)
+	if err != nil {
+		return fmt.Errorf("file send mail: %w", err)
+	}
+
+	err = os.MkdirAll(f.dir, 0o755)
+	if err != nil {
+		return fmt.Errorf("file send mail: %w", err)
+	}
+
+	name := strconv.FormatInt(time.Now().UnixNano(), 10) + "-" + strings.NewReplacer("/", "_", "\\", "_").Replace(msg.To) + ".eml"
+	err = os.WriteFile(filepath.Join(f.dir, name), body, 0o600)
+	if err != nil {
+		return fmt.Errorf("file send mail: %w", err)
+	}
+
+	return nil
+}
+
+func NewMemory() *Memory {
+	return &Memory{}
+}
+
+func (m *Memory) Send(ctx context.Context, msg Message) error {
+	_, err := format("", msg)
+	if err != nil {
+		return fmt.Errorf("memory send mail: %w", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.msgs = append(m.msgs, msg)
+
+	return nil
+}
+
+// Messages returns every message sent so far
+func (m *Memory) Messages() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	msgs := make([]Message, len(m.msgs))
+	copy(msgs, m.msgs)
+	return msgs
+}
@@ -0,0 +1,52 @@
+package mailer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+type (
+	Message struct {
+		To      string
+		Subject string
+		Body    string
+	}
+
+	Mailer interface {
+		Send(ctx context.Context, msg Message) error
+	}
+)
+
+var (
+	ErrInvalidMessage = errors.New("invalid mail message")
+)
+
+// format renders the message as a plain text RFC 5322 email
+func format(from string, msg Message) ([]byte, error) {
+	// header values must not contain line breaks, otherwise extra headers could be injected
+	for _, v := range []string{from, msg.To, msg.Subject} {
+		if strings.ContainsAny(v, "\r\n") {
+			return nil, fmt.Errorf("format mail: header contains line break: %w", ErrInvalidMessage)
+		}
+	}
+	if msg.To == "" {
+		return nil, fmt.Errorf("format mail: recipient is empty: %w", ErrInvalidMessage)
+	}
+
+	var b strings.Builder
+	if from != "" {
+		b.WriteString("From: " + from + "\r\n")
+	}
+	b.WriteString("To: " + msg.To + "\r\n")
+	b.WriteString("Subject: " + msg.Subject + "\r\n")
+	b.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+
+	return []byte(b.String()), nil
+}
@@ -0,0 +1,41 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/smtp"
+)
+
+type (
+	SMTP struct {
+		addr string
+		from string
+		auth smtp.Auth
+	}
+)
+
+// NewSMTP creates a mailer that sends through an SMTP server,
+// authentication is skipped when username is empty
+func NewSMTP(host, port, username, password, from string) SMTP {
+	var auth smtp.Auth
+	if username != "" {
+		auth = smtp.PlainAuth("", username, password, host)
+	}
+
+	return SMTP{addr: net.JoinHostPort(host, port), from: from, auth: auth}
+}
+
+func (s SMTP) Send(ctx context.Context, msg Message) error {
+	body, err := format(s.from, msg)
+	if err != nil {
+		return fmt.Errorf("smtp send mail: %w", err)
+	}
+
+	err = smtp.SendMail(s.addr, s.auth, s.from, []string{msg.To}, body)
+	if err != nil {
+		return fmt.Errorf("smtp send mail: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,10 @@
+package oidc
+
+import "errors"
+
+var (
+	ErrDiscovery       = errors.New("oidc discovery failed")
+	ErrExchange        = errors.New("oidc code exchange failed")
+	ErrInvalidIDToken  = errors.New("invalid oidc id token")
+	ErrNonceMismatched = errors.New("oidc id token nonce does not match")
+)
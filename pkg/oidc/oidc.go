@@ -0,0 +1,277 @@
+// Package oidc implements the client side of the OpenID Connect authorization code
+// flow with PKCE. ID tokens are verified with pkg/jwt against the JWKS of the provider.
+package oidc
+
+import (
+	"catsocial/pkg/jwt"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	Config struct {
+		Issuer       string
+		ClientID     string
+		ClientSecret string
+		RedirectURL  string
+		// Scopes defaults to openid, email and profile
+		Scopes []string
+		// HTTPClient defaults to a client with a 10 seconds timeout
+		HTTPClient *http.Client
+		// Leeway is the allowed clock skew when validating id tokens
+		Leeway time.Duration
+	}
+
+	// Metadata is the part of the discovery document the client needs
+	Metadata struct {
+		Issuer                string `json:"issuer"`
+		AuthorizationEndpoint string `json:"authorization_endpoint"`
+		TokenEndpoint         string `json:"token_endpoint"`
+		JWKSURI               string `json:"jwks_uri"`
+	}
+
+	IDClaims struct {
+		jwt.RegisteredClaims
+		Nonce         string `json:"nonce"`
+		Email         string `json:"email"`
+		EmailVerified bool   `json:"email_verified"`
+		Name          string `json:"name"`
+	}
+
+	// Client discovers the provider on first use, and refetches its keys when an
+	// id token is signed by a key it does not know yet
+	Client struct {
+		cfg Config
+
+		mu            sync.Mutex
+		metadata      *Metadata
+		keyring       jwt.Keyring
+		keysFetchedAt time.Time
+	}
+)
+
+// minKeysRefetchInterval stops tokens with unknown kids from hammering the provider
+const minKeysRefetchInterval = time.Minute
+
+func NewClient(cfg Config) *Client {
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"openid", "email", "profile"}
+	}
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+	}
+	cfg.Issuer = strings.TrimSuffix(cfg.Issuer, "/")
+
+	return &Client{cfg: cfg}
+}
+
+func (c *Client) RedirectURL() string {
+	return c.cfg.RedirectURL
+}
+
+func (c *Client) Issuer() string {
+	return c.cfg.Issuer
+}
+
+// AuthCodeURL returns the url of the provider the user is redirected to
+func (c *Client) AuthCodeURL(ctx context.Context, state, nonce, codeChallenge string) (string, error) {
+	m, err := c.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	q := url.Values{}
+	q.Set("response_type", "code")
+	q.Set("client_id", c.cfg.ClientID)
+	q.Set("redirect_uri", c.cfg.RedirectURL)
+	q.Set("scope", strings.Join(c.cfg.Scopes, " "))
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	q.Set("code_challenge", codeChallenge)
+	q.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(m.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return m.AuthorizationEndpoint + sep + q.Encode(), nil
+}
+
+// Exchange trades the authorization code for the tokens of the user, and returns
+// the claims of the verified id token
+func (c *Client) Exchange(ctx context.Context, code, codeVerifier, nonce string) (IDClaims, error) {
+	m, err := c.discover(ctx)
+	if err != nil {
+		return IDClaims{}, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", c.cfg.RedirectURL)
+	form.Set("code_verifier", codeVerifier)
+	// public clients have no secret and identify themselves in the form
+	if c.cfg.ClientSecret == "" {
+		form.Set("client_id", c.cfg.ClientID)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return IDClaims{}, fmt.Errorf("oidc exchange: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if c.cfg.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(c.cfg.ClientID), url.QueryEscape(c.cfg.ClientSecret))
+	}
+
+	var tokenResp struct {
+		IDToken string `json:"id_token"`
+	}
+	err = c.doJSON(req, &tokenResp)
+	if err != nil {
+		return IDClaims{}, fmt.Errorf("oidc exchange: %w: %w", ErrExchange, err)
+	}
+	if tokenResp.IDToken == "" {
+		return IDClaims{}, fmt.Errorf("oidc exchange: missing id_token: %w", ErrExchange)
+	}
+
+	claims, err := c.VerifyIDToken(ctx, tokenResp.IDToken, nonce)
+	if err != nil {
+		return IDClaims{}, fmt.Errorf("oidc exchange: %w", err)
+	}
+
+	return claims, nil
+}
+
+// VerifyIDToken checks the signature, the issuer, the audience, the expiry and the nonce
+// of the id token
+func (c *Client) VerifyIDToken(ctx context.Context, rawIDToken, nonce string) (IDClaims, error) {
+	m, err := c.discover(ctx)
+	if err != nil {
+		return IDClaims{}, err
+	}
+
+	opts := jwt.ValidationOptions{
+		Issuer:   m.Issuer,
+		Audience: c.cfg.ClientID,
+		Leeway:   c.cfg.Leeway,
+	}
+
+	keyring, err := c.keys(ctx, false)
+	if err != nil {
+		return IDClaims{}, err
+	}
+	claims, err := jwt.Parse[IDClaims](rawIDToken, keyring, opts)
+	// the provider may have rotated its keys
+	if errors.Is(err, jwt.ErrTokenUnverifiable) {
+		keyring, err = c.keys(ctx, true)
+		if err != nil {
+			return IDClaims{}, err
+		}
+		claims, err = jwt.Parse[IDClaims](rawIDToken, keyring, opts)
+	}
+	if err != nil {
+		return IDClaims{}, fmt.Errorf("oidc verify id token: %w: %w", ErrInvalidIDToken, err)
+	}
+	if claims.Subject == "" {
+		return IDClaims{}, fmt.Errorf("oidc verify id token: missing sub: %w", ErrInvalidIDToken)
+	}
+	if claims.Nonce != nonce {
+		return IDClaims{}, fmt.Errorf("oidc verify id token: %w", ErrNonceMismatched)
+	}
+
+	return claims, nil
+}
+
+func (c *Client) discover(ctx context.Context) (Metadata, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.metadata != nil {
+		return *c.metadata, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.cfg.Issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return Metadata{}, fmt.Errorf("oidc discover: %w", err)
+	}
+
+	var m Metadata
+	err = c.doJSON(req, &m)
+	if err != nil {
+		return Metadata{}, fmt.Errorf("oidc discover: %w: %w", ErrDiscovery, err)
+	}
+	// the issuer must be the one we have been configured with (OpenID Connect Discovery 4.3)
+	if strings.TrimSuffix(m.Issuer, "/") != c.cfg.Issuer {
+		return Metadata{}, fmt.Errorf("oidc discover: unexpected issuer %q: %w", m.Issuer, ErrDiscovery)
+	}
+	if m.AuthorizationEndpoint == "" || m.TokenEndpoint == "" || m.JWKSURI == "" {
+		return Metadata{}, fmt.Errorf("oidc discover: missing endpoints: %w", ErrDiscovery)
+	}
+
+	c.metadata = &m
+	return m, nil
+}
+
+// keys returns the cached keys of the provider, refetching them when asked to
+// and they have not been fetched recently
+func (c *Client) keys(ctx context.Context, refetch bool) (jwt.Keyring, error) {
+	m, err := c.discover(ctx)
+	if err != nil {
+		return jwt.Keyring{}, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	fetched := !c.keysFetchedAt.IsZero()
+	if fetched && (!refetch || time.Since(c.keysFetchedAt) < minKeysRefetchInterval) {
+		return c.keyring, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, m.JWKSURI, nil)
+	if err != nil {
+		return jwt.Keyring{}, fmt.Errorf("oidc fetch keys: %w", err)
+	}
+
+	var jwks jwt.JWKS
+	err = c.doJSON(req, &jwks)
+	if err != nil {
+		return jwt.Keyring{}, fmt.Errorf("oidc fetch keys: %w: %w", ErrDiscovery, err)
+	}
+	keyring, err := jwks.Keyring()
+	if err != nil {
+		return jwt.Keyring{}, fmt.Errorf("oidc fetch keys: %w: %w", ErrDiscovery, err)
+	}
+
+	c.keyring = keyring
+	c.keysFetchedAt = time.Now()
+	return keyring, nil
+}
+
+func (c *Client) doJSON(req *http.Request, v any) error {
+	res, err := c.cfg.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d from %s", res.StatusCode, req.URL.Redacted())
+	}
+
+	return json.Unmarshal(body, v)
+}
@@ -0,0 +1,153 @@
+package oidc
+
+import (
+	"catsocial/pkg/oidc/oidctest"
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func newTestClient(p *oidctest.Provider) *Client {
+	return NewClient(Config{
+		Issuer:       p.Issuer(),
+		ClientID:     p.ClientID,
+		ClientSecret: p.ClientSecret,
+		RedirectURL:  "http://localhost/v1/user/oidc/callback",
+	})
+}
+
+// login runs the authorization code flow up to the exchange, with the nonce
+// and the verifier given to the exchange instead of the ones of the authorization
+func login(t *testing.T, p *oidctest.Provider, c *Client, nonce, verifier string) (IDClaims, error) {
+	t.Helper()
+
+	authVerifier, challenge, err := NewPKCE()
+	if err != nil {
+		t.Fatal(err)
+	}
+	authURL, err := c.AuthCodeURL(context.Background(), "state-1", "nonce-1", challenge)
+	if err != nil {
+		t.Fatalf("AuthCodeURL(): %v", err)
+	}
+	code, state, err := p.Authorize(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state != "state-1" {
+		t.Fatalf("state = %q, want %q", state, "state-1")
+	}
+
+	if nonce == "" {
+		nonce = "nonce-1"
+	}
+	if verifier == "" {
+		verifier = authVerifier
+	}
+	return c.Exchange(context.Background(), code, verifier, nonce)
+}
+
+func TestExchange(t *testing.T) {
+	tests := []struct {
+		name         string
+		clientSecret string
+	}{
+		{name: "confidential client", clientSecret: "s3cret/+"},
+		{name: "public client"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := oidctest.NewProvider("catsocial", tt.clientSecret)
+			defer p.Close()
+			p.SetIdentity(oidctest.Identity{Subject: "sub-42", Email: "tom@example.com", EmailVerified: true, Name: "Tom Cat"})
+
+			claims, err := login(t, p, newTestClient(p), "", "")
+			if err != nil {
+				t.Fatalf("Exchange(): %v", err)
+			}
+			if claims.Subject != "sub-42" || claims.Email != "tom@example.com" || !claims.EmailVerified || claims.Name != "Tom Cat" {
+				t.Fatalf("Exchange() = %+v", claims)
+			}
+			if claims.Nonce != "nonce-1" {
+				t.Fatalf("nonce = %q, want %q", claims.Nonce, "nonce-1")
+			}
+		})
+	}
+}
+
+func TestExchangeWrongCodeVerifier(t *testing.T) {
+	p := oidctest.NewProvider("catsocial", "")
+	defer p.Close()
+
+	_, err := login(t, p, newTestClient(p), "", "not-the-verifier")
+	if !errors.Is(err, ErrExchange) {
+		t.Fatalf("Exchange() error = %v, want %v", err, ErrExchange)
+	}
+}
+
+func TestExchangeNonceMismatched(t *testing.T) {
+	p := oidctest.NewProvider("catsocial", "")
+	defer p.Close()
+
+	_, err := login(t, p, newTestClient(p), "another-nonce", "")
+	if !errors.Is(err, ErrNonceMismatched) {
+		t.Fatalf("Exchange() error = %v, want %v", err, ErrNonceMismatched)
+	}
+}
+
+func TestExchangeRefetchesKeysOnUnknownKid(t *testing.T) {
+	p := oidctest.NewProvider("catsocial", "")
+	defer p.Close()
+	c := newTestClient(p)
+
+	_, err := login(t, p, c, "", "")
+	if err != nil {
+		t.Fatalf("Exchange(): %v", err)
+	}
+
+	// a rotation right after the keys have been fetched is not picked up,
+	// so unknown kids could not make the client hammer the provider
+	p.AddKey("key-2")
+	_, err = login(t, p, c, "", "")
+	if !errors.Is(err, ErrInvalidIDToken) {
+		t.Fatalf("Exchange() error = %v, want %v", err, ErrInvalidIDToken)
+	}
+	if got := p.KeyRequests(); got != 1 {
+		t.Fatalf("key requests = %d, want 1", got)
+	}
+
+	c.mu.Lock()
+	c.keysFetchedAt = time.Now().Add(-minKeysRefetchInterval)
+	c.mu.Unlock()
+
+	_, err = login(t, p, c, "", "")
+	if err != nil {
+		t.Fatalf("Exchange() after rotation: %v", err)
+	}
+	if got := p.KeyRequests(); got != 2 {
+		t.Fatalf("key requests = %d, want 2", got)
+	}
+}
+
+func TestExchangeWithoutKid(t *testing.T) {
+	p := oidctest.NewProvider("catsocial", "")
+	defer p.Close()
+	p.OmitKid(true)
+	c := newTestClient(p)
+
+	_, err := login(t, p, c, "", "")
+	if err != nil {
+		t.Fatalf("Exchange() with a single key: %v", err)
+	}
+
+	// with two keys of the same alg the token could be checked against the wrong one
+	p.AddKey("key-2")
+	c.mu.Lock()
+	c.keysFetchedAt = time.Now().Add(-minKeysRefetchInterval)
+	c.mu.Unlock()
+
+	_, err = login(t, p, c, "", "")
+	if !errors.Is(err, ErrInvalidIDToken) {
+		t.Fatalf("Exchange() with two keys error = %v, want %v", err, ErrInvalidIDToken)
+	}
+}
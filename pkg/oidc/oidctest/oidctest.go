@@ -0,0 +1,345 @@
+// Package oidctest runs a small OpenID Connect provider in process, so the
+// authorization code flow with PKCE could be tested without a real provider.
+// It serves the discovery document, the JWKS, an authorization endpoint that
+// logs in a fixed identity right away, and the token endpoint.
+package oidctest
+
+import (
+	"catsocial/pkg/jwt"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+)
+
+type (
+	// Identity is the user the provider logs in
+	Identity struct {
+		Subject       string
+		Email         string
+		EmailVerified bool
+		Name          string
+	}
+
+	Provider struct {
+		ClientID     string
+		ClientSecret string
+
+		server *httptest.Server
+
+		mu             sync.Mutex
+		identity       Identity
+		keys           []signingKey
+		omitKid        bool
+		nonce          *string
+		authorizations map[string]authorization
+		keyRequests    int
+	}
+
+	signingKey struct {
+		id      string
+		private ed25519.PrivateKey
+	}
+
+	authorization struct {
+		redirectURI   string
+		codeChallenge string
+		nonce         string
+		identity      Identity
+	}
+
+	idClaims struct {
+		jwt.RegisteredClaims
+		Nonce         string `json:"nonce,omitempty"`
+		Email         string `json:"email,omitempty"`
+		EmailVerified bool   `json:"email_verified"`
+		Name          string `json:"name,omitempty"`
+	}
+)
+
+// NewProvider starts a provider for the client, an empty clientSecret makes it
+// a public client. The provider signs with a single key "key-1" and must be closed.
+func NewProvider(clientID, clientSecret string) *Provider {
+	p := &Provider{
+		ClientID:       clientID,
+		ClientSecret:   clientSecret,
+		identity:       Identity{Subject: "subject-1", Email: "kitty@example.com", EmailVerified: true, Name: "Kitty Owner"},
+		authorizations: make(map[string]authorization),
+	}
+	p.AddKey("key-1")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", p.discoveryHandler)
+	mux.HandleFunc("GET /jwks", p.jwksHandler)
+	mux.HandleFunc("GET /authorize", p.authorizeHandler)
+	mux.HandleFunc("POST /token", p.tokenHandler)
+	p.server = httptest.NewServer(mux)
+
+	return p
+}
+
+func (p *Provider) Close() {
+	p.server.Close()
+}
+
+// Issuer is the url the client discovers the provider with
+func (p *Provider) Issuer() string {
+	return p.server.URL
+}
+
+// SetIdentity changes the user the next authorizations log in
+func (p *Provider) SetIdentity(identity Identity) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.identity = identity
+}
+
+// AddKey publishes a new key and signs the next id tokens with it, the previous
+// keys stay published
+func (p *Provider) AddKey(kid string) {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		panic(fmt.Sprintf("oidctest: generate key: %s", err.Error()))
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.keys = append(p.keys, signingKey{id: kid, private: private})
+}
+
+// OmitKid leaves the kid out of the header of the next id tokens
+func (p *Provider) OmitKid(omit bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.omitKid = omit
+}
+
+// OverrideNonce puts nonce into the next id tokens instead of the nonce of the authorization
+func (p *Provider) OverrideNonce(nonce string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.nonce = &nonce
+}
+
+// KeyRequests returns how many times the JWKS has been fetched
+func (p *Provider) KeyRequests() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.keyRequests
+}
+
+// Authorize follows the authorization url the client has built like a browser would,
+// and returns the code and the state the provider redirects back with
+func (p *Provider) Authorize(authURL string) (code string, state string, err error) {
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	res, err := client.Get(authURL)
+	if err != nil {
+		return "", "", fmt.Errorf("oidctest authorize: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusFound {
+		return "", "", fmt.Errorf("oidctest authorize: unexpected status %d", res.StatusCode)
+	}
+
+	location, err := url.Parse(res.Header.Get("Location"))
+	if err != nil {
+		return "", "", fmt.Errorf("oidctest authorize: %w", err)
+	}
+
+	return location.Query().Get("code"), location.Query().Get("state"), nil
+}
+
+func (p *Provider) discoveryHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{
+		"issuer":                 p.server.URL,
+		"authorization_endpoint": p.server.URL + "/authorize",
+		"token_endpoint":         p.server.URL + "/token",
+		"jwks_uri":               p.server.URL + "/jwks",
+	})
+}
+
+func (p *Provider) jwksHandler(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.keyRequests++
+	jwks := jwt.JWKS{Keys: make([]jwt.JWK, 0, len(p.keys))}
+	for _, k := range p.keys {
+		jwk, _ := jwt.NewEd25519Key(k.id, k.private).JWK()
+		jwks.Keys = append(jwks.Keys, jwk)
+	}
+	writeJSON(w, http.StatusOK, jwks)
+}
+
+func (p *Provider) authorizeHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("response_type") != "code" || q.Get("client_id") != p.ClientID {
+		http.Error(w, "unexpected response type or client", http.StatusBadRequest)
+		return
+	}
+	if q.Get("code_challenge") == "" || q.Get("code_challenge_method") != "S256" {
+		http.Error(w, "pkce with S256 is required", http.StatusBadRequest)
+		return
+	}
+	redirectURI, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil || q.Get("redirect_uri") == "" {
+		http.Error(w, "invalid redirect uri", http.StatusBadRequest)
+		return
+	}
+
+	code := randomString()
+	p.mu.Lock()
+	p.authorizations[code] = authorization{
+		redirectURI:   q.Get("redirect_uri"),
+		codeChallenge: q.Get("code_challenge"),
+		nonce:         q.Get("nonce"),
+		identity:      p.identity,
+	}
+	p.mu.Unlock()
+
+	back := redirectURI.Query()
+	back.Set("code", code)
+	back.Set("state", q.Get("state"))
+	redirectURI.RawQuery = back.Encode()
+	http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+}
+
+func (p *Provider) tokenHandler(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil || r.PostForm.Get("grant_type") != "authorization_code" {
+		writeTokenError(w, http.StatusBadRequest, "unsupported_grant_type")
+		return
+	}
+	if !p.authenticateClient(r) {
+		writeTokenError(w, http.StatusUnauthorized, "invalid_client")
+		return
+	}
+
+	// codes are single use, even when the exchange fails
+	p.mu.Lock()
+	authz, ok := p.authorizations[r.PostForm.Get("code")]
+	delete(p.authorizations, r.PostForm.Get("code"))
+	p.mu.Unlock()
+	if !ok || authz.redirectURI != r.PostForm.Get("redirect_uri") {
+		writeTokenError(w, http.StatusBadRequest, "invalid_grant")
+		return
+	}
+
+	// RFC 7636 4.6, the verifier must hash into the challenge of the authorization
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	challenge := base64.RawURLEncoding.EncodeToString(sum[:])
+	if subtle.ConstantTimeCompare([]byte(challenge), []byte(authz.codeChallenge)) != 1 {
+		writeTokenError(w, http.StatusBadRequest, "invalid_grant")
+		return
+	}
+
+	idToken, err := p.signIDToken(authz)
+	if err != nil {
+		writeTokenError(w, http.StatusInternalServerError, "server_error")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"access_token": randomString(),
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     idToken,
+	})
+}
+
+func (p *Provider) authenticateClient(r *http.Request) bool {
+	if p.ClientSecret == "" {
+		return r.PostForm.Get("client_id") == p.ClientID
+	}
+
+	// RFC 6749 2.3.1, the credentials are form encoded before basic auth
+	id, secret, ok := r.BasicAuth()
+	if !ok {
+		return false
+	}
+	id, errID := url.QueryUnescape(id)
+	secret, errSecret := url.QueryUnescape(secret)
+	return errors.Join(errID, errSecret) == nil && id == p.ClientID && secret == p.ClientSecret
+}
+
+func (p *Provider) signIDToken(authz authorization) (string, error) {
+	p.mu.Lock()
+	key := p.keys[len(p.keys)-1]
+	omitKid := p.omitKid
+	nonce := authz.nonce
+	if p.nonce != nil {
+		nonce = *p.nonce
+	}
+	p.mu.Unlock()
+
+	header := jwt.Header{Alg: jwt.AlgEdDSA, Typ: "JWT", Kid: key.id}
+	if omitKid {
+		header.Kid = ""
+	}
+
+	now := time.Now()
+	claims := idClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    p.server.URL,
+			Subject:   authz.identity.Subject,
+			Audience:  jwt.Audience{p.ClientID},
+			ExpiresAt: now.Add(5 * time.Minute).Unix(),
+			IssuedAt:  now.Unix(),
+		},
+		Nonce:         nonce,
+		Email:         authz.identity.Email,
+		EmailVerified: authz.identity.EmailVerified,
+		Name:          authz.identity.Name,
+	}
+
+	headerJSON, err := json.Marshal(header)
+	if err != nil {
+		return "", err
+	}
+	claimsJSON, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	headerAndPayload := base64.RawURLEncoding.EncodeToString(headerJSON) + "." + base64.RawURLEncoding.EncodeToString(claimsJSON)
+	signature := ed25519.Sign(key.private, []byte(headerAndPayload))
+
+	return headerAndPayload + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+func writeTokenError(w http.ResponseWriter, status int, code string) {
+	writeJSON(w, status, map[string]string{"error": code})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func randomString() string {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		panic(fmt.Sprintf("oidctest: random string: %s", err.Error()))
+	}
+
+	return hex.EncodeToString(b)
+}
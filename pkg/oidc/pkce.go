@@ -0,0 +1,34 @@
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+)
+
+// RandomString returns a random url safe string, e.g. for the state and the nonce
+func RandomString() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", fmt.Errorf("oidc random string: %w", err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// NewPKCE returns a code verifier and its S256 code challenge (RFC 7636)
+func NewPKCE() (verifier string, challenge string, err error) {
+	verifier, err = RandomString()
+	if err != nil {
+		return "", "", fmt.Errorf("oidc new pkce: %w", err)
+	}
+
+	return verifier, CodeChallenge(verifier), nil
+}
+
+func CodeChallenge(verifier string) string {
+	h := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(h[:])
+}
@@ -31,8 +31,19 @@ func New(pool *pgxpool.Pool) PgxTrx {
 	return PgxTrx{pool}
 }
 
+// WithTransaction runs fn inside a transaction. When ctx already carries a transaction
+// fn runs inside a savepoint of it, so services that use transactions could be
+// composed into one bigger transaction.
 func (p PgxTrx) WithTransaction(ctx context.Context, fn func(context.Context) error) error {
-	tx, err := p.pool.Begin(ctx)
+	var (
+		tx  pgx.Tx
+		err error
+	)
+	if outer, ok := ctx.Value(trxContextKey).(pgx.Tx); ok {
+		tx, err = outer.Begin(ctx)
+	} else {
+		tx, err = p.pool.Begin(ctx)
+	}
 	if err != nil {
 		return fmt.Errorf("pgxtrx with transaction: begin transaction: %w", err)
 	}
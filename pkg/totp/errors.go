@@ -0,0 +1,8 @@
+package totp
+
+import "errors"
+
+var (
+	ErrInvalidSecret = errors.New("totp secret is not valid base32")
+	ErrInvalidCode   = errors.New("totp code is invalid")
+)
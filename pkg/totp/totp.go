@@ -0,0 +1,144 @@
+// Package totp implements the time based one time passwords of RFC 6238
+// with HMAC-SHA1, which is what authenticator apps support.
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultPeriod = 30 * time.Second
+	DefaultDigits = 6
+
+	secretSize = 20
+)
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// Options configures the generation and validation of codes,
+// the zero value uses the defaults of RFC 6238
+type Options struct {
+	// Period defaults to DefaultPeriod
+	Period time.Duration
+	// Digits defaults to DefaultDigits
+	Digits int
+	// Skew is how many periods before and after the current one are accepted
+	Skew int
+	// Now defaults to time.Now
+	Now func() time.Time
+}
+
+func (o Options) period() time.Duration {
+	if o.Period <= 0 {
+		return DefaultPeriod
+	}
+	return o.Period
+}
+
+func (o Options) digits() int {
+	if o.Digits <= 0 {
+		return DefaultDigits
+	}
+	return o.Digits
+}
+
+func (o Options) now() time.Time {
+	if o.Now == nil {
+		return time.Now()
+	}
+	return o.Now()
+}
+
+// GenerateSecret returns a random base32 secret without padding
+func GenerateSecret() (string, error) {
+	b := make([]byte, secretSize)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", fmt.Errorf("totp generate secret: %w", err)
+	}
+
+	return encoding.EncodeToString(b), nil
+}
+
+// Counter returns the number of periods since the unix epoch at t
+func Counter(t time.Time, opts Options) int64 {
+	return t.Unix() / int64(opts.period()/time.Second)
+}
+
+// Code returns the code of the secret for the given counter
+func Code(secret string, counter int64, opts Options) (string, error) {
+	key, err := encoding.DecodeString(strings.ToUpper(strings.TrimRight(secret, "=")))
+	if err != nil {
+		return "", fmt.Errorf("totp code: %w", ErrInvalidSecret)
+	}
+
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(counter))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	// dynamic truncation of RFC 4226
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	digits := opts.digits()
+	mod := uint32(1)
+	for range digits {
+		mod *= 10
+	}
+
+	return fmt.Sprintf("%0*d", digits, value%mod), nil
+}
+
+// Validate checks the code against the current period and the periods allowed by
+// the skew, and returns the counter of the matching period. Callers should reject
+// counters that are not greater than the last accepted one, so a code could not
+// be replayed.
+func Validate(secret, code string, opts Options) (int64, error) {
+	code = strings.TrimSpace(code)
+	if len(code) != opts.digits() {
+		return 0, fmt.Errorf("totp validate: %w", ErrInvalidCode)
+	}
+
+	current := Counter(opts.now(), opts)
+	for i := -opts.Skew; i <= opts.Skew; i++ {
+		expected, err := Code(secret, current+int64(i), opts)
+		if err != nil {
+			return 0, fmt.Errorf("totp validate: %w", err)
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return current + int64(i), nil
+		}
+	}
+
+	return 0, fmt.Errorf("totp validate: %w", ErrInvalidCode)
+}
+
+// URI returns the otpauth URI of the secret that authenticator apps read from a QR code
+func URI(issuer, account, secret string, opts Options) string {
+	q := url.Values{}
+	q.Set("secret", secret)
+	q.Set("issuer", issuer)
+	q.Set("algorithm", "SHA1")
+	q.Set("digits", strconv.Itoa(opts.digits()))
+	q.Set("period", strconv.Itoa(int(opts.period()/time.Second)))
+
+	u := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + issuer + ":" + account,
+		RawQuery: q.Encode(),
+	}
+	return u.String()
+}
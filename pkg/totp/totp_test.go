@@ -0,0 +1,92 @@
+package totp
+
+import (
+	"encoding/base32"
+	"errors"
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA1 seed of the test vectors of RFC 6238 appendix B
+var rfc6238Secret = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+func TestCodeRFC6238(t *testing.T) {
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{unix: 59, want: "94287082"},
+		{unix: 1111111109, want: "07081804"},
+		{unix: 1111111111, want: "14050471"},
+		{unix: 1234567890, want: "89005924"},
+		{unix: 2000000000, want: "69279037"},
+		{unix: 20000000000, want: "65353130"},
+	}
+	for _, tt := range tests {
+		opts := Options{Digits: 8, Now: func() time.Time { return time.Unix(tt.unix, 0) }}
+
+		got, err := Code(rfc6238Secret, Counter(time.Unix(tt.unix, 0), opts), opts)
+		if err != nil {
+			t.Fatalf("Code() at %d: %v", tt.unix, err)
+		}
+		if got != tt.want {
+			t.Fatalf("Code() at %d = %s, want %s", tt.unix, got, tt.want)
+		}
+
+		counter, err := Validate(rfc6238Secret, tt.want, opts)
+		if err != nil {
+			t.Fatalf("Validate() at %d: %v", tt.unix, err)
+		}
+		if want := tt.unix / 30; counter != want {
+			t.Fatalf("Validate() at %d = %d, want %d", tt.unix, counter, want)
+		}
+	}
+}
+
+func TestValidateSkew(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	opts := Options{Digits: 8, Skew: 1, Now: func() time.Time { return now }}
+	current := Counter(now, opts)
+
+	tests := []struct {
+		name    string
+		offset  int64
+		wantErr error
+	}{
+		{name: "previous period", offset: -1},
+		{name: "current period", offset: 0},
+		{name: "next period", offset: 1},
+		{name: "two periods ago", offset: -2, wantErr: ErrInvalidCode},
+		{name: "two periods ahead", offset: 2, wantErr: ErrInvalidCode},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, err := Code(rfc6238Secret, current+tt.offset, opts)
+			if err != nil {
+				t.Fatalf("Code(): %v", err)
+			}
+
+			counter, err := Validate(rfc6238Secret, code, opts)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Validate() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && counter != current+tt.offset {
+				t.Fatalf("Validate() = %d, want %d", counter, current+tt.offset)
+			}
+		})
+	}
+}
+
+func TestValidateInvalid(t *testing.T) {
+	opts := Options{Now: func() time.Time { return time.Unix(59, 0) }}
+
+	_, err := Validate(rfc6238Secret, "12345", opts)
+	if !errors.Is(err, ErrInvalidCode) {
+		t.Fatalf("short code: error = %v, want %v", err, ErrInvalidCode)
+	}
+
+	_, err = Validate("not base32!", "123456", opts)
+	if !errors.Is(err, ErrInvalidSecret) {
+		t.Fatalf("invalid secret: error = %v, want %v", err, ErrInvalidSecret)
+	}
+}
@@ -12,8 +12,16 @@ type (
 	}
 
 	ResTemplate struct {
-		Message string `json:"message"`
-		Data    any    `json:"data"`
+		Message    string      `json:"message"`
+		Data       any         `json:"data"`
+		Pagination *Pagination `json:"pagination,omitempty"`
+	}
+
+	// Pagination holds the opaque cursors of the pages around the returned page,
+	// a cursor is nil when there is no such page
+	Pagination struct {
+		Next *string `json:"next"`
+		Prev *string `json:"prev"`
 	}
 )
 
@@ -35,3 +43,7 @@ func DecodeReqBody[Body Validator](r io.Reader) (Body, error) {
 func NewResTemplate(msg string, data any) ResTemplate {
 	return ResTemplate{Message: msg, Data: data}
 }
+
+func NewPaginatedResTemplate(msg string, data any, p Pagination) ResTemplate {
+	return ResTemplate{Message: msg, Data: data, Pagination: &p}
+}
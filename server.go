@@ -4,6 +4,9 @@ import (
 	"catsocial/cat"
 	"catsocial/match"
 	"catsocial/pkg/env"
+	"catsocial/pkg/jwt"
+	"catsocial/pkg/mailer"
+	"catsocial/pkg/oidc"
 	"catsocial/pkg/pgxtrx"
 	"catsocial/user"
 	"cmp"
@@ -16,6 +19,7 @@ import (
 	"os"
 	"os/signal"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/exaring/otelpgx"
@@ -46,13 +50,39 @@ func runServer() {
 	// === ENV VAR
 	port := ":" + cmp.Or(os.Getenv("PORT"), "8080")
 
-	saltCountString := env.MustLoad("BCRYPT_SALT")
-	saltCount, err := strconv.Atoi(saltCountString)
+	passwordHasher := loadPasswordHasher()
+
+	jwtKeyring := loadJWTKeyring()
+
+	jwtLeewayString := cmp.Or(os.Getenv("JWT_LEEWAY"), "30s")
+	jwtLeeway, err := time.ParseDuration(jwtLeewayString)
 	if err != nil {
-		log.Fatalf("parsing BCRYPT_SALT as int: %s\n", err.Error())
+		log.Fatalf("parsing JWT_LEEWAY as duration: %s\n", err.Error())
 	}
 
-	jwtSecret := env.MustLoad("JWT_SECRET")
+	requireVerifiedEmail := os.Getenv("REQUIRE_VERIFIED_EMAIL") == "true"
+
+	appBaseURL := cmp.Or(os.Getenv("APP_BASE_URL"), "http://localhost"+port)
+
+	jwtOpts := jwt.ValidationOptions{
+		Issuer:   cmp.Or(os.Getenv("JWT_ISSUER"), "catsocial"),
+		Audience: cmp.Or(os.Getenv("JWT_AUDIENCE"), "catsocial"),
+		Leeway:   jwtLeeway,
+	}
+
+	mailSender := initMailer()
+
+	catRestoreWindowString := cmp.Or(os.Getenv("CAT_RESTORE_WINDOW"), "720h")
+	catRestoreWindow, err := time.ParseDuration(catRestoreWindowString)
+	if err != nil {
+		log.Fatalf("parsing CAT_RESTORE_WINDOW as duration: %s\n", err.Error())
+	}
+
+	catPurgeIntervalString := cmp.Or(os.Getenv("CAT_PURGE_INTERVAL"), "1h")
+	catPurgeInterval, err := time.ParseDuration(catPurgeIntervalString)
+	if err != nil || catPurgeInterval <= 0 {
+		log.Fatalf("parsing CAT_PURGE_INTERVAL as positive duration: %v\n", err)
+	}
 
 	// === HTTP MUX
 	mux := http.NewServeMux()
@@ -78,44 +108,142 @@ func runServer() {
 	h = otelhttp.NewHandler(h, "/")
 	srv.Handler = h
 
+	// === CAT
+	catSQL := cat.NewSQL(pgxTrx)
+	// the match service needs the cat service, so the cat service reaches it through
+	// matchSvc which is set right after
+	var matchSvc match.Service
+	withdrawCatMatches := cat.MatchWithdrawerFunc(func(ctx context.Context, catID int) error {
+		return matchSvc.WithdrawCatMatches(ctx, catID)
+	})
+	catSvc := cat.NewService(catSQL, pgxTrx, catRestoreWindow, withdrawCatMatches)
+
+	// === MATCH
+	matchSQL := match.NewSQL(pgxTrx)
+	matchSvc = match.NewService(matchSQL, catSvc, catSQL, pgxTrx)
+	matchCtrl := match.NewController(matchSvc)
+
 	// === USER
-	userSQL := user.NewSQL(dbPool)
-	userSvc := user.NewService(userSQL, saltCount, jwtSecret)
+	userSQL := user.NewSQL(pgxTrx)
+	userDataHandlers := map[string]user.DataHandler{
+		"cats":    catSvc,
+		"matches": matchSvc,
+	}
+	userSvc := user.NewService(userSQL, pgxTrx, passwordHasher, jwtKeyring, jwtOpts, mailSender, appBaseURL, userDataHandlers)
 	userCtrl := user.NewController(userSvc)
+	oidcClient := loadOIDCClient(appBaseURL, jwtLeeway)
+
+	// the cat controller embeds owners and matches into cats, so it needs both services
+	catCtrl := cat.NewController(catSvc, userSvc, matchSvc)
+
+	// verifiedOnly blocks users with unverified email when REQUIRE_VERIFIED_EMAIL is true
+	verifiedOnly := func(h http.Handler) http.Handler {
+		if !requireVerifiedEmail {
+			return h
+		}
+		return userCtrl.RequireVerifiedEmail(h)
+	}
+
+	// sessionOnly guards the routes that manage the account, api keys could not reach them
+	sessionOnly := func(h http.Handler) http.Handler {
+		return userCtrl.AuthMiddleware(userCtrl.RequireSession(h))
+	}
+
+	// scoped lets access tokens through, and api keys only when they have the scope
+	scoped := func(scope user.Scope, h http.Handler) http.Handler {
+		return userCtrl.AuthMiddleware(userCtrl.RequireScope(scope)(h))
+	}
 
 	handleFunc("POST /v1/user/register", http.HandlerFunc(userCtrl.RegisterHandler))
 	handleFunc("POST /v1/user/login", http.HandlerFunc(userCtrl.LoginHandler))
+	handleFunc("POST /v1/user/login/2fa", http.HandlerFunc(userCtrl.LoginTwoFactorHandler))
+	handleFunc("POST /v1/user/refresh", http.HandlerFunc(userCtrl.RefreshHandler))
+	handleFunc("POST /v1/user/password/forgot", http.HandlerFunc(userCtrl.ForgotPasswordHandler))
+	handleFunc("POST /v1/user/password/reset", http.HandlerFunc(userCtrl.ResetPasswordHandler))
+	handleFunc("GET /v1/user/email/verify", http.HandlerFunc(userCtrl.VerifyEmailHandler))
+	resendVerificationHandler := sessionOnly(http.HandlerFunc(userCtrl.ResendVerificationEmailHandler))
+	handleFunc("POST /v1/user/email/verify/resend", resendVerificationHandler)
+	getProfileHandler := scoped(user.ScopeProfileRead, http.HandlerFunc(userCtrl.GetProfileHandler))
+	handleFunc("GET /v1/user/me", getProfileHandler)
+	updateProfileHandler := sessionOnly(http.HandlerFunc(userCtrl.UpdateProfileHandler))
+	handleFunc("PATCH /v1/user/me", updateProfileHandler)
+	deleteAccountHandler := sessionOnly(http.HandlerFunc(userCtrl.DeleteAccountHandler))
+	handleFunc("DELETE /v1/user/me", deleteAccountHandler)
+	exportDataHandler := sessionOnly(http.HandlerFunc(userCtrl.ExportDataHandler))
+	handleFunc("GET /v1/user/me/export", exportDataHandler)
+	enrollTOTPHandler := sessionOnly(http.HandlerFunc(userCtrl.EnrollTOTPHandler))
+	handleFunc("POST /v1/user/2fa/enroll", enrollTOTPHandler)
+	confirmTOTPHandler := sessionOnly(http.HandlerFunc(userCtrl.ConfirmTOTPHandler))
+	handleFunc("POST /v1/user/2fa/confirm", confirmTOTPHandler)
+	disableTOTPHandler := sessionOnly(http.HandlerFunc(userCtrl.DisableTOTPHandler))
+	handleFunc("POST /v1/user/2fa/disable", disableTOTPHandler)
+	createAPIKeyHandler := sessionOnly(http.HandlerFunc(userCtrl.CreateAPIKeyHandler))
+	handleFunc("POST /v1/user/api-keys", createAPIKeyHandler)
+	listAPIKeysHandler := sessionOnly(http.HandlerFunc(userCtrl.ListAPIKeysHandler))
+	handleFunc("GET /v1/user/api-keys", listAPIKeysHandler)
+	revokeAPIKeyHandler := sessionOnly(http.HandlerFunc(userCtrl.RevokeAPIKeyHandler))
+	handleFunc("DELETE /v1/user/api-keys/{id}", revokeAPIKeyHandler)
+	handleFunc("GET /.well-known/jwks.json", http.HandlerFunc(userCtrl.JWKSHandler))
+	if oidcClient != nil {
+		oidcCtrl := user.NewOIDCController(userCtrl, oidcClient)
+		handleFunc("GET /v1/user/oidc/login", http.HandlerFunc(oidcCtrl.LoginHandler))
+		handleFunc("GET /v1/user/oidc/callback", http.HandlerFunc(oidcCtrl.CallbackHandler))
+	}
+	logoutHandler := sessionOnly(http.HandlerFunc(userCtrl.LogoutHandler))
+	handleFunc("POST /v1/user/logout", logoutHandler)
+	logoutAllHandler := sessionOnly(http.HandlerFunc(userCtrl.LogoutAllHandler))
+	handleFunc("POST /v1/user/logout-all", logoutAllHandler)
+	listSessionsHandler := sessionOnly(http.HandlerFunc(userCtrl.ListSessionsHandler))
+	handleFunc("GET /v1/user/sessions", listSessionsHandler)
+	revokeSessionHandler := sessionOnly(http.HandlerFunc(userCtrl.RevokeSessionHandler))
+	handleFunc("DELETE /v1/user/sessions/{id}", revokeSessionHandler)
 
-	// === CAT
-	catSQL := cat.NewSQL(pgxTrx)
-	catSvc := cat.NewService(catSQL, pgxTrx)
-	catCtrl := cat.NewController(catSvc)
-
-	createCatHandler := userCtrl.AuthMiddleware(http.HandlerFunc(catCtrl.CreateHandler))
+	// === CAT ROUTES
+	createCatHandler := scoped(user.ScopeCatWrite, verifiedOnly(http.HandlerFunc(catCtrl.CreateHandler)))
 	handleFunc("POST /v1/cat", createCatHandler)
-	searchCatHandler := userCtrl.AuthMiddleware(http.HandlerFunc(catCtrl.SearchHandler))
+	searchCatHandler := scoped(user.ScopeCatRead, http.HandlerFunc(catCtrl.SearchHandler))
 	handleFunc("GET /v1/cat", searchCatHandler)
-	updateCatHandler := userCtrl.AuthMiddleware(http.HandlerFunc(catCtrl.UpdateHandler))
+	getCatHandler := scoped(user.ScopeCatRead, http.HandlerFunc(catCtrl.GetHandler))
+	handleFunc("GET /v1/cat/{id}", getCatHandler)
+	listDeletedCatsHandler := scoped(user.ScopeCatRead, http.HandlerFunc(catCtrl.ListDeletedHandler))
+	handleFunc("GET /v1/cat/trash", listDeletedCatsHandler)
+	restoreCatHandler := scoped(user.ScopeCatWrite, http.HandlerFunc(catCtrl.RestoreHandler))
+	handleFunc("POST /v1/cat/trash/{id}/restore", restoreCatHandler)
+	purgeCatHandler := scoped(user.ScopeCatWrite, http.HandlerFunc(catCtrl.PurgeHandler))
+	handleFunc("DELETE /v1/cat/trash/{id}", purgeCatHandler)
+	updateCatHandler := scoped(user.ScopeCatWrite, http.HandlerFunc(catCtrl.UpdateHandler))
 	handleFunc("PUT /v1/cat/{id}", updateCatHandler)
-	deleteCatHandler := userCtrl.AuthMiddleware(http.HandlerFunc(catCtrl.DeleteHandler))
+	patchCatHandler := scoped(user.ScopeCatWrite, http.HandlerFunc(catCtrl.PatchHandler))
+	handleFunc("PATCH /v1/cat/{id}", patchCatHandler)
+	deleteCatHandler := scoped(user.ScopeCatWrite, http.HandlerFunc(catCtrl.DeleteHandler))
 	handleFunc("DELETE /v1/cat/{id}", deleteCatHandler)
 
-	// === MATCH
-	matchSQL := match.NewSQL(pgxTrx)
-	matchSvc := match.NewService(matchSQL, catSvc, catSQL, pgxTrx)
-	matchCtrl := match.NewController(matchSvc)
-
-	createMatchHandler := userCtrl.AuthMiddleware(http.HandlerFunc(matchCtrl.CreateHandler))
+	// === MATCH ROUTES
+	createMatchHandler := scoped(user.ScopeMatchWrite, verifiedOnly(http.HandlerFunc(matchCtrl.CreateHandler)))
 	handleFunc("POST /v1/cat/match", createMatchHandler)
-	getMatchHandler := userCtrl.AuthMiddleware(http.HandlerFunc(matchCtrl.GetHandler))
+	getMatchHandler := scoped(user.ScopeMatchRead, http.HandlerFunc(matchCtrl.GetHandler))
 	handleFunc("GET /v1/cat/match", getMatchHandler)
-	approveMatchHandler := userCtrl.AuthMiddleware(http.HandlerFunc(matchCtrl.ApproveHandler))
+	approveMatchHandler := scoped(user.ScopeMatchWrite, http.HandlerFunc(matchCtrl.ApproveHandler))
 	handleFunc("POST /v1/cat/match/approve", approveMatchHandler)
-	rejectMatchHandler := userCtrl.AuthMiddleware(http.HandlerFunc(matchCtrl.RejectHandler))
+	rejectMatchHandler := scoped(user.ScopeMatchWrite, http.HandlerFunc(matchCtrl.RejectHandler))
 	handleFunc("POST /v1/cat/match/reject", rejectMatchHandler)
-	deleteMatchHandler := userCtrl.AuthMiddleware(http.HandlerFunc(matchCtrl.DeleteHandler))
+	deleteMatchHandler := scoped(user.ScopeMatchWrite, http.HandlerFunc(matchCtrl.DeleteHandler))
 	handleFunc("DELETE /v1/cat/match/{id}", deleteMatchHandler)
 
+	// === ADMIN ROUTES
+	adminOnly := func(h http.HandlerFunc) http.Handler {
+		return sessionOnly(userCtrl.RequireRole(user.RoleAdmin)(h))
+	}
+
+	handleFunc("GET /v1/admin/users", adminOnly(userCtrl.ListUsersHandler))
+	handleFunc("POST /v1/admin/users/{id}/ban", adminOnly(userCtrl.BanHandler))
+	handleFunc("DELETE /v1/admin/users/{id}/ban", adminOnly(userCtrl.UnbanHandler))
+	handleFunc("DELETE /v1/admin/cats/{id}", adminOnly(catCtrl.ForceDeleteHandler))
+	handleFunc("DELETE /v1/admin/matches/{id}", adminOnly(matchCtrl.ForceDeleteHandler))
+
+	// === BACKGROUND JOBS
+	go runCatPurge(ctx, catSvc, catPurgeInterval)
+
 	// === SERVE HTTP AND GRACE SHUTDOWN
 	go func() {
 		log.Printf("server has started listening on: %s\n", srv.Addr)
@@ -132,6 +260,29 @@ func runServer() {
 	}
 }
 
+// runCatPurge purges the cats whose restore window has expired every interval
+// until ctx is done
+func runCatPurge(ctx context.Context, catSvc cat.Service, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		purged, err := catSvc.PurgeExpired(ctx)
+		if err != nil {
+			log.Printf("purging expired cats: %s\n", err.Error())
+		}
+		if purged > 0 {
+			log.Printf("purged %d expired cats\n", purged)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
 func initDB(ctx context.Context) *pgxpool.Pool {
 	dbName := env.MustLoad("DB_NAME")
 	dbPort := env.MustLoad("DB_PORT")
@@ -156,3 +307,114 @@ func initDB(ctx context.Context) *pgxpool.Pool {
 
 	return dbpool
 }
+
+// initMailer picks the mailer from MAILER, either smtp or file (the default)
+// which writes every email into MAIL_DIR
+func initMailer() mailer.Mailer {
+	switch m := cmp.Or(os.Getenv("MAILER"), "file"); m {
+	case "smtp":
+		return mailer.NewSMTP(
+			env.MustLoad("SMTP_HOST"),
+			env.MustLoad("SMTP_PORT"),
+			os.Getenv("SMTP_USERNAME"),
+			os.Getenv("SMTP_PASSWORD"),
+			env.MustLoad("MAIL_FROM"),
+		)
+	case "file":
+		return mailer.NewFile(cmp.Or(os.Getenv("MAIL_DIR"), "mails"))
+	default:
+		log.Fatalf("unknown MAILER: %s\n", m)
+		return nil
+	}
+}
+
+// loadOIDCClient returns the OpenID Connect client of OIDC_ISSUER, or nil when the login
+// with a provider is not configured. OIDC_REDIRECT_URL must be registered at the provider,
+// it defaults to the callback route under APP_BASE_URL.
+func loadOIDCClient(appBaseURL string, leeway time.Duration) *oidc.Client {
+	issuer := os.Getenv("OIDC_ISSUER")
+	if issuer == "" {
+		return nil
+	}
+
+	return oidc.NewClient(oidc.Config{
+		Issuer:       issuer,
+		ClientID:     env.MustLoad("OIDC_CLIENT_ID"),
+		ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+		RedirectURL:  cmp.Or(os.Getenv("OIDC_REDIRECT_URL"), strings.TrimSuffix(appBaseURL, "/")+"/v1/user/oidc/callback"),
+		Leeway:       leeway,
+	})
+}
+
+// loadPasswordHasher hashes new passwords with PASSWORD_HASHER, either bcrypt or argon2id.
+// BCRYPT_SALT is the bcrypt cost, and ARGON2_MEMORY (KiB), ARGON2_TIME and ARGON2_THREADS
+// are the argon2id parameters. Hashes of the other algorithm or of other parameters are
+// still accepted and are upgraded on the next login.
+func loadPasswordHasher() user.PasswordHashers {
+	bcryptCost, err := strconv.Atoi(env.MustLoad("BCRYPT_SALT"))
+	if err != nil {
+		log.Fatalf("parsing BCRYPT_SALT as int: %s\n", err.Error())
+	}
+	bcryptHasher := user.BcryptHasher{Cost: bcryptCost}
+
+	argon2Memory, err := strconv.ParseUint(cmp.Or(os.Getenv("ARGON2_MEMORY"), "19456"), 10, 32)
+	if err != nil {
+		log.Fatalf("parsing ARGON2_MEMORY as int: %s\n", err.Error())
+	}
+	argon2Time, err := strconv.ParseUint(cmp.Or(os.Getenv("ARGON2_TIME"), "2"), 10, 32)
+	if err != nil {
+		log.Fatalf("parsing ARGON2_TIME as int: %s\n", err.Error())
+	}
+	argon2Threads, err := strconv.ParseUint(cmp.Or(os.Getenv("ARGON2_THREADS"), "1"), 10, 8)
+	if err != nil {
+		log.Fatalf("parsing ARGON2_THREADS as int: %s\n", err.Error())
+	}
+	argon2Hasher := user.NewArgon2idHasher(uint32(argon2Memory), uint32(argon2Time), uint8(argon2Threads))
+
+	switch h := cmp.Or(os.Getenv("PASSWORD_HASHER"), "bcrypt"); h {
+	case "bcrypt":
+		return user.NewPasswordHashers(bcryptHasher, argon2Hasher)
+	case "argon2id":
+		return user.NewPasswordHashers(argon2Hasher, bcryptHasher)
+	default:
+		log.Fatalf("unknown PASSWORD_HASHER: %s\n", h)
+		return user.PasswordHashers{}
+	}
+}
+
+// loadJWTKeyring loads the HMAC keys from JWT_KEYS written as comma separated kid:secret pairs,
+// and the ed25519 or rsa pem keys from JWT_KEY_FILES written as comma separated kid:path pairs.
+// When neither is set a single HMAC key is loaded from JWT_SECRET.
+// JWT_ACTIVE_KEY_ID picks the signing key, every other key is only used to verify tokens
+// that are still signed by it.
+func loadJWTKeyring() jwt.Keyring {
+	var keys []jwt.Key
+
+	if keysString := os.Getenv("JWT_KEYS"); keysString != "" {
+		hmacKeys, err := jwt.ParseKeys(keysString)
+		if err != nil {
+			log.Fatalf("parsing JWT_KEYS: %s\n", err.Error())
+		}
+		keys = append(keys, hmacKeys...)
+	}
+
+	if keyFilesString := os.Getenv("JWT_KEY_FILES"); keyFilesString != "" {
+		pemKeys, err := jwt.LoadKeyFiles(keyFilesString)
+		if err != nil {
+			log.Fatalf("loading JWT_KEY_FILES: %s\n", err.Error())
+		}
+		keys = append(keys, pemKeys...)
+	}
+
+	if len(keys) == 0 {
+		keys = append(keys, jwt.NewHMACKey("default", []byte(env.MustLoad("JWT_SECRET"))))
+	}
+
+	activeKeyID := cmp.Or(os.Getenv("JWT_ACTIVE_KEY_ID"), keys[0].ID)
+	keyring, err := jwt.NewKeyring(activeKeyID, keys...)
+	if err != nil {
+		log.Fatalf("Unable to create jwt keyring: %s\n", err.Error())
+	}
+
+	return keyring
+}
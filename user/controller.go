@@ -1,16 +1,22 @@
 package user
 
 import (
+	"catsocial/pkg/jwt"
 	"catsocial/pkg/web"
+	"cmp"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log"
+	"math"
+	"net"
 	"net/http"
 	"net/mail"
 	"slices"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type (
@@ -19,8 +25,36 @@ type (
 	svc interface {
 		Register(ctx context.Context, args RegisterArgs) (string, error)
 		Login(ctx context.Context, args LoginArgs) (User, error)
-		GetAccessToken(userID string) (string, error)
-		IsAccessTokenValid(token string) (map[string]any, bool)
+		IssueTokens(ctx context.Context, args IssueTokensArgs) (Tokens, error)
+		Refresh(ctx context.Context, refreshToken string) (Tokens, error)
+		Logout(ctx context.Context, sessionID string) error
+		ListSessions(ctx context.Context, userID string) ([]Session, error)
+		RevokeSession(ctx context.Context, args RevokeSessionArgs) error
+		LogoutAll(ctx context.Context, userID string) error
+		ParseAccessToken(ctx context.Context, args ParseAccessTokenArgs) (AccessClaims, error)
+		ForgotPassword(ctx context.Context, email string) error
+		ResetPassword(ctx context.Context, args ResetPasswordArgs) error
+		SendVerificationEmail(ctx context.Context, userID string) error
+		VerifyEmail(ctx context.Context, token string) error
+		IsEmailVerified(ctx context.Context, userID string) (bool, error)
+		GetProfile(ctx context.Context, userID string) (User, error)
+		UpdateProfile(ctx context.Context, args UpdateProfileArgs) (User, error)
+		DeleteAccount(ctx context.Context, userID string) error
+		ExportData(ctx context.Context, userID string) (Export, error)
+		ListUsers(ctx context.Context, args ListUsersArgs) ([]User, error)
+		Ban(ctx context.Context, args BanArgs) error
+		Unban(ctx context.Context, userID string) error
+		EnrollTOTP(ctx context.Context, userID string) (TOTPEnrollment, error)
+		ConfirmTOTP(ctx context.Context, args ConfirmTOTPArgs) ([]string, error)
+		DisableTOTP(ctx context.Context, args DisableTOTPArgs) error
+		CreateLoginChallenge(ctx context.Context, userID string) (string, error)
+		LoginTwoFactor(ctx context.Context, args LoginTwoFactorArgs) (User, error)
+		CreateAPIKey(ctx context.Context, args CreateAPIKeyArgs) (APIKey, string, error)
+		ListAPIKeys(ctx context.Context, userID string) ([]APIKey, error)
+		RevokeAPIKey(ctx context.Context, args RevokeAPIKeyArgs) error
+		AuthenticateAPIKey(ctx context.Context, key string) (APIKey, error)
+		LoginWithIdentity(ctx context.Context, args LoginWithIdentityArgs) (User, error)
+		JWKS() jwt.JWKS
 	}
 
 	Controller struct {
@@ -29,7 +63,10 @@ type (
 )
 
 const (
-	userIDContextKey contextKey = "//user-id"
+	userIDContextKey    contextKey = "//user-id"
+	sessionIDContextKey contextKey = "//session-id"
+	roleContextKey      contextKey = "//role"
+	apiKeyContextKey    contextKey = "//api-key"
 )
 
 func NewController(s svc) Controller {
@@ -55,22 +92,31 @@ func (r RegisterReqBody) Validate() bool {
 	}
 
 	// name min length 5 and max length 50
-	if len(r.Name) < 5 || len(r.Name) > 50 {
+	if !isValidName(r.Name) {
 		return false
 	}
 
 	// password min length 5 and max length 15
-	if len(r.Password) < 5 || len(r.Password) > 15 {
+	if !isValidPassword(r.Password) {
 		return false
 	}
 
 	return true
 }
 
+func isValidName(name string) bool {
+	return len(name) >= 5 && len(name) <= 50
+}
+
+func isValidPassword(password string) bool {
+	return len(password) >= 5 && len(password) <= 15
+}
+
 type LoginResp struct {
-	Email       string `json:"email"`
-	Name        string `json:"name"`
-	AccessToken string `json:"accessToken"`
+	Email        string `json:"email"`
+	Name         string `json:"name"`
+	AccessToken  string `json:"accessToken"`
+	RefreshToken string `json:"refreshToken"`
 }
 
 func (c Controller) RegisterHandler(w http.ResponseWriter, r *http.Request) {
@@ -98,16 +144,27 @@ func (c Controller) RegisterHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	token, err := c.s.GetAccessToken(id)
+	tokens, err := c.s.IssueTokens(r.Context(), IssueTokensArgs{
+		UserID:    id,
+		UserAgent: r.UserAgent(),
+		IP:        clientIP(r),
+	})
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	// the user could ask for another verification email, so registration goes on
+	err = c.s.SendVerificationEmail(r.Context(), id)
+	if err != nil {
+		log.Printf("sending verification email: %s\n", err.Error())
+	}
+
 	resp := LoginResp{
-		Email:       reqBody.Email,
-		Name:        reqBody.Name,
-		AccessToken: token,
+		Email:        reqBody.Email,
+		Name:         reqBody.Name,
+		AccessToken:  tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
 	}
 	w.Header().Set("Content-Type", "application/json")
 	respBody, err := json.Marshal(web.NewResTemplate("User registered successfully", resp))
@@ -163,30 +220,78 @@ func (c Controller) LoginHandler(w http.ResponseWriter, r *http.Request) {
 	u, err := c.s.Login(r.Context(), LoginArgs{
 		Email:    reqBody.Email,
 		Password: reqBody.Password,
+		IP:       clientIP(r),
 	})
-	if errors.Is(err, ErrInvalidPassword) {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	var lockedErr LoginLockedError
+	if errors.As(err, &lockedErr) {
+		writeLoginLocked(w, lockedErr)
 		return
 	}
-	if errors.Is(err, ErrUserNotFound) {
-		http.Error(w, err.Error(), http.StatusNotFound)
+	// unknown emails and wrong passwords get the same response,
+	// so it could not be used to find out which emails have an account
+	if errors.Is(err, ErrInvalidPassword) || errors.Is(err, ErrUserNotFound) {
+		http.Error(w, "invalid email or password", http.StatusUnauthorized)
+		return
+	}
+	if errors.Is(err, ErrUserBanned) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	c.completeLogin(w, r, u)
+}
+
+// completeLogin responds with a challenge to users with a second factor,
+// and with the tokens of a new session to every other user
+func (c Controller) completeLogin(w http.ResponseWriter, r *http.Request, u User) {
+	if u.TOTPEnabledAt == nil {
+		c.writeTokens(w, r, u)
 		return
 	}
+
+	challengeToken, err := c.s.CreateLoginChallenge(r.Context(), strconv.Itoa(u.ID))
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	token, err := c.s.GetAccessToken(strconv.Itoa(u.ID))
+	resp := LoginChallengeResp{
+		TwoFactorRequired: true,
+		ChallengeToken:    challengeToken,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(web.NewResTemplate("Two factor authentication required", resp))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("decoding challenge into json: %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+}
+
+// writeTokens starts a new session for the user and responds with its tokens
+func (c Controller) writeTokens(w http.ResponseWriter, r *http.Request, u User) {
+	tokens, err := c.s.IssueTokens(r.Context(), IssueTokensArgs{
+		UserID:    strconv.Itoa(u.ID),
+		UserAgent: r.UserAgent(),
+		IP:        clientIP(r),
+	})
+	if errors.Is(err, ErrUserBanned) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	resp := LoginResp{
-		Email:       reqBody.Email,
-		Name:        u.Name,
-		AccessToken: token,
+		Email:        u.Email,
+		Name:         u.Name,
+		AccessToken:  tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
 	}
 	w.Header().Set("Content-Type", "application/json")
 	err = json.NewEncoder(w).Encode(web.NewResTemplate("User logged successfully", resp))
@@ -196,28 +301,1076 @@ func (c Controller) LoginHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func (c Controller) AuthMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		a := r.Header.Get("Authorization")
+type LoginChallengeResp struct {
+	TwoFactorRequired bool   `json:"twoFactorRequired"`
+	ChallengeToken    string `json:"challengeToken"`
+}
 
-		payload, isValid := c.s.IsAccessTokenValid(strings.TrimPrefix(a, "Bearer "))
-		if !isValid {
-			http.Error(w, "missing or expired access token", http.StatusUnauthorized)
-			return
-		}
+// writeLoginLocked responds with 429 and tells the client when to retry
+func writeLoginLocked(w http.ResponseWriter, err LoginLockedError) {
+	retryAfter := int(math.Ceil(err.RetryAfter.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(max(retryAfter, 1)))
+	http.Error(w, err.Error(), http.StatusTooManyRequests)
+}
 
-		userID, ok := payload["userId"].(string)
-		if !ok {
-			http.Error(w, "missing or expired access token", http.StatusUnauthorized)
-			return
-		}
+type LoginTwoFactorReqBody struct {
+	ChallengeToken string `json:"challengeToken"`
+	Code           string `json:"code"`
+}
 
-		ctx := context.WithValue(r.Context(), userIDContextKey, userID)
-		next.ServeHTTP(w, r.WithContext(ctx))
+func (l LoginTwoFactorReqBody) Validate() bool {
+	return l.ChallengeToken != "" && l.Code != ""
+}
+
+func (c Controller) LoginTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	reqBody, err := web.DecodeReqBody[LoginTwoFactorReqBody](r.Body)
+	if errors.Is(err, web.ErrInvalidReqBody) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	u, err := c.s.LoginTwoFactor(r.Context(), LoginTwoFactorArgs{
+		ChallengeToken: reqBody.ChallengeToken,
+		Code:           reqBody.Code,
 	})
+	var lockedErr LoginLockedError
+	if errors.As(err, &lockedErr) {
+		writeLoginLocked(w, lockedErr)
+		return
+	}
+	if errors.Is(err, ErrInvalidUserToken) {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if errors.Is(err, ErrInvalidTOTPCode) || errors.Is(err, ErrTOTPNotEnabled) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	c.writeTokens(w, r, u)
 }
 
-func UserIDFromContext(ctx context.Context) (string, bool) {
-	userID, ok := ctx.Value(userIDContextKey).(string)
-	return userID, ok
+type RefreshReqBody struct {
+	RefreshToken string `json:"refreshToken"`
+}
+
+func (r RefreshReqBody) Validate() bool {
+	// refresh token is not null
+	return r.RefreshToken != ""
+}
+
+type RefreshResp struct {
+	AccessToken  string `json:"accessToken"`
+	RefreshToken string `json:"refreshToken"`
+}
+
+func (c Controller) RefreshHandler(w http.ResponseWriter, r *http.Request) {
+	reqBody, err := web.DecodeReqBody[RefreshReqBody](r.Body)
+	if errors.Is(err, web.ErrInvalidReqBody) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	tokens, err := c.s.Refresh(r.Context(), reqBody.RefreshToken)
+	if errors.Is(err, ErrInvalidRefreshToken) || errors.Is(err, ErrRefreshTokenReused) {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if errors.Is(err, ErrUserBanned) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	resp := RefreshResp{
+		AccessToken:  tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(web.NewResTemplate("Token refreshed successfully", resp))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("decoding tokens into json: %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+}
+
+func (c Controller) LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	sessionID, ok := SessionIDFromContext(r.Context())
+	if !ok || sessionID == "" {
+		http.Error(w, "invalid access token", http.StatusInternalServerError)
+		return
+	}
+
+	err := c.s.Logout(r.Context(), sessionID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (c Controller) LogoutAllHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := UserIDFromContext(r.Context())
+	if !ok || userID == "" {
+		http.Error(w, "invalid access token", http.StatusInternalServerError)
+		return
+	}
+
+	err := c.s.LogoutAll(r.Context(), userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+type ForgotPasswordReqBody struct {
+	Email string `json:"email"`
+}
+
+func (f ForgotPasswordReqBody) Validate() bool {
+	// email should be in valid email format
+	_, parseEmailErr := mail.ParseAddress(f.Email)
+	return parseEmailErr == nil
+}
+
+func (c Controller) ForgotPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	reqBody, err := web.DecodeReqBody[ForgotPasswordReqBody](r.Body)
+	if errors.Is(err, web.ErrInvalidReqBody) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	err = c.s.ForgotPassword(r.Context(), reqBody.Email)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// the response is the same whether the email is registered or not
+	w.WriteHeader(http.StatusAccepted)
+}
+
+type ResetPasswordReqBody struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+func (rp ResetPasswordReqBody) Validate() bool {
+	// token is not null
+	if rp.Token == "" {
+		return false
+	}
+
+	// password min length 5 and max length 15
+	return isValidPassword(rp.Password)
+}
+
+func (c Controller) ResetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	reqBody, err := web.DecodeReqBody[ResetPasswordReqBody](r.Body)
+	if errors.Is(err, web.ErrInvalidReqBody) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	err = c.s.ResetPassword(r.Context(), ResetPasswordArgs{
+		Token:    reqBody.Token,
+		Password: reqBody.Password,
+	})
+	if errors.Is(err, ErrInvalidUserToken) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (c Controller) VerifyEmailHandler(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		http.Error(w, "token is empty", http.StatusBadRequest)
+		return
+	}
+
+	err := c.s.VerifyEmail(r.Context(), token)
+	if errors.Is(err, ErrInvalidUserToken) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(web.NewResTemplate("Email verified successfully", nil))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("decoding resp into json: %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+}
+
+func (c Controller) ResendVerificationEmailHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := UserIDFromContext(r.Context())
+	if !ok || userID == "" {
+		http.Error(w, "invalid access token", http.StatusInternalServerError)
+		return
+	}
+
+	err := c.s.SendVerificationEmail(r.Context(), userID)
+	if errors.Is(err, ErrEmailAlreadyVerified) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+type ProfileResp struct {
+	Email         string `json:"email"`
+	Name          string `json:"name"`
+	EmailVerified bool   `json:"emailVerified"`
+	CreatedAt     string `json:"createdAt"`
+}
+
+func newProfileResp(u User) ProfileResp {
+	return ProfileResp{
+		Email:         u.Email,
+		Name:          u.Name,
+		EmailVerified: u.EmailVerifiedAt != nil,
+		CreatedAt:     u.CreatedAt.Format(time.RFC3339),
+	}
+}
+
+func (c Controller) GetProfileHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := UserIDFromContext(r.Context())
+	if !ok || userID == "" {
+		http.Error(w, "invalid access token", http.StatusInternalServerError)
+		return
+	}
+
+	u, err := c.s.GetProfile(r.Context(), userID)
+	if errors.Is(err, ErrUserNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(web.NewResTemplate("success", newProfileResp(u)))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("decoding user into json: %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+}
+
+type UpdateProfileReqBody struct {
+	Name            *string `json:"name"`
+	Email           *string `json:"email"`
+	Password        *string `json:"password"`
+	CurrentPassword string  `json:"currentPassword"`
+}
+
+func (u UpdateProfileReqBody) Validate() bool {
+	// at least one field must be updated
+	if u.Name == nil && u.Email == nil && u.Password == nil {
+		return false
+	}
+
+	// name follows the same rule as registration
+	if u.Name != nil && !isValidName(*u.Name) {
+		return false
+	}
+
+	// email should be in valid email format
+	if u.Email != nil {
+		_, parseEmailErr := mail.ParseAddress(*u.Email)
+		if parseEmailErr != nil {
+			return false
+		}
+	}
+
+	// new password follows the same rule as registration and requires the current one
+	if u.Password != nil && (!isValidPassword(*u.Password) || u.CurrentPassword == "") {
+		return false
+	}
+
+	return true
+}
+
+func (c Controller) UpdateProfileHandler(w http.ResponseWriter, r *http.Request) {
+	reqBody, err := web.DecodeReqBody[UpdateProfileReqBody](r.Body)
+	if errors.Is(err, web.ErrInvalidReqBody) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	userID, ok := UserIDFromContext(r.Context())
+	if !ok || userID == "" {
+		http.Error(w, "invalid access token", http.StatusInternalServerError)
+		return
+	}
+	sessionID, _ := SessionIDFromContext(r.Context())
+
+	u, err := c.s.UpdateProfile(r.Context(), UpdateProfileArgs{
+		UserID:          userID,
+		SessionID:       sessionID,
+		Name:            reqBody.Name,
+		Email:           reqBody.Email,
+		Password:        reqBody.Password,
+		CurrentPassword: reqBody.CurrentPassword,
+	})
+	var lockedErr LoginLockedError
+	if errors.As(err, &lockedErr) {
+		writeLoginLocked(w, lockedErr)
+		return
+	}
+	if errors.Is(err, ErrInvalidPassword) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, ErrUniqueEmailViolation) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if errors.Is(err, ErrUserNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// the profile has been updated, the user could ask for another verification email
+	if reqBody.Email != nil && u.EmailVerifiedAt == nil {
+		err = c.s.SendVerificationEmail(r.Context(), userID)
+		if err != nil {
+			log.Printf("sending verification email: %s\n", err.Error())
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(web.NewResTemplate("User updated successfully", newProfileResp(u)))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("decoding user into json: %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+}
+
+func (c Controller) DeleteAccountHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := UserIDFromContext(r.Context())
+	if !ok || userID == "" {
+		http.Error(w, "invalid access token", http.StatusInternalServerError)
+		return
+	}
+
+	err := c.s.DeleteAccount(r.Context(), userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (c Controller) ExportDataHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := UserIDFromContext(r.Context())
+	if !ok || userID == "" {
+		http.Error(w, "invalid access token", http.StatusInternalServerError)
+		return
+	}
+
+	export, err := c.s.ExportData(r.Context(), userID)
+	if errors.Is(err, ErrUserNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	resp := map[string]any{"profile": newProfileResp(export.Profile)}
+	for name, data := range export.Data {
+		resp[name] = data
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", `attachment; filename="catsocial-export.json"`)
+	err = json.NewEncoder(w).Encode(resp)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("decoding export into json: %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+}
+
+type AdminUserResp struct {
+	ID            string  `json:"id"`
+	Email         string  `json:"email"`
+	Name          string  `json:"name"`
+	Role          string  `json:"role"`
+	EmailVerified bool    `json:"emailVerified"`
+	BannedAt      *string `json:"bannedAt"`
+	DeletedAt     *string `json:"deletedAt"`
+	CreatedAt     string  `json:"createdAt"`
+}
+
+func formatOptionalTime(t *time.Time) *string {
+	if t == nil {
+		return nil
+	}
+	formatted := t.Format(time.RFC3339)
+	return &formatted
+}
+
+func (c Controller) ListUsersHandler(w http.ResponseWriter, r *http.Request) {
+	queries := r.URL.Query()
+
+	limit := 10
+	if l := queries.Get("limit"); l != "" {
+		intLimit, err := strconv.Atoi(l)
+		if err != nil || intLimit < 1 || intLimit > 100 {
+			http.Error(w, "limit must be between 1 and 100", http.StatusBadRequest)
+			return
+		}
+		limit = intLimit
+	}
+
+	offset := 0
+	if o := queries.Get("offset"); o != "" {
+		intOffset, err := strconv.Atoi(o)
+		if err != nil || intOffset < 0 {
+			http.Error(w, "offset must not be negative", http.StatusBadRequest)
+			return
+		}
+		offset = intOffset
+	}
+
+	users, err := c.s.ListUsers(r.Context(), ListUsersArgs{Limit: limit, Offset: offset})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	resp := make([]AdminUserResp, 0, len(users))
+	for _, u := range users {
+		resp = append(resp, AdminUserResp{
+			ID:            strconv.Itoa(u.ID),
+			Email:         u.Email,
+			Name:          u.Name,
+			Role:          string(u.Role),
+			EmailVerified: u.EmailVerifiedAt != nil,
+			BannedAt:      formatOptionalTime(u.BannedAt),
+			DeletedAt:     formatOptionalTime(u.DeletedAt),
+			CreatedAt:     u.CreatedAt.Format(time.RFC3339),
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(web.NewResTemplate("success", resp))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("decoding users into json: %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+}
+
+func (c Controller) BanHandler(w http.ResponseWriter, r *http.Request) {
+	adminID, ok := UserIDFromContext(r.Context())
+	if !ok || adminID == "" {
+		http.Error(w, "invalid access token", http.StatusInternalServerError)
+		return
+	}
+
+	userID := r.PathValue("id")
+	if _, err := strconv.Atoi(userID); err != nil {
+		http.Error(w, ErrUserNotFound.Error(), http.StatusNotFound)
+		return
+	}
+
+	err := c.s.Ban(r.Context(), BanArgs{
+		AdminID: adminID,
+		UserID:  userID,
+	})
+	if errors.Is(err, ErrCannotBanYourself) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, ErrUserNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (c Controller) UnbanHandler(w http.ResponseWriter, r *http.Request) {
+	userID := r.PathValue("id")
+	if _, err := strconv.Atoi(userID); err != nil {
+		http.Error(w, ErrUserNotFound.Error(), http.StatusNotFound)
+		return
+	}
+
+	err := c.s.Unban(r.Context(), userID)
+	if errors.Is(err, ErrUserNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+type EnrollTOTPResp struct {
+	Secret     string `json:"secret"`
+	OTPAuthURI string `json:"otpauthUri"`
+}
+
+func (c Controller) EnrollTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := UserIDFromContext(r.Context())
+	if !ok || userID == "" {
+		http.Error(w, "invalid access token", http.StatusInternalServerError)
+		return
+	}
+
+	enrollment, err := c.s.EnrollTOTP(r.Context(), userID)
+	if errors.Is(err, ErrTOTPAlreadyEnabled) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	resp := EnrollTOTPResp{
+		Secret:     enrollment.Secret,
+		OTPAuthURI: enrollment.URI,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(web.NewResTemplate("success", resp))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("decoding enrollment into json: %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+}
+
+type TOTPCodeReqBody struct {
+	Code string `json:"code"`
+}
+
+func (t TOTPCodeReqBody) Validate() bool {
+	return t.Code != ""
+}
+
+type ConfirmTOTPResp struct {
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+func (c Controller) ConfirmTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	reqBody, err := web.DecodeReqBody[TOTPCodeReqBody](r.Body)
+	if errors.Is(err, web.ErrInvalidReqBody) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	userID, ok := UserIDFromContext(r.Context())
+	if !ok || userID == "" {
+		http.Error(w, "invalid access token", http.StatusInternalServerError)
+		return
+	}
+
+	codes, err := c.s.ConfirmTOTP(r.Context(), ConfirmTOTPArgs{
+		UserID: userID,
+		Code:   reqBody.Code,
+	})
+	if errors.Is(err, ErrTOTPAlreadyEnabled) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if errors.Is(err, ErrTOTPNotEnrolled) || errors.Is(err, ErrInvalidTOTPCode) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(web.NewResTemplate("success", ConfirmTOTPResp{RecoveryCodes: codes}))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("decoding recovery codes into json: %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+}
+
+func (c Controller) DisableTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	reqBody, err := web.DecodeReqBody[TOTPCodeReqBody](r.Body)
+	if errors.Is(err, web.ErrInvalidReqBody) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	userID, ok := UserIDFromContext(r.Context())
+	if !ok || userID == "" {
+		http.Error(w, "invalid access token", http.StatusInternalServerError)
+		return
+	}
+
+	err = c.s.DisableTOTP(r.Context(), DisableTOTPArgs{
+		UserID: userID,
+		Code:   reqBody.Code,
+	})
+	var lockedErr LoginLockedError
+	if errors.As(err, &lockedErr) {
+		writeLoginLocked(w, lockedErr)
+		return
+	}
+	if errors.Is(err, ErrTOTPNotEnabled) || errors.Is(err, ErrInvalidTOTPCode) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+type CreateAPIKeyReqBody struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+}
+
+func (c CreateAPIKeyReqBody) Validate() bool {
+	// name min length 1 and max length 50
+	if len(c.Name) < 1 || len(c.Name) > 50 {
+		return false
+	}
+
+	for _, scope := range c.Scopes {
+		if !Scope(scope).IsValid() {
+			return false
+		}
+	}
+
+	return true
+}
+
+type SessionResp struct {
+	ID         string `json:"id"`
+	UserAgent  string `json:"userAgent"`
+	IP         string `json:"ip"`
+	Current    bool   `json:"current"`
+	CreatedAt  string `json:"createdAt"`
+	LastSeenAt string `json:"lastSeenAt"`
+}
+
+func (c Controller) ListSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := UserIDFromContext(r.Context())
+	if !ok || userID == "" {
+		http.Error(w, "invalid access token", http.StatusInternalServerError)
+		return
+	}
+	currentSessionID, _ := SessionIDFromContext(r.Context())
+
+	sessions, err := c.s.ListSessions(r.Context(), userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	resp := make([]SessionResp, 0, len(sessions))
+	for _, ses := range sessions {
+		resp = append(resp, SessionResp{
+			ID:         ses.ID,
+			UserAgent:  ses.UserAgent,
+			IP:         ses.IP,
+			Current:    ses.ID == currentSessionID,
+			CreatedAt:  ses.CreatedAt.Format(time.RFC3339),
+			LastSeenAt: ses.LastSeenAt.Format(time.RFC3339),
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(web.NewResTemplate("success", resp))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("decoding sessions into json: %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+}
+
+// RevokeSessionHandler logs the user out of one of the sessions,
+// revoking the current session works like LogoutHandler
+func (c Controller) RevokeSessionHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := UserIDFromContext(r.Context())
+	if !ok || userID == "" {
+		http.Error(w, "invalid access token", http.StatusInternalServerError)
+		return
+	}
+
+	err := c.s.RevokeSession(r.Context(), RevokeSessionArgs{
+		UserID:    userID,
+		SessionID: r.PathValue("id"),
+	})
+	if errors.Is(err, ErrSessionNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+type APIKeyResp struct {
+	ID         string   `json:"id"`
+	Name       string   `json:"name"`
+	Prefix     string   `json:"prefix"`
+	Scopes     []string `json:"scopes"`
+	LastUsedAt *string  `json:"lastUsedAt"`
+	CreatedAt  string   `json:"createdAt"`
+}
+
+type CreateAPIKeyResp struct {
+	APIKeyResp
+	// Key is only returned once, when the key is created
+	Key string `json:"key"`
+}
+
+func newAPIKeyResp(k APIKey) APIKeyResp {
+	scopes := make([]string, 0, len(k.Scopes))
+	for _, scope := range k.Scopes {
+		scopes = append(scopes, string(scope))
+	}
+
+	return APIKeyResp{
+		ID:         strconv.Itoa(k.ID),
+		Name:       k.Name,
+		Prefix:     apiKeyPrefix + k.Prefix,
+		Scopes:     scopes,
+		LastUsedAt: formatOptionalTime(k.LastUsedAt),
+		CreatedAt:  k.CreatedAt.Format(time.RFC3339),
+	}
+}
+
+func (c Controller) CreateAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	reqBody, err := web.DecodeReqBody[CreateAPIKeyReqBody](r.Body)
+	if errors.Is(err, web.ErrInvalidReqBody) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	userID, ok := UserIDFromContext(r.Context())
+	if !ok || userID == "" {
+		http.Error(w, "invalid access token", http.StatusInternalServerError)
+		return
+	}
+
+	scopes := make([]Scope, 0, len(reqBody.Scopes))
+	for _, scope := range reqBody.Scopes {
+		scopes = append(scopes, Scope(scope))
+	}
+
+	k, key, err := c.s.CreateAPIKey(r.Context(), CreateAPIKeyArgs{
+		UserID: userID,
+		Name:   reqBody.Name,
+		Scopes: scopes,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	resp := CreateAPIKeyResp{
+		APIKeyResp: newAPIKeyResp(k),
+		Key:        key,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	err = json.NewEncoder(w).Encode(web.NewResTemplate("success", resp))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("decoding api key into json: %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+}
+
+func (c Controller) ListAPIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := UserIDFromContext(r.Context())
+	if !ok || userID == "" {
+		http.Error(w, "invalid access token", http.StatusInternalServerError)
+		return
+	}
+
+	keys, err := c.s.ListAPIKeys(r.Context(), userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	resp := make([]APIKeyResp, 0, len(keys))
+	for _, k := range keys {
+		resp = append(resp, newAPIKeyResp(k))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(web.NewResTemplate("success", resp))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("decoding api keys into json: %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+}
+
+func (c Controller) RevokeAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	keyID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, ErrAPIKeyNotFound.Error(), http.StatusNotFound)
+		return
+	}
+
+	userID, ok := UserIDFromContext(r.Context())
+	if !ok || userID == "" {
+		http.Error(w, "invalid access token", http.StatusInternalServerError)
+		return
+	}
+
+	err = c.s.RevokeAPIKey(r.Context(), RevokeAPIKeyArgs{
+		UserID: userID,
+		ID:     keyID,
+	})
+	if errors.Is(err, ErrAPIKeyNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// JWKSHandler publishes the public keys so other services could verify
+// access tokens without sharing any secret
+func (c Controller) JWKSHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	err := json.NewEncoder(w).Encode(c.s.JWKS())
+	if err != nil {
+		http.Error(w, fmt.Sprintf("decoding jwks into json: %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+}
+
+// AuthMiddleware accepts both access tokens and api keys as bearer tokens. Requests made
+// with an api key carry no session, routes limit them with RequireScope or RequireSession.
+func (c Controller) AuthMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+		if strings.HasPrefix(token, apiKeyPrefix) {
+			k, err := c.s.AuthenticateAPIKey(r.Context(), token)
+			if err != nil {
+				log.Printf("rejecting api key: %s\n", err.Error())
+				http.Error(w, "invalid or revoked api key", http.StatusUnauthorized)
+				return
+			}
+
+			ctx := ContextWithUserID(r.Context(), strconv.Itoa(k.UserID))
+			ctx = context.WithValue(ctx, roleContextKey, RoleUser)
+			ctx = context.WithValue(ctx, apiKeyContextKey, k)
+			next.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
+
+		// requests that change data must not go through on a session that has been
+		// revoked on another instance, e.g. by a ban, while reads may lag behind
+		// for sessionCacheTTL
+		claims, err := c.s.ParseAccessToken(r.Context(), ParseAccessTokenArgs{
+			Token: token,
+			Fresh: !isSafeMethod(r.Method),
+		})
+		if err != nil {
+			log.Printf("rejecting access token: %s\n", err.Error())
+			http.Error(w, "missing or expired access token", http.StatusUnauthorized)
+			return
+		}
+
+		ctx := ContextWithUserID(r.Context(), claims.UserID)
+		ctx = context.WithValue(ctx, sessionIDContextKey, claims.SessionID)
+		ctx = context.WithValue(ctx, roleContextKey, cmp.Or(claims.Role, RoleUser))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// isSafeMethod reports whether requests with the method only read data (RFC 9110 9.2.1)
+func isSafeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// RequireScope lets requests made with an access token through, and requests made with
+// an api key only when the key has the scope. It must be wrapped by AuthMiddleware.
+func (c Controller) RequireScope(scope Scope) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			k, ok := APIKeyFromContext(r.Context())
+			if ok && !k.HasScope(scope) {
+				http.Error(w, fmt.Sprintf("api key is missing the %s scope", scope), http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireSession only lets requests made with an access token through, it guards the
+// routes that manage the account, which api keys must never reach.
+// It must be wrapped by AuthMiddleware.
+func (c Controller) RequireSession(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := APIKeyFromContext(r.Context()); ok {
+			http.Error(w, "api keys could not be used for this route", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireRole only lets users with one of the given roles through,
+// it must be wrapped by AuthMiddleware
+func (c Controller) RequireRole(roles ...Role) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			role, ok := RoleFromContext(r.Context())
+			if !ok || !slices.Contains(roles, role) {
+				http.Error(w, "insufficient role", http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireVerifiedEmail only lets users with a verified email through,
+// it must be wrapped by AuthMiddleware
+func (c Controller) RequireVerifiedEmail(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID, ok := UserIDFromContext(r.Context())
+		if !ok || userID == "" {
+			http.Error(w, "invalid access token", http.StatusInternalServerError)
+			return
+		}
+
+		verified, err := c.s.IsEmailVerified(r.Context(), userID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !verified {
+			http.Error(w, ErrEmailNotVerified.Error(), http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// clientIP returns the IP of the remote address of the request
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// ContextWithUserID returns a copy of ctx carrying the id of the authenticated user
+func ContextWithUserID(ctx context.Context, userID string) context.Context {
+	return context.WithValue(ctx, userIDContextKey, userID)
+}
+
+func UserIDFromContext(ctx context.Context) (string, bool) {
+	userID, ok := ctx.Value(userIDContextKey).(string)
+	return userID, ok
+}
+
+func SessionIDFromContext(ctx context.Context) (string, bool) {
+	sessionID, ok := ctx.Value(sessionIDContextKey).(string)
+	return sessionID, ok
+}
+
+// APIKeyFromContext returns the api key the request has been made with, if any
+func APIKeyFromContext(ctx context.Context) (APIKey, bool) {
+	k, ok := ctx.Value(apiKeyContextKey).(APIKey)
+	return k, ok
+}
+
+func RoleFromContext(ctx context.Context) (Role, bool) {
+	role, ok := ctx.Value(roleContextKey).(Role)
+	return role, ok
 }
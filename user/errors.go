@@ -1,9 +1,45 @@
 package user
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"time"
+)
 
 var (
 	ErrUniqueEmailViolation = errors.New("unique email constraint violation")
 	ErrUserNotFound         = errors.New("user not found")
 	ErrInvalidPassword      = errors.New("invalid password")
+	ErrInvalidRefreshToken  = errors.New("invalid or expired refresh token")
+	ErrRefreshTokenReused   = errors.New("refresh token has already been used")
+	ErrSessionRevoked       = errors.New("session has been revoked")
+	ErrSessionNotFound      = errors.New("session not found")
+	ErrInvalidUserToken     = errors.New("invalid, used or expired token")
+	ErrEmailAlreadyVerified = errors.New("email has already been verified")
+	ErrEmailNotVerified     = errors.New("email has not been verified")
+	ErrUserBanned           = errors.New("user has been banned")
+	ErrCannotBanYourself    = errors.New("admins could not ban themselves")
+	ErrTooManyLoginAttempts = errors.New("too many failed login attempts")
+	ErrTOTPAlreadyEnabled   = errors.New("two factor authentication is already enabled")
+	ErrTOTPNotEnrolled      = errors.New("two factor authentication has not been enrolled")
+	ErrTOTPNotEnabled       = errors.New("two factor authentication is not enabled")
+	ErrInvalidTOTPCode      = errors.New("invalid two factor authentication code")
+	ErrInvalidAPIKey        = errors.New("invalid or revoked api key")
+	ErrAPIKeyNotFound       = errors.New("api key not found")
+	ErrIdentityNotVerified  = errors.New("identity provider has not verified the email")
+	ErrInvalidOIDCState     = errors.New("invalid or expired oidc login state")
 )
+
+// LoginLockedError is returned while the login is locked after too many failures,
+// it wraps ErrTooManyLoginAttempts
+type LoginLockedError struct {
+	RetryAfter time.Duration
+}
+
+func (e LoginLockedError) Error() string {
+	return fmt.Sprintf("%s, retry after %s", ErrTooManyLoginAttempts, e.RetryAfter.Round(time.Second))
+}
+
+func (e LoginLockedError) Unwrap() error {
+	return ErrTooManyLoginAttempts
+}
@@ -0,0 +1,192 @@
+package user
+
+import (
+	"catsocial/pkg/oidc"
+	"context"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+type (
+	oidcClient interface {
+		AuthCodeURL(ctx context.Context, state, nonce, codeChallenge string) (string, error)
+		Exchange(ctx context.Context, code, codeVerifier, nonce string) (oidc.IDClaims, error)
+		Issuer() string
+		RedirectURL() string
+	}
+
+	// OIDCController logs users in with an OpenID Connect provider through the
+	// authorization code flow with PKCE, and then answers like LoginHandler
+	OIDCController struct {
+		c      Controller
+		client oidcClient
+	}
+
+	// oidcLoginState is kept in a cookie between the redirect to the provider
+	// and the callback
+	oidcLoginState struct {
+		State        string `json:"state"`
+		Nonce        string `json:"nonce"`
+		CodeVerifier string `json:"codeVerifier"`
+	}
+)
+
+const (
+	oidcStateCookie    = "catsocial_oidc"
+	oidcStateCookieTTL = 10 * time.Minute
+)
+
+func NewOIDCController(c Controller, client oidcClient) OIDCController {
+	return OIDCController{c: c, client: client}
+}
+
+// LoginHandler redirects the user to the provider
+func (o OIDCController) LoginHandler(w http.ResponseWriter, r *http.Request) {
+	state, err := oidc.RandomString()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	nonce, err := oidc.RandomString()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	verifier, challenge, err := oidc.NewPKCE()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	authURL, err := o.client.AuthCodeURL(r.Context(), state, nonce, challenge)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	cookieValue, err := json.Marshal(oidcLoginState{State: state, Nonce: nonce, CodeVerifier: verifier})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.SetCookie(w, o.stateCookie(base64.RawURLEncoding.EncodeToString(cookieValue), int(oidcStateCookieTTL.Seconds())))
+
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// CallbackHandler exchanges the authorization code of the provider for the identity
+// of the user, and logs the user linked to that identity in
+func (o OIDCController) CallbackHandler(w http.ResponseWriter, r *http.Request) {
+	queries := r.URL.Query()
+	if providerErr := queries.Get("error"); providerErr != "" {
+		http.Error(w, "identity provider: "+providerErr+": "+queries.Get("error_description"), http.StatusBadRequest)
+		return
+	}
+
+	loginState, err := o.readState(r)
+	// the state is single use, whatever happens next
+	http.SetCookie(w, o.stateCookie("", -1))
+	if err != nil || subtle.ConstantTimeCompare([]byte(loginState.State), []byte(queries.Get("state"))) != 1 {
+		http.Error(w, ErrInvalidOIDCState.Error(), http.StatusBadRequest)
+		return
+	}
+
+	code := queries.Get("code")
+	if code == "" {
+		http.Error(w, "code is empty", http.StatusBadRequest)
+		return
+	}
+
+	claims, err := o.client.Exchange(r.Context(), code, loginState.CodeVerifier, loginState.Nonce)
+	if errors.Is(err, oidc.ErrInvalidIDToken) || errors.Is(err, oidc.ErrNonceMismatched) {
+		log.Printf("rejecting oidc id token: %s\n", err.Error())
+		http.Error(w, "invalid id token", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	u, err := o.c.s.LoginWithIdentity(r.Context(), LoginWithIdentityArgs{
+		Issuer:        o.client.Issuer(),
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: claims.EmailVerified,
+		Name:          claims.Name,
+	})
+	if errors.Is(err, ErrIdentityNotVerified) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if u.BannedAt != nil {
+		http.Error(w, ErrUserBanned.Error(), http.StatusForbidden)
+		return
+	}
+
+	o.c.completeLogin(w, r, u)
+}
+
+func (o OIDCController) stateCookie(value string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    value,
+		Path:     "/v1/user/oidc",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(o.client.RedirectURL(), "https://"),
+		// Lax lets the cookie through on the top level redirect back from the provider
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+func (o OIDCController) readState(r *http.Request) (oidcLoginState, error) {
+	var s oidcLoginState
+
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil {
+		return s, err
+	}
+	b, err := base64.RawURLEncoding.DecodeString(cookie.Value)
+	if err != nil {
+		return s, err
+	}
+	err = json.Unmarshal(b, &s)
+	if err != nil {
+		return s, err
+	}
+	if s.State == "" || s.Nonce == "" || s.CodeVerifier == "" {
+		return s, ErrInvalidOIDCState
+	}
+
+	return s, nil
+}
+
+// identityName keeps the name given by an identity provider within the 5 to 50 characters
+// of a registered name, falling back to the local part of the email
+func identityName(name string, email string) string {
+	name = strings.TrimSpace(name)
+	if len(name) < 5 {
+		name, _, _ = strings.Cut(email, "@")
+	}
+	if len(name) < 5 {
+		name = "catsocial user"
+	}
+	for len(name) > 50 {
+		_, size := utf8.DecodeLastRuneInString(name)
+		name = name[:len(name)-size]
+	}
+
+	return name
+}
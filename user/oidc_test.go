@@ -0,0 +1,352 @@
+package user
+
+import (
+	"catsocial/pkg/oidc"
+	"catsocial/pkg/oidc/oidctest"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// identitySvc logs in whichever identity the callback hands over
+type identitySvc struct {
+	svc
+	identities []LoginWithIdentityArgs
+}
+
+func (s *identitySvc) LoginWithIdentity(ctx context.Context, args LoginWithIdentityArgs) (User, error) {
+	s.identities = append(s.identities, args)
+	return User{ID: 7, Email: args.Email, Name: args.Name}, nil
+}
+
+func (s *identitySvc) IssueTokens(ctx context.Context, args IssueTokensArgs) (Tokens, error) {
+	return Tokens{AccessToken: "access", RefreshToken: "refresh"}, nil
+}
+
+func newTestOIDCController(p *oidctest.Provider, s svc) OIDCController {
+	client := oidc.NewClient(oidc.Config{
+		Issuer:      p.Issuer(),
+		ClientID:    p.ClientID,
+		RedirectURL: "http://localhost/v1/user/oidc/callback",
+	})
+	return NewOIDCController(NewController(s), client)
+}
+
+// startOIDCLogin redirects to the provider, and returns the state cookie together
+// with the code and the state the provider redirects back with
+func startOIDCLogin(t *testing.T, p *oidctest.Provider, o OIDCController) (*http.Cookie, string, string) {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	o.LoginHandler(w, httptest.NewRequest(http.MethodGet, "/v1/user/oidc/login", nil))
+	if w.Code != http.StatusFound {
+		t.Fatalf("login status = %d: %s", w.Code, w.Body.String())
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != oidcStateCookie {
+		t.Fatalf("login cookies = %v", cookies)
+	}
+
+	code, state, err := p.Authorize(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cookies[0], code, state
+}
+
+func oidcCallback(o OIDCController, cookie *http.Cookie, code, state string) *httptest.ResponseRecorder {
+	q := url.Values{}
+	q.Set("code", code)
+	q.Set("state", state)
+	r := httptest.NewRequest(http.MethodGet, "/v1/user/oidc/callback?"+q.Encode(), nil)
+	if cookie != nil {
+		r.AddCookie(cookie)
+	}
+
+	w := httptest.NewRecorder()
+	o.CallbackHandler(w, r)
+	return w
+}
+
+func TestOIDCCallback(t *testing.T) {
+	p := oidctest.NewProvider("catsocial", "")
+	defer p.Close()
+	p.SetIdentity(oidctest.Identity{Subject: "sub-42", Email: "tom@example.com", EmailVerified: true, Name: "Tom Cat"})
+	s := &identitySvc{}
+	o := newTestOIDCController(p, s)
+
+	cookie, code, state := startOIDCLogin(t, p, o)
+	w := oidcCallback(o, cookie, code, state)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("callback status = %d: %s", w.Code, w.Body.String())
+	}
+	want := LoginWithIdentityArgs{Issuer: p.Issuer(), Subject: "sub-42", Email: "tom@example.com", EmailVerified: true, Name: "Tom Cat"}
+	if len(s.identities) != 1 || s.identities[0] != want {
+		t.Fatalf("identities = %+v, want %+v", s.identities, want)
+	}
+}
+
+func TestOIDCCallbackRejected(t *testing.T) {
+	tests := []struct {
+		name string
+		// tamper changes the callback request or the provider before the callback
+		tamper func(p *oidctest.Provider, cookie *http.Cookie, code, state *string) *http.Cookie
+		want   int
+	}{
+		{
+			name: "state mismatched",
+			tamper: func(p *oidctest.Provider, cookie *http.Cookie, code, state *string) *http.Cookie {
+				*state = "another-state"
+				return cookie
+			},
+			want: http.StatusBadRequest,
+		},
+		{
+			name: "missing state cookie",
+			tamper: func(p *oidctest.Provider, cookie *http.Cookie, code, state *string) *http.Cookie {
+				return nil
+			},
+			want: http.StatusBadRequest,
+		},
+		{
+			name: "code verifier mismatched",
+			tamper: func(p *oidctest.Provider, cookie *http.Cookie, code, state *string) *http.Cookie {
+				b, _ := base64.RawURLEncoding.DecodeString(cookie.Value)
+				var s oidcLoginState
+				json.Unmarshal(b, &s)
+				s.CodeVerifier = "not-the-verifier"
+				b, _ = json.Marshal(s)
+				return &http.Cookie{Name: cookie.Name, Value: base64.RawURLEncoding.EncodeToString(b)}
+			},
+			want: http.StatusBadGateway,
+		},
+		{
+			name: "nonce mismatched",
+			tamper: func(p *oidctest.Provider, cookie *http.Cookie, code, state *string) *http.Cookie {
+				p.OverrideNonce("another-nonce")
+				return cookie
+			},
+			want: http.StatusUnauthorized,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := oidctest.NewProvider("catsocial", "")
+			defer p.Close()
+			s := &identitySvc{}
+			o := newTestOIDCController(p, s)
+
+			cookie, code, state := startOIDCLogin(t, p, o)
+			cookie = tt.tamper(p, cookie, &code, &state)
+			w := oidcCallback(o, cookie, code, state)
+
+			if w.Code != tt.want {
+				t.Fatalf("callback status = %d, want %d: %s", w.Code, tt.want, w.Body.String())
+			}
+			if len(s.identities) != 0 {
+				t.Fatalf("identities = %+v, want none", s.identities)
+			}
+		})
+	}
+}
+
+// identityRepo keeps users and their identities in memory
+type identityRepo struct {
+	repo
+	users          map[string]User
+	identities     map[string]string
+	revokedUserIDs []string
+}
+
+func newIdentityRepo(users ...User) *identityRepo {
+	r := &identityRepo{users: make(map[string]User), identities: make(map[string]string)}
+	for _, u := range users {
+		r.users[strconv.Itoa(u.ID)] = u
+	}
+	return r
+}
+
+func (r *identityRepo) GetOneByIdentity(ctx context.Context, issuer string, subject string) (User, error) {
+	userID, ok := r.identities[issuer+" "+subject]
+	if !ok {
+		return User{}, ErrUserNotFound
+	}
+	return r.GetOneByID(ctx, userID)
+}
+
+func (r *identityRepo) GetOneByEmail(ctx context.Context, email string) (User, error) {
+	for _, u := range r.users {
+		if u.Email == email {
+			return u, nil
+		}
+	}
+	return User{}, ErrUserNotFound
+}
+
+func (r *identityRepo) GetOneByID(ctx context.Context, id string) (User, error) {
+	u, ok := r.users[id]
+	if !ok {
+		return User{}, ErrUserNotFound
+	}
+	return u, nil
+}
+
+func (r *identityRepo) Create(ctx context.Context, args CreateUserRepoArgs) (string, error) {
+	id := len(r.users) + 1
+	r.users[strconv.Itoa(id)] = User{ID: id, Email: args.Email, Name: args.Name, HashedPassword: args.HashedPassword}
+	return strconv.Itoa(id), nil
+}
+
+func (r *identityRepo) Update(ctx context.Context, args UpdateUserRepoArgs) error {
+	u := r.users[args.ID]
+	if args.HashedPassword != nil {
+		u.HashedPassword = *args.HashedPassword
+	}
+	r.users[args.ID] = u
+	return nil
+}
+
+func (r *identityRepo) MarkEmailVerified(ctx context.Context, userID string) error {
+	u := r.users[userID]
+	now := time.Now()
+	u.EmailVerifiedAt = &now
+	r.users[userID] = u
+	return nil
+}
+
+func (r *identityRepo) RevokeRefreshTokens(ctx context.Context, args RevokeRefreshTokensRepoArgs) error {
+	r.revokedUserIDs = append(r.revokedUserIDs, *args.UserID)
+	return nil
+}
+
+func (r *identityRepo) RevokeAPIKeys(ctx context.Context, args RevokeAPIKeysRepoArgs) (int64, error) {
+	return 0, nil
+}
+
+func (r *identityRepo) CreateIdentity(ctx context.Context, args CreateIdentityRepoArgs) error {
+	r.identities[args.Issuer+" "+args.Subject] = args.UserID
+	return nil
+}
+
+type inlineTrx struct{}
+
+func (inlineTrx) WithTransaction(ctx context.Context, fn func(context.Context) error) error {
+	return fn(ctx)
+}
+
+func TestLoginWithIdentity(t *testing.T) {
+	verifiedAt := time.Now().Add(-time.Hour)
+	verifiedUser := User{ID: 1, Email: "tom@example.com", Name: "Tom Cat", HashedPassword: "hash", EmailVerifiedAt: &verifiedAt}
+	unverifiedUser := User{ID: 1, Email: "tom@example.com", Name: "Tom Cat", HashedPassword: "hash"}
+	identity := LoginWithIdentityArgs{Issuer: "https://idp.example.com", Subject: "sub-42", Email: "tom@example.com", EmailVerified: true, Name: "Tom Cat"}
+	unverifiedIdentity := identity
+	unverifiedIdentity.EmailVerified = false
+
+	tests := []struct {
+		name string
+		repo *identityRepo
+		args LoginWithIdentityArgs
+		// linked is true when the identity must have been linked
+		linked       bool
+		wantErr      error
+		wantPassword string
+		wantRevoked  bool
+	}{
+		{
+			name:    "unverified email is not linked to an existing user",
+			repo:    newIdentityRepo(verifiedUser),
+			args:    unverifiedIdentity,
+			wantErr: ErrIdentityNotVerified,
+		},
+		{
+			name:    "unverified email does not create a user",
+			repo:    newIdentityRepo(),
+			args:    unverifiedIdentity,
+			wantErr: ErrIdentityNotVerified,
+		},
+		{
+			name:         "verified email is linked to the verified user",
+			repo:         newIdentityRepo(verifiedUser),
+			args:         identity,
+			linked:       true,
+			wantPassword: "hash",
+		},
+		{
+			name:         "verified email takes over the unverified user",
+			repo:         newIdentityRepo(unverifiedUser),
+			args:         identity,
+			linked:       true,
+			wantPassword: "",
+			wantRevoked:  true,
+		},
+		{
+			name:   "verified email creates a user",
+			repo:   newIdentityRepo(),
+			args:   identity,
+			linked: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := Service{r: tt.repo, trx: inlineTrx{}, sessions: newSessionCache(sessionCacheTTL)}
+			before := maps.Clone(tt.repo.users)
+
+			u, err := s.LoginWithIdentity(context.Background(), tt.args)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("LoginWithIdentity() error = %v, want %v", err, tt.wantErr)
+			}
+
+			userID, linked := tt.repo.identities[tt.args.Issuer+" "+tt.args.Subject]
+			if linked != tt.linked {
+				t.Fatalf("linked = %v, want %v", linked, tt.linked)
+			}
+			if !tt.linked {
+				if !reflect.DeepEqual(tt.repo.users, before) {
+					t.Fatalf("users have been changed: %+v", tt.repo.users)
+				}
+				return
+			}
+
+			if userID != strconv.Itoa(u.ID) || u.Email != tt.args.Email || u.EmailVerifiedAt == nil {
+				t.Fatalf("LoginWithIdentity() = %+v, linked to %s", u, userID)
+			}
+			if u.HashedPassword != tt.wantPassword {
+				t.Fatalf("password hash = %q, want %q", u.HashedPassword, tt.wantPassword)
+			}
+			if revoked := len(tt.repo.revokedUserIDs) > 0; revoked != tt.wantRevoked {
+				t.Fatalf("sessions revoked = %v, want %v", revoked, tt.wantRevoked)
+			}
+		})
+	}
+}
+
+func TestLoginWithIdentityKnownIdentity(t *testing.T) {
+	verifiedAt := time.Now().Add(-time.Hour)
+	r := newIdentityRepo(User{ID: 1, Email: "tom@example.com", Name: "Tom Cat", EmailVerifiedAt: &verifiedAt})
+	r.identities["https://idp.example.com sub-42"] = "1"
+	s := Service{r: r, trx: inlineTrx{}, sessions: newSessionCache(sessionCacheTTL)}
+
+	// a linked identity logs in even when the provider does not vouch for the email anymore
+	u, err := s.LoginWithIdentity(context.Background(), LoginWithIdentityArgs{
+		Issuer:  "https://idp.example.com",
+		Subject: "sub-42",
+		Email:   "tom@example.com",
+	})
+	if err != nil {
+		t.Fatalf("LoginWithIdentity(): %v", err)
+	}
+	if u.ID != 1 {
+		t.Fatalf("LoginWithIdentity() = %+v, want user 1", u)
+	}
+}
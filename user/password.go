@@ -0,0 +1,219 @@
+package user
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type (
+	// PasswordHasher hashes passwords with one algorithm and its security parameters
+	PasswordHasher interface {
+		Hash(password string) (string, error)
+		// Compare returns ErrInvalidPassword when the password does not match the hash
+		Compare(hash, password string) error
+		// Supports reports whether the hash has been made with the algorithm of the hasher
+		Supports(hash string) bool
+		// NeedsRehash reports whether the hash has been made with other parameters
+		NeedsRehash(hash string) bool
+	}
+
+	BcryptHasher struct {
+		Cost int
+	}
+
+	// Argon2idHasher hashes into the PHC string format,
+	// e.g. $argon2id$v=19$m=65536,t=3,p=2$<salt>$<key>
+	Argon2idHasher struct {
+		// Memory is in KiB
+		Memory  uint32
+		Time    uint32
+		Threads uint8
+		SaltLen uint32
+		KeyLen  uint32
+	}
+
+	// PasswordHashers hashes new passwords with the current hasher, and compares
+	// with whichever hasher supports the stored hash, so hashes of older algorithms
+	// keep working until they are rehashed
+	PasswordHashers struct {
+		current PasswordHasher
+		others  []PasswordHasher
+	}
+)
+
+// NewArgon2idHasher uses the parameters recommended by RFC 9106 for memory constrained
+// environments, memory is in KiB
+func NewArgon2idHasher(memory, time uint32, threads uint8) Argon2idHasher {
+	return Argon2idHasher{
+		Memory:  memory,
+		Time:    time,
+		Threads: threads,
+		SaltLen: 16,
+		KeyLen:  32,
+	}
+}
+
+func NewPasswordHashers(current PasswordHasher, others ...PasswordHasher) PasswordHashers {
+	return PasswordHashers{current: current, others: others}
+}
+
+func (b BcryptHasher) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), b.Cost)
+	if err != nil {
+		return "", fmt.Errorf("bcrypt hash: %w", err)
+	}
+
+	return string(hash), nil
+}
+
+func (b BcryptHasher) Compare(hash, password string) error {
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	if err != nil {
+		return ErrInvalidPassword
+	}
+
+	return nil
+}
+
+func (b BcryptHasher) Supports(hash string) bool {
+	return strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$")
+}
+
+func (b BcryptHasher) NeedsRehash(hash string) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err != nil || cost != b.cost()
+}
+
+// cost is the cost bcrypt actually hashes with, it uses the default cost
+// instead of a cost below the minimum
+func (b BcryptHasher) cost() int {
+	if b.Cost < bcrypt.MinCost {
+		return bcrypt.DefaultCost
+	}
+	return b.Cost
+}
+
+func (a Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, a.SaltLen)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return "", fmt.Errorf("argon2id hash: %w", err)
+	}
+
+	key := argon2.IDKey([]byte(password), salt, a.Time, a.Memory, a.Threads, a.KeyLen)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, a.Memory, a.Time, a.Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+func (a Argon2idHasher) Compare(hash, password string) error {
+	params, salt, key, err := decodeArgon2idHash(hash)
+	if err != nil {
+		return ErrInvalidPassword
+	}
+
+	otherKey := argon2.IDKey([]byte(password), salt, params.Time, params.Memory, params.Threads, uint32(len(key)))
+	if subtle.ConstantTimeCompare(key, otherKey) != 1 {
+		return ErrInvalidPassword
+	}
+
+	return nil
+}
+
+func (a Argon2idHasher) Supports(hash string) bool {
+	return strings.HasPrefix(hash, "$argon2id$")
+}
+
+func (a Argon2idHasher) NeedsRehash(hash string) bool {
+	params, salt, key, err := decodeArgon2idHash(hash)
+	if err != nil {
+		return true
+	}
+
+	return params.Memory != a.Memory || params.Time != a.Time || params.Threads != a.Threads ||
+		uint32(len(salt)) != a.SaltLen || uint32(len(key)) != a.KeyLen
+}
+
+var errInvalidArgon2idHash = errors.New("invalid argon2id hash")
+
+func decodeArgon2idHash(hash string) (Argon2idHasher, []byte, []byte, error) {
+	// "", "argon2id", "v=19", "m=65536,t=3,p=2", salt, key
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return Argon2idHasher{}, nil, nil, errInvalidArgon2idHash
+	}
+
+	var version int
+	_, err := fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil || version != argon2.Version {
+		return Argon2idHasher{}, nil, nil, errInvalidArgon2idHash
+	}
+
+	var params Argon2idHasher
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Time, &params.Threads)
+	if err != nil {
+		return Argon2idHasher{}, nil, nil, errInvalidArgon2idHash
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return Argon2idHasher{}, nil, nil, errInvalidArgon2idHash
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return Argon2idHasher{}, nil, nil, errInvalidArgon2idHash
+	}
+
+	return params, salt, key, nil
+}
+
+func (p PasswordHashers) Hash(password string) (string, error) {
+	return p.current.Hash(password)
+}
+
+func (p PasswordHashers) Compare(hash, password string) error {
+	h := p.hasherOf(hash)
+	if h == nil {
+		return ErrInvalidPassword
+	}
+
+	return h.Compare(hash, password)
+}
+
+func (p PasswordHashers) Supports(hash string) bool {
+	return p.hasherOf(hash) != nil
+}
+
+// NeedsRehash reports whether the hash has not been made by the current hasher
+// with its current parameters
+func (p PasswordHashers) NeedsRehash(hash string) bool {
+	return !p.current.Supports(hash) || p.current.NeedsRehash(hash)
+}
+
+// hashers returns every hasher of p, the current one first
+func (p PasswordHashers) hashers() []PasswordHasher {
+	return append([]PasswordHasher{p.current}, p.others...)
+}
+
+func (p PasswordHashers) hasherOf(hash string) PasswordHasher {
+	if p.current.Supports(hash) {
+		return p.current
+	}
+	for _, h := range p.others {
+		if h.Supports(hash) {
+			return h
+		}
+	}
+
+	return nil
+}
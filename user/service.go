@@ -2,28 +2,147 @@ package user
 
 import (
 	"catsocial/pkg/jwt"
+	"catsocial/pkg/mailer"
+	"catsocial/pkg/pointer"
+	"catsocial/pkg/totp"
 	"context"
+	"crypto/subtle"
+	"errors"
 	"fmt"
+	"log"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
-
-	"golang.org/x/crypto/bcrypt"
 )
 
 type (
 	repo interface {
 		Create(ctx context.Context, args CreateUserRepoArgs) (string, error)
 		GetOneByEmail(ctx context.Context, email string) (User, error)
+		GetOneByID(ctx context.Context, id string) (User, error)
+		MarkEmailVerified(ctx context.Context, userID string) error
+		CreateRefreshToken(ctx context.Context, args CreateRefreshTokenRepoArgs) error
+		GetRefreshTokenByHash(ctx context.Context, args GetRefreshTokenByHashRepoArgs) (RefreshToken, error)
+		RotateRefreshToken(ctx context.Context, id int) error
+		RevokeRefreshTokens(ctx context.Context, args RevokeRefreshTokensRepoArgs) error
+		IsSessionActive(ctx context.Context, sessionID string) (bool, error)
+		CreateSession(ctx context.Context, args CreateSessionRepoArgs) error
+		ListActiveSessions(ctx context.Context, userID string) ([]Session, error)
+		TouchSession(ctx context.Context, sessionID string) error
+		DeleteSessions(ctx context.Context, userID string) error
+		CreateUserToken(ctx context.Context, args CreateUserTokenRepoArgs) error
+		GetUserTokenByHash(ctx context.Context, args GetUserTokenByHashRepoArgs) (UserToken, error)
+		UseUserTokens(ctx context.Context, userID string, purpose TokenPurpose) error
+		Update(ctx context.Context, args UpdateUserRepoArgs) error
+		Anonymize(ctx context.Context, userID string) error
+		List(ctx context.Context, args ListUsersRepoArgs) ([]User, error)
+		SetBanned(ctx context.Context, userID string, banned bool) error
+		RecordFailedLogin(ctx context.Context, key string, window time.Duration) (int, error)
+		LockLogin(ctx context.Context, key string, until time.Time) error
+		GetLoginLockedUntil(ctx context.Context, keys []string) (*time.Time, error)
+		ResetLoginAttempts(ctx context.Context, key string) error
+		SetTOTPSecret(ctx context.Context, userID string, secret string) error
+		EnableTOTP(ctx context.Context, userID string, counter int64) error
+		UseTOTPCounter(ctx context.Context, userID string, counter int64) (bool, error)
+		DisableTOTP(ctx context.Context, userID string) error
+		ReplaceRecoveryCodes(ctx context.Context, userID string, codeHashes []string) error
+		UseRecoveryCode(ctx context.Context, userID string, codeHash string) (bool, error)
+		CreateAPIKey(ctx context.Context, args CreateAPIKeyRepoArgs) (APIKey, error)
+		GetActiveAPIKeyByPrefix(ctx context.Context, prefix string) (APIKey, error)
+		ListAPIKeys(ctx context.Context, userID string) ([]APIKey, error)
+		RevokeAPIKeys(ctx context.Context, args RevokeAPIKeysRepoArgs) (int64, error)
+		TouchAPIKey(ctx context.Context, id int) error
+		GetOneByIdentity(ctx context.Context, issuer string, subject string) (User, error)
+		CreateIdentity(ctx context.Context, args CreateIdentityRepoArgs) error
+		DeleteIdentities(ctx context.Context, userID string) error
+	}
+
+	// DataHandler deletes and exports the data that another package keeps for a user
+	DataHandler interface {
+		DeleteUserData(ctx context.Context, userID string) error
+		ExportUserData(ctx context.Context, userID string) (any, error)
+	}
+
+	mailSender interface {
+		Send(ctx context.Context, msg mailer.Message) error
+	}
+
+	trx interface {
+		WithTransaction(ctx context.Context, fn func(context.Context) error) error
 	}
 
 	Service struct {
-		r         repo
-		saltCount int
-		jwtSecret string
+		r            repo
+		trx          trx
+		hasher       PasswordHasher
+		keyring      jwt.Keyring
+		tokenOpts    jwt.ValidationOptions
+		mailer       mailSender
+		appBaseURL   string
+		dataHandlers map[string]DataHandler
+		// dummyHashes holds a hash made by each algorithm of hasher, see Login
+		dummyHashes []dummyHash
+		sessions    *sessionCache
 	}
+
+	dummyHash struct {
+		hasher PasswordHasher
+		hash   string
+	}
+)
+
+const (
+	accessTokenTTL  = 15 * time.Minute
+	refreshTokenTTL = 30 * 24 * time.Hour
+	resetTokenTTL   = time.Hour
+	verifyTokenTTL  = 24 * time.Hour
 )
 
-func NewService(r repo, saltCount int, jwtSecret string) Service {
-	return Service{r: r, saltCount: saltCount, jwtSecret: jwtSecret}
+// NewService creates the user service, the issuer and audience of tokenOpts are
+// both set on the issued access tokens and required when validating them.
+// appBaseURL is used to build the links that are sent by email.
+// dataHandlers are keyed by the name of their data in the personal data export.
+// Passwords are hashed with hasher, the hashes it does not consider current are
+// rehashed on the next login.
+func NewService(
+	r repo,
+	trx trx,
+	hasher PasswordHasher,
+	keyring jwt.Keyring,
+	tokenOpts jwt.ValidationOptions,
+	m mailSender,
+	appBaseURL string,
+	dataHandlers map[string]DataHandler,
+) Service {
+	hashers := []PasswordHasher{hasher}
+	if hs, ok := hasher.(PasswordHashers); ok {
+		hashers = hs.hashers()
+	}
+
+	// the password is never used, only the time comparing against it takes matters
+	dummyHashes := make([]dummyHash, 0, len(hashers))
+	for _, h := range hashers {
+		hash, err := h.Hash("catsocial-dummy-password")
+		if err != nil {
+			panic(fmt.Sprintf("user: generate dummy password hash: %s", err.Error()))
+		}
+		dummyHashes = append(dummyHashes, dummyHash{hasher: h, hash: hash})
+	}
+
+	return Service{
+		r:            r,
+		trx:          trx,
+		hasher:       hasher,
+		keyring:      keyring,
+		tokenOpts:    tokenOpts,
+		mailer:       m,
+		appBaseURL:   strings.TrimSuffix(appBaseURL, "/"),
+		dataHandlers: dataHandlers,
+		dummyHashes:  dummyHashes,
+		sessions:     newSessionCache(sessionCacheTTL),
+	}
 }
 
 type RegisterArgs struct {
@@ -33,14 +152,14 @@ type RegisterArgs struct {
 }
 
 func (s Service) Register(ctx context.Context, args RegisterArgs) (string, error) {
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(args.Password), s.saltCount)
+	hashedPassword, err := s.hasher.Hash(args.Password)
 	if err != nil {
 		return "", fmt.Errorf("register user: %w", err)
 	}
 
 	id, err := s.r.Create(ctx, CreateUserRepoArgs{
 		Email:          args.Email,
-		HashedPassword: string(hashedPassword),
+		HashedPassword: hashedPassword,
 		Name:           args.Name,
 	})
 	if err != nil {
@@ -53,26 +172,1291 @@ func (s Service) Register(ctx context.Context, args RegisterArgs) (string, error
 type LoginArgs struct {
 	Email    string
 	Password string
+	// IP is the address the login comes from, failures are also counted per IP
+	IP string
 }
 
+// Login checks the credentials of the user. Failed logins are counted per account and
+// per IP, and past a limit the login is locked with an exponential backoff, returning
+// a LoginLockedError. Unknown emails are counted and take about as long as known ones,
+// so they could not be told apart by timing or by lockout.
 func (s Service) Login(ctx context.Context, args LoginArgs) (User, error) {
-	u, err := s.r.GetOneByEmail(ctx, args.Email)
+	accountKey := accountLoginKey(args.Email)
+	ipKey := ipLoginKey(args.IP)
+
+	lockedUntil, err := s.r.GetLoginLockedUntil(ctx, []string{accountKey, ipKey})
 	if err != nil {
+		return User{}, fmt.Errorf("login user: %w", err)
+	}
+	if lockedUntil != nil {
+		return User{}, fmt.Errorf("login user: %w", LoginLockedError{RetryAfter: time.Until(*lockedUntil)})
+	}
+
+	u, err := s.r.GetOneByEmail(ctx, args.Email)
+	if err != nil && !errors.Is(err, ErrUserNotFound) {
 		return u, fmt.Errorf("login user: %w", err)
 	}
+	userFound := err == nil
+
+	// every algorithm compares once, against the stored hash when it has made it and
+	// against its dummy hash otherwise, so the login takes as long for an unknown email
+	// as for a known one whichever algorithm its hash has been stored with
+	var (
+		pwErr    = ErrInvalidPassword
+		compared bool
+	)
+	for _, d := range s.dummyHashes {
+		if userFound && !compared && d.hasher.Supports(u.HashedPassword) {
+			pwErr = d.hasher.Compare(u.HashedPassword, args.Password)
+			compared = true
+			continue
+		}
+		_ = d.hasher.Compare(d.hash, args.Password)
+	}
 
-	pwErr := bcrypt.CompareHashAndPassword([]byte(u.HashedPassword), []byte(args.Password))
-	if pwErr != nil {
+	if !userFound || pwErr != nil {
+		err = s.recordFailedLogin(ctx, accountKey, maxFailedLoginsPerAccount)
+		if err != nil {
+			return u, fmt.Errorf("login user: %w", err)
+		}
+		err = s.recordFailedLogin(ctx, ipKey, maxFailedLoginsPerIP)
+		if err != nil {
+			return u, fmt.Errorf("login user: %w", err)
+		}
+
+		if !userFound {
+			return u, fmt.Errorf("login user: %w", ErrUserNotFound)
+		}
 		return u, fmt.Errorf("login user: %w", ErrInvalidPassword)
 	}
 
+	if u.BannedAt != nil {
+		return u, fmt.Errorf("login user: %w", ErrUserBanned)
+	}
+
+	// the IP is not reset, otherwise logging into an own account would let an attacker
+	// keep guessing the passwords of other accounts
+	err = s.r.ResetLoginAttempts(ctx, accountKey)
+	if err != nil {
+		return u, fmt.Errorf("login user: %w", err)
+	}
+
+	// the login must not fail because the upgrade failed, it is retried on the next login
+	if s.hasher.NeedsRehash(u.HashedPassword) {
+		err = s.rehashPassword(ctx, strconv.Itoa(u.ID), args.Password)
+		if err != nil {
+			log.Printf("rehashing password of user %d: %s\n", u.ID, err.Error())
+		}
+	}
+
+	return u, nil
+}
+
+// rehashPassword stores a hash of the password made with the current hasher and parameters
+func (s Service) rehashPassword(ctx context.Context, userID string, password string) error {
+	hashedPassword, err := s.hasher.Hash(password)
+	if err != nil {
+		return fmt.Errorf("rehash password: %w", err)
+	}
+
+	err = s.r.Update(ctx, UpdateUserRepoArgs{
+		ID:             userID,
+		HashedPassword: &hashedPassword,
+	})
+	if err != nil {
+		return fmt.Errorf("rehash password: %w", err)
+	}
+
+	return nil
+}
+
+func (s Service) recordFailedLogin(ctx context.Context, key string, maxFailures int) error {
+	failures, err := s.r.RecordFailedLogin(ctx, key, failedLoginWindow)
+	if err != nil {
+		return err
+	}
+
+	lock := loginLockDuration(failures, maxFailures)
+	if lock == 0 {
+		return nil
+	}
+
+	err = s.r.LockLogin(ctx, key, time.Now().Add(lock))
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+type Tokens struct {
+	AccessToken  string
+	RefreshToken string
+}
+
+type IssueTokensArgs struct {
+	UserID    string
+	UserAgent string
+	IP        string
+}
+
+// IssueTokens starts a new session for the user and returns its first pair of tokens,
+// the user agent and ip are kept to tell the sessions apart in ListSessions
+func (s Service) IssueTokens(ctx context.Context, args IssueTokensArgs) (Tokens, error) {
+	sessionID, err := generateSessionID()
+	if err != nil {
+		return Tokens{}, fmt.Errorf("issue tokens: %w", err)
+	}
+
+	var tokens Tokens
+	err = s.trx.WithTransaction(ctx, func(ctx context.Context) error {
+		err := s.r.CreateSession(ctx, CreateSessionRepoArgs{
+			ID:        sessionID,
+			UserID:    args.UserID,
+			UserAgent: truncateUserAgent(args.UserAgent),
+			IP:        args.IP,
+		})
+		if err != nil {
+			return fmt.Errorf("create session: %w", err)
+		}
+
+		tokens, err = s.issueTokens(ctx, args.UserID, sessionID)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	})
+	if err != nil {
+		return Tokens{}, fmt.Errorf("issue tokens: %w", err)
+	}
+
+	return tokens, nil
+}
+
+// Refresh rotates the refresh token, the given refresh token could only be used once.
+// Using an already rotated refresh token revokes its whole session since it means
+// the token has most likely been stolen.
+func (s Service) Refresh(ctx context.Context, refreshToken string) (Tokens, error) {
+	var (
+		tokens          Tokens
+		reused          bool
+		reusedSessionID string
+	)
+	err := s.trx.WithTransaction(ctx, func(ctx context.Context) error {
+		t, err := s.r.GetRefreshTokenByHash(ctx, GetRefreshTokenByHashRepoArgs{
+			TokenHash: hashToken(refreshToken),
+			ForUpdate: true,
+		})
+		if err != nil {
+			return fmt.Errorf("get refresh token by hash: %w", err)
+		}
+		if t.RevokedAt != nil || time.Now().After(t.ExpiresAt) {
+			return ErrInvalidRefreshToken
+		}
+
+		// the revocation must be committed, so the error is returned after the transaction
+		if t.RotatedAt != nil {
+			reused = true
+			reusedSessionID = t.SessionID
+			err = s.r.RevokeRefreshTokens(ctx, RevokeRefreshTokensRepoArgs{SessionID: &t.SessionID})
+			if err != nil {
+				return fmt.Errorf("revoke session: %w", err)
+			}
+			return nil
+		}
+
+		err = s.r.RotateRefreshToken(ctx, t.ID)
+		if err != nil {
+			return fmt.Errorf("rotate refresh token: %w", err)
+		}
+
+		tokens, err = s.issueTokens(ctx, strconv.Itoa(t.UserID), t.SessionID)
+		if err != nil {
+			return err
+		}
+
+		err = s.r.TouchSession(ctx, t.SessionID)
+		if err != nil {
+			return fmt.Errorf("touch session: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return Tokens{}, fmt.Errorf("refresh tokens: %w", err)
+	}
+	if reused {
+		s.sessions.forget(reusedSessionID)
+		return Tokens{}, fmt.Errorf("refresh tokens: %w", ErrRefreshTokenReused)
+	}
+
+	return tokens, nil
+}
+
+// Logout revokes a single session
+func (s Service) Logout(ctx context.Context, sessionID string) error {
+	err := s.r.RevokeRefreshTokens(ctx, RevokeRefreshTokensRepoArgs{SessionID: &sessionID})
+	if err != nil {
+		return fmt.Errorf("logout: %w", err)
+	}
+	s.sessions.forget(sessionID)
+
+	return nil
+}
+
+// LogoutAll revokes every session of the user
+func (s Service) LogoutAll(ctx context.Context, userID string) error {
+	err := s.r.RevokeRefreshTokens(ctx, RevokeRefreshTokensRepoArgs{UserID: &userID})
+	if err != nil {
+		return fmt.Errorf("logout all: %w", err)
+	}
+	s.sessions.forgetUser(userID)
+
+	return nil
+}
+
+type ParseAccessTokenArgs struct {
+	Token string
+	// Fresh checks the session in the database even when it is cached,
+	// e.g. for requests that change data
+	Fresh bool
+}
+
+// ParseAccessToken returns the claims of a valid access token whose session has not been
+// revoked, otherwise the error tells why the token has been rejected. Active sessions are
+// cached for sessionCacheTTL, the last seen time of the session is set on every cache miss.
+// The sessions of privileged roles are never taken from the cache, so a revocation made on
+// another instance stops them right away.
+func (s Service) ParseAccessToken(ctx context.Context, args ParseAccessTokenArgs) (AccessClaims, error) {
+	claims, err := jwt.Parse[AccessClaims](args.Token, s.keyring, s.tokenOpts)
+	if err != nil {
+		return claims, fmt.Errorf("parse access token: %w", err)
+	}
+	if claims.UserID == "" || claims.SessionID == "" {
+		return claims, fmt.Errorf("parse access token: missing userId or sid: %w", jwt.ErrTokenMalformed)
+	}
+
+	privileged := claims.Role != "" && claims.Role != RoleUser
+	if !args.Fresh && !privileged && s.sessions.isActive(claims.SessionID) {
+		return claims, nil
+	}
+
+	active, err := s.r.IsSessionActive(ctx, claims.SessionID)
+	if err != nil {
+		return claims, fmt.Errorf("parse access token: %w", err)
+	}
+	if !active {
+		s.sessions.forget(claims.SessionID)
+		return claims, fmt.Errorf("parse access token: %w", ErrSessionRevoked)
+	}
+	s.sessions.markActive(claims.SessionID, claims.UserID)
+
+	// the request goes on without an up to date last seen time
+	err = s.r.TouchSession(ctx, claims.SessionID)
+	if err != nil {
+		log.Printf("touching session: %s\n", err.Error())
+	}
+
+	return claims, nil
+}
+
+// ListSessions returns the active sessions of the user, the most recently seen first
+func (s Service) ListSessions(ctx context.Context, userID string) ([]Session, error) {
+	sessions, err := s.r.ListActiveSessions(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("list sessions: %w", err)
+	}
+
+	return sessions, nil
+}
+
+type RevokeSessionArgs struct {
+	UserID    string
+	SessionID string
+}
+
+// RevokeSession revokes an active session of the user, the access tokens of the session
+// are rejected right away by this instance, and by the others right away for requests that
+// change data and after sessionCacheTTL for reads
+func (s Service) RevokeSession(ctx context.Context, args RevokeSessionArgs) error {
+	err := s.trx.WithTransaction(ctx, func(ctx context.Context) error {
+		sessions, err := s.r.ListActiveSessions(ctx, args.UserID)
+		if err != nil {
+			return fmt.Errorf("list active sessions: %w", err)
+		}
+
+		found := false
+		for _, ses := range sessions {
+			if ses.ID == args.SessionID {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return ErrSessionNotFound
+		}
+
+		err = s.r.RevokeRefreshTokens(ctx, RevokeRefreshTokensRepoArgs{
+			SessionID: &args.SessionID,
+			UserID:    &args.UserID,
+		})
+		if err != nil {
+			return fmt.Errorf("revoke refresh tokens: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("revoke session: %w", err)
+	}
+	s.sessions.forget(args.SessionID)
+
+	return nil
+}
+
+func (s Service) GetProfile(ctx context.Context, userID string) (User, error) {
+	u, err := s.r.GetOneByID(ctx, userID)
+	if err != nil {
+		return u, fmt.Errorf("get profile: %w", err)
+	}
+
 	return u, nil
 }
 
-func (s Service) GetAccessToken(userID string) (string, error) {
-	return jwt.GenerateToken(8*time.Hour, s.jwtSecret, map[string]any{"userId": userID})
+type UpdateProfileArgs struct {
+	UserID    string
+	SessionID string
+	Name      *string
+	Email     *string
+	Password  *string
+	// CurrentPassword is required to change the password
+	CurrentPassword string
+}
+
+// UpdateProfile updates the given fields only. A new email has to be verified again
+// through SendVerificationEmail, and a new password revokes every session other
+// than the current one. A wrong current password counts as a failed login.
+func (s Service) UpdateProfile(ctx context.Context, args UpdateProfileArgs) (User, error) {
+	var (
+		u            User
+		emailChanged bool
+		checkErr     error
+	)
+	err := s.trx.WithTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		u, err = s.r.GetOneByID(ctx, args.UserID)
+		if err != nil {
+			return fmt.Errorf("get user by id: %w", err)
+		}
+
+		updateArgs := UpdateUserRepoArgs{ID: args.UserID}
+
+		if args.Password != nil {
+			checkErr = s.checkCurrentPassword(ctx, u, args.CurrentPassword)
+			if errors.Is(checkErr, ErrInvalidPassword) {
+				// the failure that has been recorded must be committed
+				return nil
+			}
+			if checkErr != nil {
+				return checkErr
+			}
+
+			hashedPassword, err := s.hasher.Hash(*args.Password)
+			if err != nil {
+				return fmt.Errorf("hash password: %w", err)
+			}
+			updateArgs.HashedPassword = &hashedPassword
+		}
+
+		if args.Name != nil && *args.Name != u.Name {
+			updateArgs.Name = args.Name
+			u.Name = *args.Name
+		}
+
+		if args.Email != nil && *args.Email != u.Email {
+			emailChanged = true
+			updateArgs.Email = args.Email
+			updateArgs.UnverifyEmail = true
+			u.Email = *args.Email
+			u.EmailVerifiedAt = nil
+		}
+
+		err = s.r.Update(ctx, updateArgs)
+		if err != nil {
+			return fmt.Errorf("update user: %w", err)
+		}
+
+		// links sent to the previous email must not verify the new one
+		if emailChanged {
+			err = s.r.UseUserTokens(ctx, args.UserID, TokenPurposeEmailVerification)
+			if err != nil {
+				return fmt.Errorf("use verification tokens: %w", err)
+			}
+		}
+
+		if args.Password != nil {
+			err = s.r.RevokeRefreshTokens(ctx, RevokeRefreshTokensRepoArgs{
+				UserID:           &args.UserID,
+				ExcludeSessionID: &args.SessionID,
+			})
+			if err != nil {
+				return fmt.Errorf("revoke other sessions: %w", err)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return u, fmt.Errorf("update profile: %w", err)
+	}
+	if checkErr != nil {
+		return u, fmt.Errorf("update profile: %w", checkErr)
+	}
+	if args.Password != nil {
+		s.sessions.forgetUser(args.UserID)
+	}
+
+	return u, nil
+}
+
+// checkCurrentPassword compares the password the user has given to change it. Failures
+// are throttled with the failed logins of the account, so a stolen access token could
+// not be used to brute force the password.
+func (s Service) checkCurrentPassword(ctx context.Context, u User, password string) error {
+	key := accountLoginKey(u.Email)
+
+	lockedUntil, err := s.r.GetLoginLockedUntil(ctx, []string{key})
+	if err != nil {
+		return err
+	}
+	if lockedUntil != nil {
+		return LoginLockedError{RetryAfter: time.Until(*lockedUntil)}
+	}
+
+	if s.hasher.Compare(u.HashedPassword, password) != nil {
+		err = s.recordFailedLogin(ctx, key, maxFailedLoginsPerAccount)
+		if err != nil {
+			return err
+		}
+		return ErrInvalidPassword
+	}
+
+	err = s.r.ResetLoginAttempts(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// DeleteAccount anonymizes the user, then deletes or withdraws the data other packages
+// keep for the user, and revokes every session. Everything happens in one transaction.
+func (s Service) DeleteAccount(ctx context.Context, userID string) error {
+	err := s.trx.WithTransaction(ctx, func(ctx context.Context) error {
+		err := s.r.Anonymize(ctx, userID)
+		if err != nil {
+			return fmt.Errorf("anonymize user: %w", err)
+		}
+
+		names := make([]string, 0, len(s.dataHandlers))
+		for name := range s.dataHandlers {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			err = s.dataHandlers[name].DeleteUserData(ctx, userID)
+			if err != nil {
+				return fmt.Errorf("delete user %s: %w", name, err)
+			}
+		}
+
+		for _, purpose := range []TokenPurpose{TokenPurposePasswordReset, TokenPurposeEmailVerification, TokenPurposeLoginChallenge} {
+			err = s.r.UseUserTokens(ctx, userID, purpose)
+			if err != nil {
+				return fmt.Errorf("use user tokens: %w", err)
+			}
+		}
+
+		err = s.r.DisableTOTP(ctx, userID)
+		if err != nil {
+			return fmt.Errorf("disable totp: %w", err)
+		}
+
+		_, err = s.r.RevokeAPIKeys(ctx, RevokeAPIKeysRepoArgs{UserID: userID})
+		if err != nil {
+			return fmt.Errorf("revoke api keys: %w", err)
+		}
+
+		err = s.r.DeleteIdentities(ctx, userID)
+		if err != nil {
+			return fmt.Errorf("delete identities: %w", err)
+		}
+
+		err = s.r.RevokeRefreshTokens(ctx, RevokeRefreshTokensRepoArgs{UserID: &userID})
+		if err != nil {
+			return fmt.Errorf("revoke sessions: %w", err)
+		}
+
+		err = s.r.DeleteSessions(ctx, userID)
+		if err != nil {
+			return fmt.Errorf("delete sessions: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("delete account: %w", err)
+	}
+	s.sessions.forgetUser(userID)
+
+	return nil
 }
 
-func (s Service) IsAccessTokenValid(token string) (map[string]any, bool) {
-	return jwt.IsTokenValid(token, s.jwtSecret)
+type Export struct {
+	Profile User
+	Data    map[string]any
+}
+
+// ExportData collects the profile of the user and the data other packages keep for the user
+func (s Service) ExportData(ctx context.Context, userID string) (Export, error) {
+	u, err := s.r.GetOneByID(ctx, userID)
+	if err != nil {
+		return Export{}, fmt.Errorf("export data: %w", err)
+	}
+
+	export := Export{Profile: u, Data: make(map[string]any, len(s.dataHandlers))}
+	for name, h := range s.dataHandlers {
+		data, err := h.ExportUserData(ctx, userID)
+		if err != nil {
+			return Export{}, fmt.Errorf("export data: %s: %w", name, err)
+		}
+		export.Data[name] = data
+	}
+
+	return export, nil
+}
+
+type ListUsersArgs struct {
+	Limit  int
+	Offset int
+}
+
+func (s Service) ListUsers(ctx context.Context, args ListUsersArgs) ([]User, error) {
+	users, err := s.r.List(ctx, ListUsersRepoArgs{Limit: args.Limit, Offset: args.Offset})
+	if err != nil {
+		return nil, fmt.Errorf("list users: %w", err)
+	}
+
+	return users, nil
+}
+
+type BanArgs struct {
+	// AdminID is the admin who bans the user
+	AdminID string
+	UserID  string
+}
+
+// Ban blocks the user from logging in and revokes every session of the user, so the access
+// tokens of the user stop working right away, except for reads on other instances that
+// may go through for sessionCacheTTL
+func (s Service) Ban(ctx context.Context, args BanArgs) error {
+	if args.AdminID == args.UserID {
+		return fmt.Errorf("ban user: %w", ErrCannotBanYourself)
+	}
+
+	err := s.trx.WithTransaction(ctx, func(ctx context.Context) error {
+		err := s.r.SetBanned(ctx, args.UserID, true)
+		if err != nil {
+			return fmt.Errorf("set banned: %w", err)
+		}
+
+		err = s.r.RevokeRefreshTokens(ctx, RevokeRefreshTokensRepoArgs{UserID: &args.UserID})
+		if err != nil {
+			return fmt.Errorf("revoke sessions: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("ban user: %w", err)
+	}
+	s.sessions.forgetUser(args.UserID)
+
+	return nil
+}
+
+// Unban lets the user log in again, the sessions revoked by Ban stay revoked
+func (s Service) Unban(ctx context.Context, userID string) error {
+	err := s.r.SetBanned(ctx, userID, false)
+	if err != nil {
+		return fmt.Errorf("unban user: %w", err)
+	}
+
+	return nil
+}
+
+type TOTPEnrollment struct {
+	Secret string
+	// URI is the otpauth URI that authenticator apps read from a QR code
+	URI string
+}
+
+// EnrollTOTP starts the enrollment of a second factor, it has to be confirmed with
+// a first code through ConfirmTOTP before the login requires it
+func (s Service) EnrollTOTP(ctx context.Context, userID string) (TOTPEnrollment, error) {
+	u, err := s.r.GetOneByID(ctx, userID)
+	if err != nil {
+		return TOTPEnrollment{}, fmt.Errorf("enroll totp: %w", err)
+	}
+	if u.TOTPEnabledAt != nil {
+		return TOTPEnrollment{}, fmt.Errorf("enroll totp: %w", ErrTOTPAlreadyEnabled)
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return TOTPEnrollment{}, fmt.Errorf("enroll totp: %w", err)
+	}
+
+	err = s.r.SetTOTPSecret(ctx, userID, secret)
+	if err != nil {
+		return TOTPEnrollment{}, fmt.Errorf("enroll totp: %w", err)
+	}
+
+	return TOTPEnrollment{
+		Secret: secret,
+		URI:    totp.URI(totpIssuer, u.Email, secret, totpOptions),
+	}, nil
+}
+
+type ConfirmTOTPArgs struct {
+	UserID string
+	Code   string
+}
+
+// ConfirmTOTP enables the enrolled second factor and returns the recovery codes,
+// they are only stored hashed so they could not be shown again
+func (s Service) ConfirmTOTP(ctx context.Context, args ConfirmTOTPArgs) ([]string, error) {
+	var codes []string
+	err := s.trx.WithTransaction(ctx, func(ctx context.Context) error {
+		u, err := s.r.GetOneByID(ctx, args.UserID)
+		if err != nil {
+			return fmt.Errorf("get user: %w", err)
+		}
+		if u.TOTPEnabledAt != nil {
+			return ErrTOTPAlreadyEnabled
+		}
+		if u.TOTPSecret == nil {
+			return ErrTOTPNotEnrolled
+		}
+
+		counter, err := totp.Validate(*u.TOTPSecret, args.Code, totpOptions)
+		if errors.Is(err, totp.ErrInvalidCode) {
+			return ErrInvalidTOTPCode
+		}
+		if err != nil {
+			return err
+		}
+
+		err = s.r.EnableTOTP(ctx, args.UserID, counter)
+		if err != nil {
+			return fmt.Errorf("enable totp: %w", err)
+		}
+
+		codes, err = generateRecoveryCodes()
+		if err != nil {
+			return err
+		}
+		codeHashes := make([]string, 0, len(codes))
+		for _, code := range codes {
+			codeHashes = append(codeHashes, hashToken(normalizeRecoveryCode(code)))
+		}
+
+		err = s.r.ReplaceRecoveryCodes(ctx, args.UserID, codeHashes)
+		if err != nil {
+			return fmt.Errorf("store recovery codes: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("confirm totp: %w", err)
+	}
+
+	return codes, nil
+}
+
+type DisableTOTPArgs struct {
+	UserID string
+	// Code is either a TOTP code or a recovery code
+	Code string
+}
+
+func (s Service) DisableTOTP(ctx context.Context, args DisableTOTPArgs) error {
+	u, err := s.r.GetOneByID(ctx, args.UserID)
+	if err != nil {
+		return fmt.Errorf("disable totp: %w", err)
+	}
+	if u.TOTPEnabledAt == nil {
+		return fmt.Errorf("disable totp: %w", ErrTOTPNotEnabled)
+	}
+
+	err = s.checkSecondFactor(ctx, u, args.Code)
+	if err != nil {
+		return fmt.Errorf("disable totp: %w", err)
+	}
+
+	err = s.r.DisableTOTP(ctx, args.UserID)
+	if err != nil {
+		return fmt.Errorf("disable totp: %w", err)
+	}
+
+	return nil
+}
+
+// CreateLoginChallenge returns a short lived token that proves the password of the user
+// has been checked, it is exchanged together with a code through LoginTwoFactor
+func (s Service) CreateLoginChallenge(ctx context.Context, userID string) (string, error) {
+	token, err := generateOpaqueToken()
+	if err != nil {
+		return "", fmt.Errorf("create login challenge: %w", err)
+	}
+
+	err = s.r.CreateUserToken(ctx, CreateUserTokenRepoArgs{
+		UserID:    userID,
+		Purpose:   TokenPurposeLoginChallenge,
+		TokenHash: hashToken(token),
+		ExpiresAt: time.Now().Add(loginChallengeTTL),
+	})
+	if err != nil {
+		return "", fmt.Errorf("create login challenge: %w", err)
+	}
+
+	return token, nil
+}
+
+type LoginTwoFactorArgs struct {
+	ChallengeToken string
+	// Code is either a TOTP code or a recovery code
+	Code string
+}
+
+// LoginTwoFactor finishes the login of a user with a second factor, the challenge
+// token could only be used once
+func (s Service) LoginTwoFactor(ctx context.Context, args LoginTwoFactorArgs) (User, error) {
+	var (
+		u        User
+		checkErr error
+	)
+	err := s.trx.WithTransaction(ctx, func(ctx context.Context) error {
+		// the challenge is locked until it is used, so concurrent submissions
+		// of the same challenge could not all pass
+		t, err := s.r.GetUserTokenByHash(ctx, GetUserTokenByHashRepoArgs{
+			TokenHash: hashToken(args.ChallengeToken),
+			Purpose:   TokenPurposeLoginChallenge,
+			ForUpdate: true,
+		})
+		if err != nil {
+			return fmt.Errorf("get user token by hash: %w", err)
+		}
+		if t.UsedAt != nil || time.Now().After(t.ExpiresAt) {
+			return ErrInvalidUserToken
+		}
+
+		userID := strconv.Itoa(t.UserID)
+		u, err = s.r.GetOneByID(ctx, userID)
+		if err != nil {
+			return fmt.Errorf("get user by id: %w", err)
+		}
+		if u.TOTPEnabledAt == nil {
+			return ErrTOTPNotEnabled
+		}
+
+		checkErr = s.checkSecondFactor(ctx, u, args.Code)
+		if errors.Is(checkErr, ErrInvalidTOTPCode) {
+			// the failure that has been recorded must be committed,
+			// the challenge stays unused so the user could try again
+			return nil
+		}
+		if checkErr != nil {
+			return checkErr
+		}
+
+		err = s.r.UseUserTokens(ctx, userID, TokenPurposeLoginChallenge)
+		if err != nil {
+			return fmt.Errorf("use challenge tokens: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return u, fmt.Errorf("login two factor: %w", err)
+	}
+	if checkErr != nil {
+		return u, fmt.Errorf("login two factor: %w", checkErr)
+	}
+
+	return u, nil
+}
+
+// checkSecondFactor accepts a TOTP code that has not been used yet or an unused recovery
+// code. Failures are throttled like failed logins, so the codes could not be brute forced.
+func (s Service) checkSecondFactor(ctx context.Context, u User, code string) error {
+	userID := strconv.Itoa(u.ID)
+	key := twoFactorLoginKey(userID)
+
+	lockedUntil, err := s.r.GetLoginLockedUntil(ctx, []string{key})
+	if err != nil {
+		return err
+	}
+	if lockedUntil != nil {
+		return LoginLockedError{RetryAfter: time.Until(*lockedUntil)}
+	}
+
+	ok, err := s.useSecondFactor(ctx, userID, *u.TOTPSecret, code)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		err = s.recordFailedLogin(ctx, key, maxFailedTwoFactorLogins)
+		if err != nil {
+			return err
+		}
+		return ErrInvalidTOTPCode
+	}
+
+	err = s.r.ResetLoginAttempts(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s Service) useSecondFactor(ctx context.Context, userID, secret, code string) (bool, error) {
+	counter, err := totp.Validate(secret, code, totpOptions)
+	if err == nil {
+		return s.r.UseTOTPCounter(ctx, userID, counter)
+	}
+	if !errors.Is(err, totp.ErrInvalidCode) {
+		return false, err
+	}
+
+	return s.r.UseRecoveryCode(ctx, userID, hashToken(normalizeRecoveryCode(code)))
+}
+
+type CreateAPIKeyArgs struct {
+	UserID string
+	Name   string
+	// Scopes limit what the key could do, no scopes means every scope
+	Scopes []Scope
+}
+
+// CreateAPIKey returns the new api key together with the key itself,
+// the key could not be shown again since only its hash is stored
+func (s Service) CreateAPIKey(ctx context.Context, args CreateAPIKeyArgs) (APIKey, string, error) {
+	key, prefix, err := generateAPIKey()
+	if err != nil {
+		return APIKey{}, "", fmt.Errorf("create api key: %w", err)
+	}
+
+	k, err := s.r.CreateAPIKey(ctx, CreateAPIKeyRepoArgs{
+		UserID:  args.UserID,
+		Name:    args.Name,
+		Prefix:  prefix,
+		KeyHash: hashToken(key),
+		Scopes:  args.Scopes,
+	})
+	if err != nil {
+		return APIKey{}, "", fmt.Errorf("create api key: %w", err)
+	}
+
+	return k, key, nil
+}
+
+func (s Service) ListAPIKeys(ctx context.Context, userID string) ([]APIKey, error) {
+	keys, err := s.r.ListAPIKeys(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("list api keys: %w", err)
+	}
+
+	return keys, nil
+}
+
+type RevokeAPIKeyArgs struct {
+	UserID string
+	ID     int
+}
+
+func (s Service) RevokeAPIKey(ctx context.Context, args RevokeAPIKeyArgs) error {
+	revoked, err := s.r.RevokeAPIKeys(ctx, RevokeAPIKeysRepoArgs{
+		UserID: args.UserID,
+		ID:     &args.ID,
+	})
+	if err != nil {
+		return fmt.Errorf("revoke api key: %w", err)
+	}
+	if revoked == 0 {
+		return fmt.Errorf("revoke api key: %w", ErrAPIKeyNotFound)
+	}
+
+	return nil
+}
+
+// AuthenticateAPIKey returns the api key of an unrevoked key whose user is neither
+// banned nor deleted, and records that the key has been used
+func (s Service) AuthenticateAPIKey(ctx context.Context, key string) (APIKey, error) {
+	prefix, ok := parseAPIKeyPrefix(key)
+	if !ok {
+		return APIKey{}, fmt.Errorf("authenticate api key: %w", ErrInvalidAPIKey)
+	}
+
+	k, err := s.r.GetActiveAPIKeyByPrefix(ctx, prefix)
+	if err != nil {
+		return APIKey{}, fmt.Errorf("authenticate api key: %w", err)
+	}
+	if subtle.ConstantTimeCompare([]byte(k.KeyHash), []byte(hashToken(key))) != 1 {
+		return APIKey{}, fmt.Errorf("authenticate api key: %w", ErrInvalidAPIKey)
+	}
+
+	err = s.r.TouchAPIKey(ctx, k.ID)
+	if err != nil {
+		return APIKey{}, fmt.Errorf("authenticate api key: %w", err)
+	}
+
+	return k, nil
+}
+
+type LoginWithIdentityArgs struct {
+	Issuer        string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// LoginWithIdentity returns the user linked to the identity of an OpenID Connect provider.
+// An unknown identity is linked to the user with the same email, or to a new user, but only
+// when the provider has verified the email. When the existing user has not verified the email
+// either, the account could have been registered by someone who does not own the email, so
+// its password, sessions and api keys are dropped before it is linked.
+func (s Service) LoginWithIdentity(ctx context.Context, args LoginWithIdentityArgs) (User, error) {
+	var u User
+	err := s.trx.WithTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		u, err = s.r.GetOneByIdentity(ctx, args.Issuer, args.Subject)
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, ErrUserNotFound) {
+			return fmt.Errorf("get user by identity: %w", err)
+		}
+
+		if !args.EmailVerified || args.Email == "" {
+			return ErrIdentityNotVerified
+		}
+
+		u, err = s.r.GetOneByEmail(ctx, args.Email)
+		if err != nil && !errors.Is(err, ErrUserNotFound) {
+			return fmt.Errorf("get user by email: %w", err)
+		}
+		var userID string
+		switch {
+		case errors.Is(err, ErrUserNotFound):
+			userID, err = s.r.Create(ctx, CreateUserRepoArgs{
+				Email: args.Email,
+				// no password could match an empty hash, the user logs in with the provider
+				HashedPassword: "",
+				Name:           identityName(args.Name, args.Email),
+			})
+			if err != nil {
+				return fmt.Errorf("create user: %w", err)
+			}
+		case u.EmailVerifiedAt == nil:
+			userID = strconv.Itoa(u.ID)
+			err = s.r.Update(ctx, UpdateUserRepoArgs{
+				ID:             userID,
+				HashedPassword: pointer.Pointer(""),
+			})
+			if err != nil {
+				return fmt.Errorf("drop password: %w", err)
+			}
+			err = s.r.RevokeRefreshTokens(ctx, RevokeRefreshTokensRepoArgs{UserID: &userID})
+			if err != nil {
+				return fmt.Errorf("revoke sessions: %w", err)
+			}
+			_, err = s.r.RevokeAPIKeys(ctx, RevokeAPIKeysRepoArgs{UserID: userID})
+			if err != nil {
+				return fmt.Errorf("revoke api keys: %w", err)
+			}
+		default:
+			userID = strconv.Itoa(u.ID)
+		}
+
+		err = s.r.MarkEmailVerified(ctx, userID)
+		if err != nil {
+			return fmt.Errorf("mark email verified: %w", err)
+		}
+
+		err = s.r.CreateIdentity(ctx, CreateIdentityRepoArgs{
+			UserID:  userID,
+			Issuer:  args.Issuer,
+			Subject: args.Subject,
+			Email:   args.Email,
+		})
+		if err != nil {
+			return fmt.Errorf("create identity: %w", err)
+		}
+
+		u, err = s.r.GetOneByID(ctx, userID)
+		if err != nil {
+			return fmt.Errorf("get user: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return User{}, fmt.Errorf("login with identity: %w", err)
+	}
+	// only needed when the sessions of an unverified account have been revoked
+	s.sessions.forgetUser(strconv.Itoa(u.ID))
+
+	return u, nil
+}
+
+// ForgotPassword sends a password reset link to the user. Unknown emails are
+// ignored so that the caller could not tell which emails are registered.
+func (s Service) ForgotPassword(ctx context.Context, email string) error {
+	u, err := s.r.GetOneByEmail(ctx, email)
+	if errors.Is(err, ErrUserNotFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("forgot password: %w", err)
+	}
+
+	token, err := generateOpaqueToken()
+	if err != nil {
+		return fmt.Errorf("forgot password: %w", err)
+	}
+
+	err = s.r.CreateUserToken(ctx, CreateUserTokenRepoArgs{
+		UserID:    strconv.Itoa(u.ID),
+		Purpose:   TokenPurposePasswordReset,
+		TokenHash: hashToken(token),
+		ExpiresAt: time.Now().Add(resetTokenTTL),
+	})
+	if err != nil {
+		return fmt.Errorf("forgot password: %w", err)
+	}
+
+	link := s.appBaseURL + "/reset-password?token=" + url.QueryEscape(token)
+	err = s.mailer.Send(ctx, mailer.Message{
+		To:      u.Email,
+		Subject: "Reset your catsocial password",
+		Body: fmt.Sprintf("Hi %s,\n\nUse the link below to reset your password, it expires in %s.\n\n%s\n\n"+
+			"If you did not ask for a password reset you could ignore this email.\n", u.Name, resetTokenTTL, link),
+	})
+	if err != nil {
+		return fmt.Errorf("forgot password: %w", err)
+	}
+
+	return nil
+}
+
+type ResetPasswordArgs struct {
+	Token    string
+	Password string
+}
+
+// ResetPassword sets a new password with a reset token, every reset token of the user
+// is used up and every session of the user is revoked
+func (s Service) ResetPassword(ctx context.Context, args ResetPasswordArgs) error {
+	hashedPassword, err := s.hasher.Hash(args.Password)
+	if err != nil {
+		return fmt.Errorf("reset password: %w", err)
+	}
+
+	var userID string
+	err = s.trx.WithTransaction(ctx, func(ctx context.Context) error {
+		t, err := s.r.GetUserTokenByHash(ctx, GetUserTokenByHashRepoArgs{
+			TokenHash: hashToken(args.Token),
+			Purpose:   TokenPurposePasswordReset,
+			ForUpdate: true,
+		})
+		if err != nil {
+			return fmt.Errorf("get user token by hash: %w", err)
+		}
+		if t.UsedAt != nil || time.Now().After(t.ExpiresAt) {
+			return ErrInvalidUserToken
+		}
+
+		userID = strconv.Itoa(t.UserID)
+		err = s.r.Update(ctx, UpdateUserRepoArgs{
+			ID:             userID,
+			HashedPassword: pointer.Pointer(hashedPassword),
+		})
+		if err != nil {
+			return fmt.Errorf("update password: %w", err)
+		}
+
+		err = s.r.UseUserTokens(ctx, userID, TokenPurposePasswordReset)
+		if err != nil {
+			return fmt.Errorf("use reset tokens: %w", err)
+		}
+
+		err = s.r.RevokeRefreshTokens(ctx, RevokeRefreshTokensRepoArgs{UserID: &userID})
+		if err != nil {
+			return fmt.Errorf("revoke sessions: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("reset password: %w", err)
+	}
+	s.sessions.forgetUser(userID)
+
+	return nil
+}
+
+// SendVerificationEmail sends a link that verifies the current email of the user
+func (s Service) SendVerificationEmail(ctx context.Context, userID string) error {
+	u, err := s.r.GetOneByID(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("send verification email: %w", err)
+	}
+	if u.EmailVerifiedAt != nil {
+		return fmt.Errorf("send verification email: %w", ErrEmailAlreadyVerified)
+	}
+
+	token, err := generateOpaqueToken()
+	if err != nil {
+		return fmt.Errorf("send verification email: %w", err)
+	}
+
+	err = s.r.CreateUserToken(ctx, CreateUserTokenRepoArgs{
+		UserID:    userID,
+		Purpose:   TokenPurposeEmailVerification,
+		TokenHash: hashToken(token),
+		ExpiresAt: time.Now().Add(verifyTokenTTL),
+	})
+	if err != nil {
+		return fmt.Errorf("send verification email: %w", err)
+	}
+
+	link := s.appBaseURL + "/v1/user/email/verify?token=" + url.QueryEscape(token)
+	err = s.mailer.Send(ctx, mailer.Message{
+		To:      u.Email,
+		Subject: "Verify your catsocial email",
+		Body: fmt.Sprintf("Hi %s,\n\nUse the link below to verify your email, it expires in %s.\n\n%s\n",
+			u.Name, verifyTokenTTL, link),
+	})
+	if err != nil {
+		return fmt.Errorf("send verification email: %w", err)
+	}
+
+	return nil
+}
+
+// VerifyEmail marks the email of the user as verified with a verification token
+func (s Service) VerifyEmail(ctx context.Context, token string) error {
+	err := s.trx.WithTransaction(ctx, func(ctx context.Context) error {
+		t, err := s.r.GetUserTokenByHash(ctx, GetUserTokenByHashRepoArgs{
+			TokenHash: hashToken(token),
+			Purpose:   TokenPurposeEmailVerification,
+			ForUpdate: true,
+		})
+		if err != nil {
+			return fmt.Errorf("get user token by hash: %w", err)
+		}
+		if t.UsedAt != nil || time.Now().After(t.ExpiresAt) {
+			return ErrInvalidUserToken
+		}
+
+		userID := strconv.Itoa(t.UserID)
+		err = s.r.MarkEmailVerified(ctx, userID)
+		if err != nil {
+			return fmt.Errorf("mark email verified: %w", err)
+		}
+
+		err = s.r.UseUserTokens(ctx, userID, TokenPurposeEmailVerification)
+		if err != nil {
+			return fmt.Errorf("use verification tokens: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("verify email: %w", err)
+	}
+
+	return nil
+}
+
+func (s Service) IsEmailVerified(ctx context.Context, userID string) (bool, error) {
+	u, err := s.r.GetOneByID(ctx, userID)
+	if err != nil {
+		return false, fmt.Errorf("is email verified: %w", err)
+	}
+
+	return u.EmailVerifiedAt != nil, nil
+}
+
+// JWKS returns the public keys that verify the access tokens
+func (s Service) JWKS() jwt.JWKS {
+	return s.keyring.JWKS()
+}
+
+// issueTokens reads the user on every call, so a banned user could not get new tokens
+// and a new role is carried by the next access token
+func (s Service) issueTokens(ctx context.Context, userID string, sessionID string) (Tokens, error) {
+	u, err := s.r.GetOneByID(ctx, userID)
+	if err != nil {
+		return Tokens{}, fmt.Errorf("get user: %w", err)
+	}
+	if u.BannedAt != nil {
+		return Tokens{}, ErrUserBanned
+	}
+
+	refreshToken, err := generateOpaqueToken()
+	if err != nil {
+		return Tokens{}, err
+	}
+
+	err = s.r.CreateRefreshToken(ctx, CreateRefreshTokenRepoArgs{
+		UserID:    userID,
+		SessionID: sessionID,
+		TokenHash: hashToken(refreshToken),
+		ExpiresAt: time.Now().Add(refreshTokenTTL),
+	})
+	if err != nil {
+		return Tokens{}, fmt.Errorf("create refresh token: %w", err)
+	}
+
+	tokenID, err := jwt.NewID()
+	if err != nil {
+		return Tokens{}, err
+	}
+
+	now := time.Now()
+	accessToken, err := jwt.Sign(s.keyring, AccessClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    s.tokenOpts.Issuer,
+			Subject:   userID,
+			Audience:  jwt.Audience{s.tokenOpts.Audience},
+			ExpiresAt: now.Add(accessTokenTTL).Unix(),
+			NotBefore: now.Unix(),
+			IssuedAt:  now.Unix(),
+			ID:        tokenID,
+		},
+		UserID:    userID,
+		SessionID: sessionID,
+		Role:      u.Role,
+	})
+	if err != nil {
+		return Tokens{}, fmt.Errorf("generate access token: %w", err)
+	}
+
+	return Tokens{AccessToken: accessToken, RefreshToken: refreshToken}, nil
 }
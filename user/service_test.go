@@ -0,0 +1,104 @@
+package user
+
+import (
+	"catsocial/pkg/pointer"
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// plainHasher stores the passwords as they are
+type plainHasher struct{}
+
+func (plainHasher) Hash(password string) (string, error) { return password, nil }
+
+func (plainHasher) Compare(hash, password string) error {
+	if hash != password {
+		return ErrInvalidPassword
+	}
+	return nil
+}
+
+func (plainHasher) Supports(hash string) bool { return true }
+
+func (plainHasher) NeedsRehash(hash string) bool { return false }
+
+// throttleRepo keeps one user and the failed logins in memory
+type throttleRepo struct {
+	repo
+	user        User
+	failures    map[string]int
+	lockedUntil map[string]time.Time
+	updates     int
+}
+
+func (r *throttleRepo) GetOneByID(ctx context.Context, id string) (User, error) {
+	return r.user, nil
+}
+
+func (r *throttleRepo) Update(ctx context.Context, args UpdateUserRepoArgs) error {
+	r.updates++
+	return nil
+}
+
+func (r *throttleRepo) RecordFailedLogin(ctx context.Context, key string, window time.Duration) (int, error) {
+	r.failures[key]++
+	return r.failures[key], nil
+}
+
+func (r *throttleRepo) LockLogin(ctx context.Context, key string, until time.Time) error {
+	r.lockedUntil[key] = until
+	return nil
+}
+
+func (r *throttleRepo) GetLoginLockedUntil(ctx context.Context, keys []string) (*time.Time, error) {
+	for _, key := range keys {
+		if until, ok := r.lockedUntil[key]; ok && until.After(time.Now()) {
+			return &until, nil
+		}
+	}
+	return nil, nil
+}
+
+func (r *throttleRepo) ResetLoginAttempts(ctx context.Context, key string) error {
+	delete(r.failures, key)
+	return nil
+}
+
+func TestUpdateProfilePasswordThrottled(t *testing.T) {
+	r := &throttleRepo{
+		user:        User{ID: 1, Email: "Tom@example.com", HashedPassword: "current"},
+		failures:    make(map[string]int),
+		lockedUntil: make(map[string]time.Time),
+	}
+	s := Service{r: r, trx: inlineTrx{}, hasher: plainHasher{}}
+	changePassword := func(current string) error {
+		_, err := s.UpdateProfile(context.Background(), UpdateProfileArgs{
+			UserID:          "1",
+			Password:        pointer.Pointer("new-password"),
+			CurrentPassword: current,
+		})
+		return err
+	}
+
+	for i := 0; i < maxFailedLoginsPerAccount; i++ {
+		err := changePassword("guess")
+		if !errors.Is(err, ErrInvalidPassword) {
+			t.Fatalf("attempt %d error = %v, want %v", i+1, err, ErrInvalidPassword)
+		}
+	}
+	if got := r.failures[accountLoginKey("tom@example.com")]; got != maxFailedLoginsPerAccount {
+		t.Fatalf("failed logins of the account = %d, want %d", got, maxFailedLoginsPerAccount)
+	}
+
+	// the lock is shared with the login, so the right password has to wait as well
+	var lockedErr LoginLockedError
+	err := changePassword("current")
+	if !errors.As(err, &lockedErr) {
+		t.Fatalf("error once locked = %v, want LoginLockedError", err)
+	}
+	if r.updates != 0 {
+		t.Fatalf("updates = %d, want the password left unchanged", r.updates)
+	}
+}
@@ -0,0 +1,108 @@
+package user
+
+import (
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// sessionCacheTTL bounds how long a session revoked by another instance, e.g. by
+	// a ban, could still be used with its access tokens. The cache is per instance and
+	// only evicted locally, so only reads of the unprivileged roles go through it,
+	// see ParseAccessToken and AuthMiddleware.
+	sessionCacheTTL = 15 * time.Second
+	// maxSessionCacheEntries keeps the cache from growing with every session ever seen
+	maxSessionCacheEntries = 10000
+	// maxUserAgentLength keeps clients from storing arbitrary long headers
+	maxUserAgentLength = 512
+)
+
+type sessionCacheEntry struct {
+	userID    string
+	expiresAt time.Time
+}
+
+// sessionCache remembers the sessions that have recently been found active, so
+// authenticating a request does not query the database every time. Only active
+// sessions are cached and revocations made through the service evict them.
+type sessionCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]sessionCacheEntry
+}
+
+func newSessionCache(ttl time.Duration) *sessionCache {
+	return &sessionCache{
+		ttl:     ttl,
+		entries: make(map[string]sessionCacheEntry),
+	}
+}
+
+func (c *sessionCache) isActive(sessionID string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[sessionID]
+	if !ok {
+		return false
+	}
+	if time.Now().After(e.expiresAt) {
+		delete(c.entries, sessionID)
+		return false
+	}
+
+	return true
+}
+
+func (c *sessionCache) markActive(sessionID string, userID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if len(c.entries) >= maxSessionCacheEntries {
+		for id, e := range c.entries {
+			if now.After(e.expiresAt) {
+				delete(c.entries, id)
+			}
+		}
+	}
+	if len(c.entries) >= maxSessionCacheEntries {
+		c.entries = make(map[string]sessionCacheEntry)
+	}
+
+	c.entries[sessionID] = sessionCacheEntry{userID: userID, expiresAt: now.Add(c.ttl)}
+}
+
+func (c *sessionCache) forget(sessionID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.entries, sessionID)
+}
+
+func (c *sessionCache) forgetUser(userID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for id, e := range c.entries {
+		if e.userID == userID {
+			delete(c.entries, id)
+		}
+	}
+}
+
+// truncateUserAgent cuts the user agent to maxUserAgentLength bytes without
+// splitting a multi byte character
+func truncateUserAgent(userAgent string) string {
+	if len(userAgent) <= maxUserAgentLength {
+		return userAgent
+	}
+
+	cut := maxUserAgentLength
+	for cut > 0 && !utf8.RuneStart(userAgent[cut]) {
+		cut--
+	}
+
+	return userAgent[:cut]
+}
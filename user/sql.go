@@ -1,23 +1,25 @@
 package user
 
 import (
+	"catsocial/pkg/pgxtrx"
 	"context"
 	"errors"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
-	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 type (
 	SQL struct {
-		pool *pgxpool.Pool
+		pgxTrx pgxtrx.PgxTrx
 	}
 )
 
-func NewSQL(pool *pgxpool.Pool) SQL {
-	return SQL{pool}
+func NewSQL(pgxTrx pgxtrx.PgxTrx) SQL {
+	return SQL{pgxTrx}
 }
 
 type CreateUserRepoArgs struct {
@@ -27,8 +29,10 @@ type CreateUserRepoArgs struct {
 }
 
 func (s SQL) Create(ctx context.Context, args CreateUserRepoArgs) (string, error) {
+	db := s.pgxTrx.FromContext(ctx)
+
 	var id string
-	err := s.pool.QueryRow(ctx, `
+	err := db.QueryRow(ctx, `
 		insert into users(name, hashed_pw, email)
 		values ($1, $2, $3)
 		returning id
@@ -46,12 +50,19 @@ func (s SQL) Create(ctx context.Context, args CreateUserRepoArgs) (string, error
 }
 
 func (s SQL) GetOneByEmail(ctx context.Context, email string) (User, error) {
+	db := s.pgxTrx.FromContext(ctx)
+
 	var u User
-	err := s.pool.QueryRow(ctx, `
-		select id, email, hashed_pw, name, created_at
+	err := db.QueryRow(ctx, `
+		select
+			id, email, hashed_pw, name, role, email_verified_at,
+			banned_at, deleted_at, totp_secret, totp_enabled_at,
+			totp_last_counter, created_at
 		from users
 		where email = $1
-	`, email).Scan(&u.ID, &u.Email, &u.HashedPassword, &u.Name, &u.CreatedAt)
+	`, email).Scan(&u.ID, &u.Email, &u.HashedPassword, &u.Name, &u.Role, &u.EmailVerifiedAt,
+		&u.BannedAt, &u.DeletedAt, &u.TOTPSecret, &u.TOTPEnabledAt,
+		&u.TOTPLastCounter, &u.CreatedAt)
 	if err != nil {
 		e := err
 		if err == pgx.ErrNoRows {
@@ -62,3 +73,941 @@ func (s SQL) GetOneByEmail(ctx context.Context, email string) (User, error) {
 
 	return u, nil
 }
+
+func (s SQL) GetOneByID(ctx context.Context, id string) (User, error) {
+	db := s.pgxTrx.FromContext(ctx)
+
+	var u User
+	err := db.QueryRow(ctx, `
+		select
+			id, email, hashed_pw, name, role, email_verified_at,
+			banned_at, deleted_at, totp_secret, totp_enabled_at,
+			totp_last_counter, created_at
+		from users
+		where id = $1
+	`, id).Scan(&u.ID, &u.Email, &u.HashedPassword, &u.Name, &u.Role, &u.EmailVerifiedAt,
+		&u.BannedAt, &u.DeletedAt, &u.TOTPSecret, &u.TOTPEnabledAt,
+		&u.TOTPLastCounter, &u.CreatedAt)
+	if err != nil {
+		e := err
+		if err == pgx.ErrNoRows {
+			e = ErrUserNotFound
+		}
+		return u, fmt.Errorf("sql finding user by id: %w", e)
+	}
+
+	return u, nil
+}
+
+type ListUsersRepoArgs struct {
+	Limit  int
+	Offset int
+}
+
+func (s SQL) List(ctx context.Context, args ListUsersRepoArgs) ([]User, error) {
+	db := s.pgxTrx.FromContext(ctx)
+
+	rows, err := db.Query(ctx, `
+		select
+			id, email, name, role, email_verified_at,
+			banned_at, deleted_at, created_at
+		from users
+		order by id desc
+		limit $1
+		offset $2
+	`, args.Limit, args.Offset)
+	if err != nil {
+		return nil, fmt.Errorf("sql list users: %w", err)
+	}
+	defer rows.Close()
+
+	users := make([]User, 0)
+	for rows.Next() {
+		var u User
+		err = rows.Scan(&u.ID, &u.Email, &u.Name, &u.Role, &u.EmailVerifiedAt,
+			&u.BannedAt, &u.DeletedAt, &u.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("sql list users: %w", err)
+		}
+
+		users = append(users, u)
+	}
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("sql list users: %w", rows.Err())
+	}
+
+	return users, nil
+}
+
+// SetBanned bans the user when banned is true, otherwise lifts the ban
+func (s SQL) SetBanned(ctx context.Context, userID string, banned bool) error {
+	db := s.pgxTrx.FromContext(ctx)
+
+	bannedAt := "null"
+	if banned {
+		bannedAt = "coalesce(banned_at, now())"
+	}
+
+	tag, err := db.Exec(ctx, fmt.Sprintf(`
+		update users
+		set banned_at = %s
+		where id = $1
+	`, bannedAt), userID)
+	if err != nil {
+		return fmt.Errorf("sql set user banned: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("sql set user banned: %w", ErrUserNotFound)
+	}
+
+	return nil
+}
+
+type CreateRefreshTokenRepoArgs struct {
+	UserID    string
+	SessionID string
+	TokenHash string
+	ExpiresAt time.Time
+}
+
+func (s SQL) CreateRefreshToken(ctx context.Context, args CreateRefreshTokenRepoArgs) error {
+	db := s.pgxTrx.FromContext(ctx)
+
+	_, err := db.Exec(ctx, `
+		insert into refresh_tokens(user_id, session_id, token_hash, expires_at)
+		values ($1, $2, $3, $4)
+	`, args.UserID, args.SessionID, args.TokenHash, args.ExpiresAt)
+	if err != nil {
+		return fmt.Errorf("sql create refresh token: %w", err)
+	}
+
+	return nil
+}
+
+type GetRefreshTokenByHashRepoArgs struct {
+	TokenHash string
+	ForUpdate bool
+}
+
+func (s SQL) GetRefreshTokenByHash(ctx context.Context, args GetRefreshTokenByHashRepoArgs) (RefreshToken, error) {
+	db := s.pgxTrx.FromContext(ctx)
+
+	forUpdate := ""
+	if args.ForUpdate {
+		forUpdate = "for update"
+	}
+
+	var t RefreshToken
+	err := db.QueryRow(ctx, fmt.Sprintf(`
+		select
+			id, user_id, session_id, token_hash, expires_at,
+			rotated_at, revoked_at, created_at
+		from refresh_tokens
+		where token_hash = $1 %s
+	`, forUpdate), args.TokenHash).Scan(&t.ID, &t.UserID, &t.SessionID, &t.TokenHash, &t.ExpiresAt,
+		&t.RotatedAt, &t.RevokedAt, &t.CreatedAt)
+	if err != nil {
+		e := err
+		if err == pgx.ErrNoRows {
+			e = ErrInvalidRefreshToken
+		}
+		return t, fmt.Errorf("sql finding refresh token by hash: %w", e)
+	}
+
+	return t, nil
+}
+
+func (s SQL) RotateRefreshToken(ctx context.Context, id int) error {
+	db := s.pgxTrx.FromContext(ctx)
+
+	_, err := db.Exec(ctx, `
+		update refresh_tokens
+		set rotated_at = now()
+		where id = $1
+	`, id)
+	if err != nil {
+		return fmt.Errorf("sql rotate refresh token: %w", err)
+	}
+
+	return nil
+}
+
+type RevokeRefreshTokensRepoArgs struct {
+	SessionID        *string
+	UserID           *string
+	ExcludeSessionID *string
+}
+
+func (s SQL) RevokeRefreshTokens(ctx context.Context, args RevokeRefreshTokensRepoArgs) error {
+	var (
+		query        strings.Builder
+		whereQueries []string
+		sqlArgs      []any
+
+		arg = 1
+	)
+	query.WriteString(`
+		update refresh_tokens
+		set revoked_at = now()
+	`)
+
+	whereQueries = append(whereQueries, "revoked_at is null")
+
+	if args.SessionID != nil {
+		whereQueries = append(whereQueries, fmt.Sprintf("session_id = $%d", arg))
+		sqlArgs = append(sqlArgs, *args.SessionID)
+		arg += 1
+	}
+
+	if args.UserID != nil {
+		whereQueries = append(whereQueries, fmt.Sprintf("user_id = $%d", arg))
+		sqlArgs = append(sqlArgs, *args.UserID)
+		arg += 1
+	}
+
+	if args.ExcludeSessionID != nil {
+		whereQueries = append(whereQueries, fmt.Sprintf("session_id != $%d", arg))
+		sqlArgs = append(sqlArgs, *args.ExcludeSessionID)
+		arg += 1
+	}
+
+	query.WriteString(fmt.Sprintf(`
+		where %s
+	`, strings.Join(whereQueries, " and ")))
+
+	db := s.pgxTrx.FromContext(ctx)
+	_, err := db.Exec(ctx, query.String(), sqlArgs...)
+	if err != nil {
+		return fmt.Errorf("sql revoke refresh tokens: %w", err)
+	}
+
+	return nil
+}
+
+// IsSessionActive reports whether the session still has a refresh token that
+// is neither revoked nor expired
+func (s SQL) IsSessionActive(ctx context.Context, sessionID string) (bool, error) {
+	db := s.pgxTrx.FromContext(ctx)
+
+	var active bool
+	err := db.QueryRow(ctx, `
+		select exists (
+			select 1
+			from refresh_tokens
+			where session_id = $1
+			and revoked_at is null
+			and expires_at > now()
+		)
+	`, sessionID).Scan(&active)
+	if err != nil {
+		return false, fmt.Errorf("sql finding active session: %w", err)
+	}
+
+	return active, nil
+}
+
+type CreateSessionRepoArgs struct {
+	ID        string
+	UserID    string
+	UserAgent string
+	IP        string
+}
+
+func (s SQL) CreateSession(ctx context.Context, args CreateSessionRepoArgs) error {
+	db := s.pgxTrx.FromContext(ctx)
+
+	_, err := db.Exec(ctx, `
+		insert into sessions(id, user_id, user_agent, ip)
+		values ($1, $2, $3, $4)
+	`, args.ID, args.UserID, args.UserAgent, args.IP)
+	if err != nil {
+		return fmt.Errorf("sql create session: %w", err)
+	}
+
+	return nil
+}
+
+// ListActiveSessions returns the sessions of the user that are still active,
+// the most recently seen first
+func (s SQL) ListActiveSessions(ctx context.Context, userID string) ([]Session, error) {
+	db := s.pgxTrx.FromContext(ctx)
+
+	rows, err := db.Query(ctx, `
+		select id, user_id, user_agent, ip, created_at, last_seen_at
+		from sessions s
+		where user_id = $1
+		and exists (
+			select 1
+			from refresh_tokens
+			where session_id = s.id
+			and revoked_at is null
+			and expires_at > now()
+		)
+		order by last_seen_at desc, id
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("sql list active sessions: %w", err)
+	}
+	defer rows.Close()
+
+	sessions := make([]Session, 0)
+	for rows.Next() {
+		var ses Session
+		err = rows.Scan(&ses.ID, &ses.UserID, &ses.UserAgent, &ses.IP, &ses.CreatedAt, &ses.LastSeenAt)
+		if err != nil {
+			return nil, fmt.Errorf("sql list active sessions: %w", err)
+		}
+
+		sessions = append(sessions, ses)
+	}
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("sql list active sessions: %w", rows.Err())
+	}
+
+	return sessions, nil
+}
+
+// TouchSession sets the last seen time of the session
+func (s SQL) TouchSession(ctx context.Context, sessionID string) error {
+	db := s.pgxTrx.FromContext(ctx)
+
+	_, err := db.Exec(ctx, `
+		update sessions
+		set last_seen_at = now()
+		where id = $1
+	`, sessionID)
+	if err != nil {
+		return fmt.Errorf("sql touch session: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteSessions drops the user agents and ips kept for the user
+func (s SQL) DeleteSessions(ctx context.Context, userID string) error {
+	db := s.pgxTrx.FromContext(ctx)
+
+	_, err := db.Exec(ctx, `
+		delete from sessions
+		where user_id = $1
+	`, userID)
+	if err != nil {
+		return fmt.Errorf("sql delete sessions: %w", err)
+	}
+
+	return nil
+}
+
+type CreateUserTokenRepoArgs struct {
+	UserID    string
+	Purpose   TokenPurpose
+	TokenHash string
+	ExpiresAt time.Time
+}
+
+func (s SQL) CreateUserToken(ctx context.Context, args CreateUserTokenRepoArgs) error {
+	db := s.pgxTrx.FromContext(ctx)
+
+	_, err := db.Exec(ctx, `
+		insert into user_tokens(user_id, purpose, token_hash, expires_at)
+		values ($1, $2, $3, $4)
+	`, args.UserID, string(args.Purpose), args.TokenHash, args.ExpiresAt)
+	if err != nil {
+		return fmt.Errorf("sql create user token: %w", err)
+	}
+
+	return nil
+}
+
+type GetUserTokenByHashRepoArgs struct {
+	TokenHash string
+	Purpose   TokenPurpose
+	ForUpdate bool
+}
+
+func (s SQL) GetUserTokenByHash(ctx context.Context, args GetUserTokenByHashRepoArgs) (UserToken, error) {
+	db := s.pgxTrx.FromContext(ctx)
+
+	forUpdate := ""
+	if args.ForUpdate {
+		forUpdate = "for update"
+	}
+
+	var t UserToken
+	err := db.QueryRow(ctx, fmt.Sprintf(`
+		select id, user_id, purpose, token_hash, expires_at, used_at, created_at
+		from user_tokens
+		where token_hash = $1
+		and purpose = $2 %s
+	`, forUpdate), args.TokenHash, string(args.Purpose)).Scan(&t.ID, &t.UserID, &t.Purpose, &t.TokenHash,
+		&t.ExpiresAt, &t.UsedAt, &t.CreatedAt)
+	if err != nil {
+		e := err
+		if err == pgx.ErrNoRows {
+			e = ErrInvalidUserToken
+		}
+		return t, fmt.Errorf("sql finding user token by hash: %w", e)
+	}
+
+	return t, nil
+}
+
+// UseUserTokens marks every unused token of the user with the given purpose as used
+func (s SQL) UseUserTokens(ctx context.Context, userID string, purpose TokenPurpose) error {
+	db := s.pgxTrx.FromContext(ctx)
+
+	_, err := db.Exec(ctx, `
+		update user_tokens
+		set used_at = now()
+		where user_id = $1
+		and purpose = $2
+		and used_at is null
+	`, userID, string(purpose))
+	if err != nil {
+		return fmt.Errorf("sql use user tokens: %w", err)
+	}
+
+	return nil
+}
+
+type UpdateUserRepoArgs struct {
+	ID             string
+	Name           *string
+	Email          *string
+	HashedPassword *string
+	// UnverifyEmail clears email_verified_at, e.g. when the email has changed
+	UnverifyEmail bool
+}
+
+func (s SQL) Update(ctx context.Context, args UpdateUserRepoArgs) error {
+	var (
+		query         strings.Builder
+		sqlArgs       []any
+		updateQueries []string
+
+		arg = 1
+	)
+	query.WriteString("update users")
+
+	if args.Name != nil {
+		updateQueries = append(updateQueries, fmt.Sprintf(`
+			name = $%d
+		`, arg))
+		sqlArgs = append(sqlArgs, *args.Name)
+		arg += 1
+	}
+
+	if args.Email != nil {
+		updateQueries = append(updateQueries, fmt.Sprintf(`
+			email = $%d
+		`, arg))
+		sqlArgs = append(sqlArgs, *args.Email)
+		arg += 1
+	}
+
+	if args.HashedPassword != nil {
+		updateQueries = append(updateQueries, fmt.Sprintf(`
+			hashed_pw = $%d
+		`, arg))
+		sqlArgs = append(sqlArgs, *args.HashedPassword)
+		arg += 1
+	}
+
+	if args.UnverifyEmail {
+		updateQueries = append(updateQueries, `
+			email_verified_at = null
+		`)
+	}
+
+	if len(updateQueries) == 0 {
+		return nil
+	}
+
+	query.WriteString(fmt.Sprintf(`
+		set %s
+		where id = $%d
+	`, strings.Join(updateQueries, ", "), arg))
+	sqlArgs = append(sqlArgs, args.ID)
+
+	db := s.pgxTrx.FromContext(ctx)
+	_, err := db.Exec(ctx, query.String(), sqlArgs...)
+
+	var e *pgconn.PgError
+	if errors.As(err, &e) && e.Code == "23505" { // unique constraint violation
+		return fmt.Errorf("sql update user: %w", ErrUniqueEmailViolation)
+	}
+	if err != nil {
+		return fmt.Errorf("sql update user: %w", err)
+	}
+
+	return nil
+}
+
+func (s SQL) MarkEmailVerified(ctx context.Context, userID string) error {
+	db := s.pgxTrx.FromContext(ctx)
+
+	_, err := db.Exec(ctx, `
+		update users
+		set email_verified_at = now()
+		where id = $1
+	`, userID)
+	if err != nil {
+		return fmt.Errorf("sql mark user email verified: %w", err)
+	}
+
+	return nil
+}
+
+// Anonymize removes the personal data of the user while keeping the row,
+// so the data that references the user stays consistent
+func (s SQL) Anonymize(ctx context.Context, userID string) error {
+	db := s.pgxTrx.FromContext(ctx)
+
+	_, err := db.Exec(ctx, `
+		update users
+		set
+			email = 'deleted-' || id || '@deleted.invalid',
+			name = 'Deleted user',
+			hashed_pw = '',
+			email_verified_at = null,
+			deleted_at = now()
+		where id = $1
+	`, userID)
+	if err != nil {
+		return fmt.Errorf("sql anonymize user: %w", err)
+	}
+
+	return nil
+}
+
+// RecordFailedLogin counts a failed login for the key and returns the number of
+// failures within the window, older failures are forgotten
+func (s SQL) RecordFailedLogin(ctx context.Context, key string, window time.Duration) (int, error) {
+	db := s.pgxTrx.FromContext(ctx)
+
+	var count int
+	err := db.QueryRow(ctx, `
+		insert into login_attempts(key, failed_count, last_failed_at)
+		values ($1, 1, now())
+		on conflict (key) do update
+		set
+			failed_count = case
+				when login_attempts.last_failed_at < now() - make_interval(secs => $2) then 1
+				else login_attempts.failed_count + 1
+			end,
+			last_failed_at = now()
+		returning failed_count
+	`, key, window.Seconds()).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("sql record failed login: %w", err)
+	}
+
+	return count, nil
+}
+
+func (s SQL) LockLogin(ctx context.Context, key string, until time.Time) error {
+	db := s.pgxTrx.FromContext(ctx)
+
+	_, err := db.Exec(ctx, `
+		update login_attempts
+		set locked_until = $2
+		where key = $1
+	`, key, until)
+	if err != nil {
+		return fmt.Errorf("sql lock login: %w", err)
+	}
+
+	return nil
+}
+
+// GetLoginLockedUntil returns the latest time the keys are locked until, or nil
+// when none of them is locked
+func (s SQL) GetLoginLockedUntil(ctx context.Context, keys []string) (*time.Time, error) {
+	db := s.pgxTrx.FromContext(ctx)
+
+	var lockedUntil *time.Time
+	err := db.QueryRow(ctx, `
+		select max(locked_until)
+		from login_attempts
+		where key = any($1)
+		and locked_until > now()
+	`, keys).Scan(&lockedUntil)
+	if err != nil {
+		return nil, fmt.Errorf("sql finding login lock: %w", err)
+	}
+
+	return lockedUntil, nil
+}
+
+func (s SQL) ResetLoginAttempts(ctx context.Context, key string) error {
+	db := s.pgxTrx.FromContext(ctx)
+
+	_, err := db.Exec(ctx, `
+		delete from login_attempts
+		where key = $1
+	`, key)
+	if err != nil {
+		return fmt.Errorf("sql reset login attempts: %w", err)
+	}
+
+	return nil
+}
+
+// SetTOTPSecret stores the secret of a new enrollment, it replaces the secret of
+// an unconfirmed enrollment but never the one of an enabled second factor
+func (s SQL) SetTOTPSecret(ctx context.Context, userID string, secret string) error {
+	db := s.pgxTrx.FromContext(ctx)
+
+	tag, err := db.Exec(ctx, `
+		update users
+		set
+			totp_secret = $2,
+			totp_last_counter = null
+		where id = $1
+		and totp_enabled_at is null
+	`, userID, secret)
+	if err != nil {
+		return fmt.Errorf("sql set totp secret: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("sql set totp secret: %w", ErrTOTPAlreadyEnabled)
+	}
+
+	return nil
+}
+
+func (s SQL) EnableTOTP(ctx context.Context, userID string, counter int64) error {
+	db := s.pgxTrx.FromContext(ctx)
+
+	_, err := db.Exec(ctx, `
+		update users
+		set
+			totp_enabled_at = now(),
+			totp_last_counter = $2
+		where id = $1
+	`, userID, counter)
+	if err != nil {
+		return fmt.Errorf("sql enable totp: %w", err)
+	}
+
+	return nil
+}
+
+// UseTOTPCounter stores the counter of an accepted code, it reports false when the
+// counter is not greater than the last one, i.e. the code has been replayed
+func (s SQL) UseTOTPCounter(ctx context.Context, userID string, counter int64) (bool, error) {
+	db := s.pgxTrx.FromContext(ctx)
+
+	tag, err := db.Exec(ctx, `
+		update users
+		set totp_last_counter = $2
+		where id = $1
+		and (totp_last_counter is null or totp_last_counter < $2)
+	`, userID, counter)
+	if err != nil {
+		return false, fmt.Errorf("sql use totp counter: %w", err)
+	}
+
+	return tag.RowsAffected() > 0, nil
+}
+
+// DisableTOTP removes the second factor of the user together with its recovery codes
+func (s SQL) DisableTOTP(ctx context.Context, userID string) error {
+	db := s.pgxTrx.FromContext(ctx)
+
+	_, err := db.Exec(ctx, `
+		update users
+		set
+			totp_secret = null,
+			totp_enabled_at = null,
+			totp_last_counter = null
+		where id = $1
+	`, userID)
+	if err != nil {
+		return fmt.Errorf("sql disable totp: %w", err)
+	}
+
+	_, err = db.Exec(ctx, `
+		delete from user_recovery_codes
+		where user_id = $1
+	`, userID)
+	if err != nil {
+		return fmt.Errorf("sql disable totp: delete recovery codes: %w", err)
+	}
+
+	return nil
+}
+
+// ReplaceRecoveryCodes deletes the recovery codes of the user and stores the new ones
+func (s SQL) ReplaceRecoveryCodes(ctx context.Context, userID string, codeHashes []string) error {
+	db := s.pgxTrx.FromContext(ctx)
+
+	_, err := db.Exec(ctx, `
+		delete from user_recovery_codes
+		where user_id = $1
+	`, userID)
+	if err != nil {
+		return fmt.Errorf("sql replace recovery codes: %w", err)
+	}
+
+	_, err = db.Exec(ctx, `
+		insert into user_recovery_codes(user_id, code_hash)
+		select $1, unnest($2::text[])
+	`, userID, codeHashes)
+	if err != nil {
+		return fmt.Errorf("sql replace recovery codes: %w", err)
+	}
+
+	return nil
+}
+
+// UseRecoveryCode marks the recovery code as used, it reports false when the code
+// does not exist or has already been used
+func (s SQL) UseRecoveryCode(ctx context.Context, userID string, codeHash string) (bool, error) {
+	db := s.pgxTrx.FromContext(ctx)
+
+	tag, err := db.Exec(ctx, `
+		update user_recovery_codes
+		set used_at = now()
+		where user_id = $1
+		and code_hash = $2
+		and used_at is null
+	`, userID, codeHash)
+	if err != nil {
+		return false, fmt.Errorf("sql use recovery code: %w", err)
+	}
+
+	return tag.RowsAffected() > 0, nil
+}
+
+type CreateAPIKeyRepoArgs struct {
+	UserID  string
+	Name    string
+	Prefix  string
+	KeyHash string
+	Scopes  []Scope
+}
+
+func (s SQL) CreateAPIKey(ctx context.Context, args CreateAPIKeyRepoArgs) (APIKey, error) {
+	db := s.pgxTrx.FromContext(ctx)
+
+	scopes := make([]string, 0, len(args.Scopes))
+	for _, scope := range args.Scopes {
+		scopes = append(scopes, string(scope))
+	}
+
+	k := APIKey{Name: args.Name, Prefix: args.Prefix, KeyHash: args.KeyHash, Scopes: args.Scopes}
+	err := db.QueryRow(ctx, `
+		insert into api_keys(user_id, name, prefix, key_hash, scopes)
+		values ($1, $2, $3, $4, $5)
+		returning id, user_id, created_at
+	`, args.UserID, args.Name, args.Prefix, args.KeyHash, scopes).Scan(&k.ID, &k.UserID, &k.CreatedAt)
+	if err != nil {
+		return k, fmt.Errorf("sql create api key: %w", err)
+	}
+
+	return k, nil
+}
+
+// GetActiveAPIKeyByPrefix returns the unrevoked api key of the prefix, as long as its
+// user is neither banned nor deleted
+func (s SQL) GetActiveAPIKeyByPrefix(ctx context.Context, prefix string) (APIKey, error) {
+	db := s.pgxTrx.FromContext(ctx)
+
+	var (
+		k      APIKey
+		scopes []string
+	)
+	err := db.QueryRow(ctx, `
+		select
+			k.id, k.user_id, k.name, k.prefix, k.key_hash, k.scopes,
+			k.last_used_at, k.revoked_at, k.created_at
+		from api_keys k
+		join users u on u.id = k.user_id
+		where k.prefix = $1
+		and k.revoked_at is null
+		and u.banned_at is null
+		and u.deleted_at is null
+	`, prefix).Scan(&k.ID, &k.UserID, &k.Name, &k.Prefix, &k.KeyHash, &scopes,
+		&k.LastUsedAt, &k.RevokedAt, &k.CreatedAt)
+	if err != nil {
+		e := err
+		if err == pgx.ErrNoRows {
+			e = ErrInvalidAPIKey
+		}
+		return k, fmt.Errorf("sql finding api key by prefix: %w", e)
+	}
+	for _, scope := range scopes {
+		k.Scopes = append(k.Scopes, Scope(scope))
+	}
+
+	return k, nil
+}
+
+func (s SQL) ListAPIKeys(ctx context.Context, userID string) ([]APIKey, error) {
+	db := s.pgxTrx.FromContext(ctx)
+
+	rows, err := db.Query(ctx, `
+		select
+			id, user_id, name, prefix, scopes,
+			last_used_at, revoked_at, created_at
+		from api_keys
+		where user_id = $1
+		and revoked_at is null
+		order by id desc
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("sql list api keys: %w", err)
+	}
+	defer rows.Close()
+
+	keys := make([]APIKey, 0)
+	for rows.Next() {
+		var (
+			k      APIKey
+			scopes []string
+		)
+		err = rows.Scan(&k.ID, &k.UserID, &k.Name, &k.Prefix, &scopes,
+			&k.LastUsedAt, &k.RevokedAt, &k.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("sql list api keys: %w", err)
+		}
+		for _, scope := range scopes {
+			k.Scopes = append(k.Scopes, Scope(scope))
+		}
+
+		keys = append(keys, k)
+	}
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("sql list api keys: %w", rows.Err())
+	}
+
+	return keys, nil
+}
+
+type RevokeAPIKeysRepoArgs struct {
+	UserID string
+	ID     *int
+}
+
+// RevokeAPIKeys revokes the given api key of the user, or every api key of the user
+// when no id is given
+func (s SQL) RevokeAPIKeys(ctx context.Context, args RevokeAPIKeysRepoArgs) (int64, error) {
+	var (
+		query        strings.Builder
+		whereQueries []string
+		sqlArgs      []any
+
+		arg = 1
+	)
+	query.WriteString(`
+		update api_keys
+		set revoked_at = now()
+	`)
+
+	whereQueries = append(whereQueries, "revoked_at is null")
+
+	whereQueries = append(whereQueries, fmt.Sprintf("user_id = $%d", arg))
+	sqlArgs = append(sqlArgs, args.UserID)
+	arg += 1
+
+	if args.ID != nil {
+		whereQueries = append(whereQueries, fmt.Sprintf("id = $%d", arg))
+		sqlArgs = append(sqlArgs, *args.ID)
+		arg += 1
+	}
+
+	query.WriteString(fmt.Sprintf(`
+		where %s
+	`, strings.Join(whereQueries, " and ")))
+
+	db := s.pgxTrx.FromContext(ctx)
+	tag, err := db.Exec(ctx, query.String(), sqlArgs...)
+	if err != nil {
+		return 0, fmt.Errorf("sql revoke api keys: %w", err)
+	}
+
+	return tag.RowsAffected(), nil
+}
+
+// TouchAPIKey records the use of the api key, at most once a minute
+// so that every request does not write
+func (s SQL) TouchAPIKey(ctx context.Context, id int) error {
+	db := s.pgxTrx.FromContext(ctx)
+
+	_, err := db.Exec(ctx, `
+		update api_keys
+		set last_used_at = now()
+		where id = $1
+		and (last_used_at is null or last_used_at < now() - interval '1 minute')
+	`, id)
+	if err != nil {
+		return fmt.Errorf("sql touch api key: %w", err)
+	}
+
+	return nil
+}
+
+// GetOneByIdentity returns the user linked to the subject of the identity provider
+func (s SQL) GetOneByIdentity(ctx context.Context, issuer string, subject string) (User, error) {
+	db := s.pgxTrx.FromContext(ctx)
+
+	var u User
+	err := db.QueryRow(ctx, `
+		select
+			u.id, u.email, u.hashed_pw, u.name, u.role, u.email_verified_at,
+			u.banned_at, u.deleted_at, u.totp_secret, u.totp_enabled_at,
+			u.totp_last_counter, u.created_at
+		from user_identities i
+		join users u on u.id = i.user_id
+		where i.issuer = $1
+		and i.subject = $2
+		and u.deleted_at is null
+	`, issuer, subject).Scan(&u.ID, &u.Email, &u.HashedPassword, &u.Name, &u.Role, &u.EmailVerifiedAt,
+		&u.BannedAt, &u.DeletedAt, &u.TOTPSecret, &u.TOTPEnabledAt,
+		&u.TOTPLastCounter, &u.CreatedAt)
+	if err != nil {
+		e := err
+		if err == pgx.ErrNoRows {
+			e = ErrUserNotFound
+		}
+		return u, fmt.Errorf("sql finding user by identity: %w", e)
+	}
+
+	return u, nil
+}
+
+type CreateIdentityRepoArgs struct {
+	UserID  string
+	Issuer  string
+	Subject string
+	Email   string
+}
+
+func (s SQL) CreateIdentity(ctx context.Context, args CreateIdentityRepoArgs) error {
+	db := s.pgxTrx.FromContext(ctx)
+
+	_, err := db.Exec(ctx, `
+		insert into user_identities(user_id, issuer, subject, email)
+		values ($1, $2, $3, $4)
+	`, args.UserID, args.Issuer, args.Subject, args.Email)
+	if err != nil {
+		return fmt.Errorf("sql create identity: %w", err)
+	}
+
+	return nil
+}
+
+func (s SQL) DeleteIdentities(ctx context.Context, userID string) error {
+	db := s.pgxTrx.FromContext(ctx)
+
+	_, err := db.Exec(ctx, `
+		delete from user_identities
+		where user_id = $1
+	`, userID)
+	if err != nil {
+		return fmt.Errorf("sql delete identities: %w", err)
+	}
+
+	return nil
+}
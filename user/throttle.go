@@ -0,0 +1,40 @@
+package user
+
+import (
+	"strings"
+	"time"
+)
+
+const (
+	// failed logins older than failedLoginWindow are forgotten
+	failedLoginWindow = 15 * time.Minute
+
+	maxFailedLoginsPerAccount = 5
+	maxFailedLoginsPerIP      = 20
+
+	loginLockBase = 30 * time.Second
+	loginLockMax  = 15 * time.Minute
+)
+
+func accountLoginKey(email string) string {
+	return "email:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func ipLoginKey(ip string) string {
+	return "ip:" + ip
+}
+
+// loginLockDuration returns how long the login is locked after the given number of
+// failures, it doubles with every failure past maxFailures up to loginLockMax
+func loginLockDuration(failures, maxFailures int) time.Duration {
+	if failures < maxFailures {
+		return 0
+	}
+
+	lock := loginLockBase
+	for i := maxFailures; i < failures && lock < loginLockMax; i++ {
+		lock *= 2
+	}
+
+	return min(lock, loginLockMax)
+}
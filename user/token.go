@@ -0,0 +1,75 @@
+package user
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// apiKeyPrefix tells api keys apart from access tokens in the Authorization header
+const apiKeyPrefix = "cs_"
+
+// generateOpaqueToken returns a random url safe token that is handed to the client,
+// only its hash is stored
+func generateOpaqueToken() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", fmt.Errorf("generate opaque token: %w", err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func generateSessionID() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", fmt.Errorf("generate session id: %w", err)
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// hashToken hashes high entropy tokens for storage, a fast hash is enough
+// since the tokens could not be brute forced
+func hashToken(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:])
+}
+
+// generateAPIKey returns a key like cs_<prefix>_<secret>, the prefix is stored in
+// plain text to find the key and to show it to the user
+func generateAPIKey() (key string, prefix string, err error) {
+	b := make([]byte, 6)
+	_, err = rand.Read(b)
+	if err != nil {
+		return "", "", fmt.Errorf("generate api key: %w", err)
+	}
+	prefix = hex.EncodeToString(b)
+
+	secret, err := generateOpaqueToken()
+	if err != nil {
+		return "", "", fmt.Errorf("generate api key: %w", err)
+	}
+
+	return apiKeyPrefix + prefix + "_" + secret, prefix, nil
+}
+
+// parseAPIKeyPrefix returns the prefix of a key made by generateAPIKey
+func parseAPIKeyPrefix(key string) (string, bool) {
+	rest, ok := strings.CutPrefix(key, apiKeyPrefix)
+	if !ok {
+		return "", false
+	}
+
+	prefix, secret, ok := strings.Cut(rest, "_")
+	if !ok || prefix == "" || secret == "" {
+		return "", false
+	}
+
+	return prefix, true
+}
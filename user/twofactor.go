@@ -0,0 +1,50 @@
+package user
+
+import (
+	"catsocial/pkg/totp"
+	"crypto/rand"
+	"encoding/base32"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	totpIssuer        = "catsocial"
+	recoveryCodeCount = 10
+	loginChallengeTTL = 5 * time.Minute
+
+	maxFailedTwoFactorLogins = 5
+)
+
+// totpOptions accepts the codes of the previous and the next period as well,
+// since the clocks of phones often drift
+var totpOptions = totp.Options{Skew: 1}
+
+func twoFactorLoginKey(userID string) string {
+	return "2fa:" + userID
+}
+
+// generateRecoveryCodes returns codes like "abcde-fghij" that could be used once
+// instead of a TOTP code
+func generateRecoveryCodes() ([]string, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	for range recoveryCodeCount {
+		b := make([]byte, 7)
+		_, err := rand.Read(b)
+		if err != nil {
+			return nil, fmt.Errorf("generate recovery codes: %w", err)
+		}
+
+		code := strings.ToLower(base32.StdEncoding.EncodeToString(b))[:10]
+		codes = append(codes, code[:5]+"-"+code[5:])
+	}
+
+	return codes, nil
+}
+
+// normalizeRecoveryCode lets users type recovery codes without the dash or in upper case
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return strings.ReplaceAll(code, "-", "")
+}
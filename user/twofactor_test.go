@@ -0,0 +1,63 @@
+package user
+
+import (
+	"catsocial/pkg/totp"
+	"context"
+	"testing"
+	"time"
+)
+
+// totpRepo keeps the last accepted counter the way SQL.UseTOTPCounter does
+type totpRepo struct {
+	repo
+	lastCounter *int64
+}
+
+func (r *totpRepo) UseTOTPCounter(ctx context.Context, userID string, counter int64) (bool, error) {
+	if r.lastCounter != nil && *r.lastCounter >= counter {
+		return false, nil
+	}
+	r.lastCounter = &counter
+	return true, nil
+}
+
+func (r *totpRepo) UseRecoveryCode(ctx context.Context, userID string, codeHash string) (bool, error) {
+	return false, nil
+}
+
+func TestUseSecondFactorReplay(t *testing.T) {
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := Service{r: &totpRepo{}}
+	counter := totp.Counter(time.Now(), totpOptions)
+
+	code := func(counter int64) string {
+		c, err := totp.Code(secret, counter, totpOptions)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	use := func(code string) bool {
+		ok, err := s.useSecondFactor(context.Background(), "1", secret, code)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ok
+	}
+
+	if !use(code(counter)) {
+		t.Fatal("current code has been rejected")
+	}
+	if use(code(counter)) {
+		t.Fatal("replayed code has been accepted")
+	}
+	if use(code(counter - 1)) {
+		t.Fatal("code older than the last accepted one has been accepted")
+	}
+	if !use(code(counter + 1)) {
+		t.Fatal("code of the next period has been rejected")
+	}
+}
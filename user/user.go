@@ -1,13 +1,119 @@
 package user
 
-import "time"
+import (
+	"catsocial/pkg/jwt"
+	"slices"
+	"time"
+)
 
 type (
 	User struct {
-		ID             int
-		Email          string
-		Name           string
-		HashedPassword string
-		CreatedAt      time.Time
+		ID              int
+		Email           string
+		Name            string
+		HashedPassword  string
+		Role            Role
+		EmailVerifiedAt *time.Time
+		BannedAt        *time.Time
+		DeletedAt       *time.Time
+		// TOTPSecret is set on enrollment, the second factor is only required
+		// once TOTPEnabledAt is set by the confirmation
+		TOTPSecret      *string
+		TOTPEnabledAt   *time.Time
+		TOTPLastCounter *int64
+		CreatedAt       time.Time
+	}
+
+	// Role decides which routes the user could access, it is carried in the access token
+	Role string
+
+	AccessClaims struct {
+		jwt.RegisteredClaims
+		UserID    string `json:"userId"`
+		SessionID string `json:"sid"`
+		Role      Role   `json:"role"`
+	}
+
+	RefreshToken struct {
+		ID        int
+		UserID    int
+		SessionID string
+		TokenHash string
+		ExpiresAt time.Time
+		RotatedAt *time.Time
+		RevokedAt *time.Time
+		CreatedAt time.Time
 	}
+
+	// Session is started by every login, its id is carried by the access tokens and
+	// shared by the refresh tokens of the session. It is active while one of its
+	// refresh tokens is neither revoked nor expired.
+	Session struct {
+		ID         string
+		UserID     int
+		UserAgent  string
+		IP         string
+		CreatedAt  time.Time
+		LastSeenAt time.Time
+	}
+
+	// UserToken is a single use token that is sent to the user by email
+	UserToken struct {
+		ID        int
+		UserID    int
+		Purpose   TokenPurpose
+		TokenHash string
+		ExpiresAt time.Time
+		UsedAt    *time.Time
+		CreatedAt time.Time
+	}
+
+	TokenPurpose string
+
+	// APIKey lets scripts call the API as the user without logging in,
+	// only the prefix and the hash of the key are stored
+	APIKey struct {
+		ID     int
+		UserID int
+		Name   string
+		Prefix string
+		// KeyHash is never returned to the client
+		KeyHash string
+		// Scopes limit what the key could do, no scopes means every scope
+		Scopes     []Scope
+		LastUsedAt *time.Time
+		RevokedAt  *time.Time
+		CreatedAt  time.Time
+	}
+
+	Scope string
+)
+
+const (
+	TokenPurposePasswordReset     TokenPurpose = "password_reset"
+	TokenPurposeEmailVerification TokenPurpose = "email_verification"
+	TokenPurposeLoginChallenge    TokenPurpose = "login_challenge"
+)
+
+const (
+	RoleUser  Role = "user"
+	RoleAdmin Role = "admin"
 )
+
+const (
+	ScopeCatRead     Scope = "cat:read"
+	ScopeCatWrite    Scope = "cat:write"
+	ScopeMatchRead   Scope = "match:read"
+	ScopeMatchWrite  Scope = "match:write"
+	ScopeProfileRead Scope = "profile:read"
+)
+
+var scopes = []Scope{ScopeCatRead, ScopeCatWrite, ScopeMatchRead, ScopeMatchWrite, ScopeProfileRead}
+
+func (s Scope) IsValid() bool {
+	return slices.Contains(scopes, s)
+}
+
+func (k APIKey) HasScope(scope Scope) bool {
+	return len(k.Scopes) == 0 || slices.Contains(k.Scopes, scope)
+}